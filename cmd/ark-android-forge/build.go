@@ -1,6 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
 	"github.com/spf13/cobra"
 
 	"github.com/koobie777/ark-android-forge/internal/android"
@@ -10,30 +17,245 @@ var (
 	buildDevice  string
 	buildTarget  string
 	buildVariant string
-	buildRepo    string
-	buildDryRun  bool
+	buildProduct string
+	buildRelease string
+	buildCombos  bool
+
+	imagesBoot       bool
+	imagesRecovery   bool
+	imagesVendorBoot bool
+	imagesInitBoot   bool
+
+	recoveryFlavor    string
+	recoveryPartition string
+	recoveryInit      bool
+	buildRepo         string
+	buildDryRun       bool
+	buildNoPatch      bool
+
+	fleetAll      bool
+	fleetRole     string
+	fleetDevices  []string
+	fleetParallel int
 )
 
 var buildCmd = &cobra.Command{
 	Use:   "build",
 	Short: "Run envsetup + lunch + m for a device",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		opts := android.BuildOptions{
-			Device:       buildDevice,
-			Target:       buildTarget,
-			Variant:      buildVariant,
-			RepoOverride: buildRepo,
-			DryRun:       buildDryRun,
-		}
-		return android.Build(cmd.Context(), appCtx.runner, appCtx.cfg, opts)
+		if buildCombos {
+			return listLunchCombos()
+		}
+		if fleetAll || fleetRole != "" || len(fleetDevices) > 0 {
+			return runFleetBuild(cmd)
+		}
+		return android.Build(cmd.Context(), appCtx.runner, appCtx.cfg, buildOptions())
+	},
+}
+
+var buildROMCmd = &cobra.Command{
+	Use:   "rom",
+	Short: "Build the full ROM via the repository entry point (m bacon, mka yaap, ...)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := android.BuildROM(cmd.Context(), appCtx.runner, appCtx.cfg, buildOptions())
+		if result.Package != "" {
+			fmt.Printf("[PACKAGE] %s\n", result.Package)
+		}
+		for _, image := range result.Images {
+			fmt.Printf("[IMAGE]   %s\n", image)
+		}
+		for _, missing := range result.Missing {
+			fmt.Printf("[MISSING] %s\n", missing)
+		}
+		return err
+	},
+}
+
+var buildImagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "Build boot/recovery/vendor_boot/init_boot images from ROM source",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var images []android.Image
+		if imagesBoot {
+			images = append(images, android.ImageBoot)
+		}
+		if imagesRecovery {
+			images = append(images, android.ImageRecovery)
+		}
+		if imagesVendorBoot {
+			images = append(images, android.ImageVendorBoot)
+		}
+		if imagesInitBoot {
+			images = append(images, android.ImageInitBoot)
+		}
+
+		results, err := android.BuildImages(cmd.Context(), appCtx.runner, appCtx.cfg, buildOptions(), images)
+		for _, result := range results {
+			fmt.Printf("[IMAGE] %-12s %10s  %s\n", result.Image, formatBytes(result.Size), result.Path)
+		}
+		return err
+	},
+}
+
+var buildRecoveryCmd = &cobra.Command{
+	Use:   "recovery",
+	Short: "Build a TWRP or OrangeFox recovery and package it with a versioned name",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := android.RecoveryOptions{
+			Build:     buildOptions(),
+			Flavor:    recoveryFlavor,
+			Partition: recoveryPartition,
+			Init:      recoveryInit,
+		}
+		// Recovery trees are normally built as eng unless asked otherwise.
+		if !cmd.Flags().Changed("variant") {
+			opts.Build.Variant = ""
+		}
+
+		result, err := android.BuildRecovery(cmd.Context(), appCtx.runner, appCtx.cfg, opts)
+		if result.Package != "" {
+			fmt.Printf("[RECOVERY] %-10s %10s  %s\n", result.Flavor, formatBytes(result.Image.Size), result.Package)
+		}
+		for _, extra := range result.Extras {
+			fmt.Printf("[EXTRA]    %s\n", extra)
+		}
+		return err
 	},
 }
 
+func buildOptions() android.BuildOptions {
+	return android.BuildOptions{
+		Device:       buildDevice,
+		Target:       buildTarget,
+		Variant:      buildVariant,
+		Product:      buildProduct,
+		Release:      buildRelease,
+		RepoOverride: buildRepo,
+		DryRun:       buildDryRun,
+		SkipPatches:  buildNoPatch,
+	}
+}
+
+// runFleetBuild builds every selected device × target and prints a matrix
+// summary. It fails if any cell failed, after all cells have run.
+func runFleetBuild(cmd *cobra.Command) error {
+	// Products and repositories are per tree; a single value cannot fit the
+	// whole fleet.
+	if buildProduct != "" || buildRepo != "" {
+		return fmt.Errorf("--product and --repo select one tree; drop them with --fleet, --role or --devices")
+	}
+	cfg := appCtx.cfg
+	devices, err := android.SelectDevices(cfg, fleetRole, fleetDevices)
+	if err != nil {
+		return err
+	}
+	var targets []string
+	for _, target := range strings.Split(buildTarget, ",") {
+		if target = strings.TrimSpace(target); target != "" && !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	jobs, err := android.PlanFleet(cfg, devices, targets)
+	if err != nil {
+		return err
+	}
+
+	opts := buildOptions()
+	// Let each entry point pick its variant default (eng for recovery).
+	if !cmd.Flags().Changed("variant") {
+		opts.Variant = ""
+	}
+	fmt.Printf("Building %d job(s) across %d device(s), %d at a time\n", len(jobs), len(devices), max(fleetParallel, 1))
+	results := android.RunFleet(cmd.Context(), appCtx.runner, cfg, jobs, opts, fleetParallel, func(result android.FleetResult) {
+		status := "pass"
+		if result.Err != nil {
+			status = "FAIL: " + result.Err.Error()
+		}
+		fmt.Printf("[%s/%s] %s (%s)\n", result.Device, result.Target, status, formatDuration(result.Duration))
+	})
+
+	var columns []string
+	cells := map[string]string{}
+	trees := map[string]string{}
+	failed := 0
+	for _, result := range results {
+		if !slices.Contains(columns, result.Target) {
+			columns = append(columns, result.Target)
+		}
+		trees[result.Device] = filepath.Base(result.Tree.Dir)
+		cell := "pass " + formatDuration(result.Duration)
+		if result.Err != nil {
+			cell = "FAIL " + formatDuration(result.Duration)
+			failed++
+		}
+		cells[result.Device+"\x00"+result.Target] = cell
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "DEVICE\tTREE\t%s\n", strings.Join(columns, "\t"))
+	for _, device := range devices {
+		row := []string{device, trees[device]}
+		for _, target := range columns {
+			row = append(row, cells[device+"\x00"+target])
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d fleet builds failed", failed, len(results))
+	}
+	return nil
+}
+
+func listLunchCombos() error {
+	tree, err := android.ResolveTree(appCtx.cfg, buildDevice, buildRepo)
+	if err != nil {
+		return err
+	}
+	info, err := android.DiscoverLunch(tree.Dir, tree.Device)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Device tree: %s\n", info.DeviceDir)
+	fmt.Printf("Products:    %s\n", strings.Join(info.Products, ", "))
+	for _, choice := range info.Choices {
+		fmt.Printf("  lunch %s\n", choice)
+	}
+	if len(info.Releases) > 0 {
+		fmt.Printf("Releases:    %s\n", strings.Join(info.Releases, ", "))
+	}
+	combo, err := android.ResolveLunch(info, tree.Repository, tree.Device, buildProduct, buildRelease, buildVariant)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Selected:    %s\n", combo)
+	return nil
+}
+
 func init() {
-	buildCmd.Flags().StringVar(&buildDevice, "device", "", "device codename to build (defaults to fleet primary)")
-	buildCmd.Flags().StringVar(&buildTarget, "target", "", "build target (recovery, bootimage, etc.)")
-	buildCmd.Flags().StringVar(&buildVariant, "variant", "userdebug", "lunch variant (user, userdebug, eng)")
-	buildCmd.Flags().StringVar(&buildRepo, "repo", "", "override repository directory inside workspace")
-	buildCmd.Flags().BoolVar(&buildDryRun, "dry-run", false, "log command without running it")
+	buildCmd.PersistentFlags().StringVar(&buildDevice, "device", "", "device codename to build (defaults to fleet primary)")
+	buildCmd.PersistentFlags().StringVar(&buildTarget, "target", "", "build target (recovery, bootimage, etc.); for 'rom' overrides the catalog entry point")
+	buildCmd.PersistentFlags().StringVar(&buildVariant, "variant", "userdebug", "lunch variant (user, userdebug, eng)")
+	buildCmd.PersistentFlags().StringVar(&buildProduct, "product", "", "lunch product (inferred from the device tree, e.g. lineage_waffle)")
+	buildCmd.PersistentFlags().StringVar(&buildRelease, "release", "", "release config for product-release-variant combos (e.g. ap2a)")
+	buildCmd.Flags().BoolVar(&fleetAll, "fleet", false, "build every fleet device; --target then takes a comma-separated list (rom, images and recovery select those builds)")
+	buildCmd.Flags().StringVar(&fleetRole, "role", "", "build the fleet devices with this role (primary, secondary, ...)")
+	buildCmd.Flags().StringSliceVar(&fleetDevices, "devices", nil, "build these devices (comma-separated codenames)")
+	buildCmd.Flags().IntVar(&fleetParallel, "parallel", 1, "number of trees to build at once in fleet mode")
+	buildCmd.Flags().BoolVar(&buildCombos, "list-combos", false, "list lunch combos discovered in the device tree and exit")
+	buildCmd.PersistentFlags().StringVar(&buildRepo, "repo", "", "override repository directory inside workspace")
+	buildCmd.PersistentFlags().BoolVar(&buildDryRun, "dry-run", false, "log command without running it")
+	buildCmd.PersistentFlags().BoolVar(&buildNoPatch, "no-patches", false, "skip applying configured patch sets before building")
+	buildImagesCmd.Flags().BoolVar(&imagesBoot, "boot", false, "build boot.img")
+	buildImagesCmd.Flags().BoolVar(&imagesRecovery, "recovery", false, "build recovery.img")
+	buildImagesCmd.Flags().BoolVar(&imagesVendorBoot, "vendor-boot", false, "build vendor_boot.img")
+	buildImagesCmd.Flags().BoolVar(&imagesInitBoot, "init-boot", false, "build init_boot.img")
+	buildRecoveryCmd.Flags().StringVar(&recoveryFlavor, "flavor", "", "recovery flavor (twrp, orangefox); defaults to the device's recovery.flavor")
+	buildRecoveryCmd.Flags().StringVar(&recoveryPartition, "partition", "", "partition carrying recovery (recovery, vendor_boot, boot)")
+	buildRecoveryCmd.Flags().BoolVar(&recoveryInit, "init", false, "repo init/sync the minimal recovery manifest if the tree is missing")
+	buildCmd.AddCommand(buildROMCmd, buildImagesCmd, buildRecoveryCmd)
 	rootCmd.AddCommand(buildCmd)
 }
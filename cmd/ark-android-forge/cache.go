@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/ccache"
+)
+
+var (
+	cacheRepo   string
+	cacheBuilds int
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Show ccache size, hit rate and per-build stats",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := appCtx.cfg
+		if !cfg.CCache.Enabled {
+			fmt.Println("ccache is disabled (set ccache.enabled in forge.yaml).")
+		}
+
+		dirs := cacheDirs()
+		if len(dirs) == 0 {
+			fmt.Println("No per-ROM caches yet; they are created by the first build of each repository.")
+		}
+		for _, dir := range dirs {
+			fmt.Printf("Cache: %s (max %s, compression %t)\n", dir, cfg.CCache.MaxSize, cfg.CCache.Compression)
+			stats, err := ccache.Snapshot(cmd.Context(), appCtx.runner, dir)
+			if err != nil {
+				fmt.Printf("  stats unavailable: %v\n", err)
+			} else {
+				fmt.Printf("  Size: %s in %d files\n", formatBytes(stats.SizeBytes), stats.Files)
+				fmt.Printf("  Hit rate: %.1f%% (%d hits, %d misses)\n", stats.HitRate(), stats.Hits(), stats.Misses)
+			}
+
+			history, err := ccache.History(dir)
+			if err != nil {
+				return err
+			}
+			if len(history) > cacheBuilds {
+				history = history[len(history)-cacheBuilds:]
+			}
+			shared := false
+			for _, build := range history {
+				mark := ""
+				if build.Shared {
+					mark, shared = " *", true
+				}
+				fmt.Printf("  %s %-10s %-10s %-9s hit %5.1f%% (%d/%d)%s\n",
+					build.Time.Local().Format("2006-01-02 15:04"), build.Device, build.Repository, build.Mode,
+					build.Delta.HitRate(), build.Delta.Hits(), build.Delta.Hits()+build.Delta.Misses, mark)
+			}
+			if shared {
+				fmt.Println("  * approximate: another build used the cache at the same time")
+			}
+		}
+		return nil
+	},
+}
+
+// cacheDirs lists the shared cache, or, when caches are split per ROM, the
+// existing cache of every repository builds may use.
+func cacheDirs() []string {
+	cfg := appCtx.cfg
+	if cacheRepo != "" || cfg.CCache.Shared {
+		return []string{ccache.Dir(cfg, cacheRepo)}
+	}
+	var dirs []string
+	for _, repo := range android.CacheRepositories(cfg) {
+		dir := ccache.Dir(cfg, repo)
+		if _, err := os.Stat(dir); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func init() {
+	cacheCmd.Flags().StringVar(&cacheRepo, "repo", "", "show the per-ROM cache of this repository")
+	cacheCmd.Flags().IntVar(&cacheBuilds, "builds", 10, "number of recent builds to show")
+	rootCmd.AddCommand(cacheCmd)
+}
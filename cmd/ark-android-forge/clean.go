@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+)
+
+var (
+	cleanDevice string
+	cleanRepo   string
+	cleanYes    bool
+	cleanDryRun bool
+)
+
+var cleanCmd = &cobra.Command{
+	Use:       "clean [light|device|full|cache]",
+	Short:     "Clean build output (installclean, product out, out/, or ccache)",
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: []string{"light", "device", "full", "cache"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mode := android.CleanLight
+		if len(args) == 1 {
+			mode = android.CleanMode(args[0])
+		}
+
+		tree, err := android.DetectTree(appCtx.cfg, cleanDevice, cleanRepo)
+		if err != nil {
+			return err
+		}
+		plan, err := android.PlanClean(appCtx.cfg, tree, mode)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Clean mode: %s\n", plan.Mode)
+		fmt.Printf("Target:     %s\n", plan.Path)
+		fmt.Printf("Reclaims:   ~%s\n", formatBytes(plan.Reclaimable))
+
+		if plan.Destructive && !cleanYes && !cleanDryRun {
+			if nonInteractive {
+				return fmt.Errorf("%s clean is destructive; re-run with --yes", plan.Mode)
+			}
+			if !confirm(fmt.Sprintf("Permanently remove %s?", plan.Path)) {
+				fmt.Println("Clean aborted.")
+				return nil
+			}
+		}
+		return android.Clean(cmd.Context(), appCtx.runner, appCtx.cfg, plan, cleanDryRun)
+	},
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func init() {
+	cleanCmd.Flags().StringVar(&cleanDevice, "device", "", "device codename (defaults to fleet primary)")
+	cleanCmd.Flags().StringVar(&cleanRepo, "repo", "", "override repository directory inside workspace")
+	cleanCmd.Flags().BoolVarP(&cleanYes, "yes", "y", false, "skip the confirmation prompt for destructive modes")
+	cleanCmd.Flags().BoolVar(&cleanDryRun, "dry-run", false, "show what would be removed without removing it")
+	rootCmd.AddCommand(cleanCmd)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/scheduler"
+)
+
+var (
+	daemonDryRun bool
+	daemonOnce   bool
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run scheduled pipelines and builds from schedules: in forge.yaml",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		d := scheduler.New(appCtx.cfg, appCtx.runner, appCtx.logger)
+		d.DryRun = daemonDryRun
+		if daemonOnce {
+			if _, err := scheduler.Validate(appCtx.cfg); err != nil {
+				return err
+			}
+			return d.Tick(cmd.Context())
+		}
+		appCtx.logger.Info().Int("schedules", len(appCtx.cfg.Schedules)).Msg("daemon: started")
+		return d.Run(cmd.Context())
+	},
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show schedules, their next run and the pending queue",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := appCtx.cfg
+		crons, err := scheduler.Validate(cfg)
+		if err != nil {
+			return err
+		}
+		state, err := scheduler.LoadState(cfg)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		d := scheduler.New(cfg, appCtx.runner, appCtx.logger)
+		for _, schedule := range cfg.Schedules {
+			what := "pipeline " + schedule.Pipeline
+			if schedule.Pipeline == "" {
+				what = fmt.Sprintf("build %s for %s", cmp.Or(schedule.Target, cfg.Build.DefaultType), strings.Join(d.Devices(schedule), ","))
+			}
+			fmt.Printf("%-16s %-14s %s\n", schedule.Name, schedule.Cron, what)
+			fmt.Printf("  next: %s\n", crons[schedule.Name].Next(now).Format("2006-01-02 15:04"))
+			if last, ok := state.Success[schedule.Name]; ok {
+				fmt.Printf("  last success: %s\n", last.At.Local().Format("2006-01-02 15:04"))
+			}
+		}
+		if len(state.Queue) == 0 {
+			fmt.Println("Queue: empty")
+			return nil
+		}
+		fmt.Println("Queue:")
+		for _, job := range state.Queue {
+			fmt.Printf("  %-16s due %s\n", job.Schedule, job.Due.Local().Format("2006-01-02 15:04"))
+		}
+		return nil
+	},
+}
+
+func init() {
+	daemonCmd.Flags().BoolVar(&daemonDryRun, "dry-run", false, "log the commands scheduled jobs would run")
+	daemonCmd.Flags().BoolVar(&daemonOnce, "once", false, "run a single tick (queue due jobs and drain the queue) and exit")
+	daemonCmd.AddCommand(daemonStatusCmd)
+	rootCmd.AddCommand(daemonCmd)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/dashboard"
+)
+
+var dashboardCmd = &cobra.Command{
+	Use:   "dashboard",
+	Short: "Serve the built-in web dashboard and the JSON API",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		server, err := newAPIServer(cmd.Context())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Dashboard: http://%s/\n", serveListen)
+		return listenAndServe(cmd.Context(), serveListen, dashboard.Handler(server))
+	},
+}
+
+func init() {
+	dashboardCmd.Flags().StringVar(&serveListen, "listen", "", "address to listen on (defaults to api.listen)")
+	dashboardCmd.Flags().StringVar(&serveToken, "token", "", "bearer token the dashboard must send (defaults to ARK_API_TOKEN or api.token)")
+	rootCmd.AddCommand(dashboardCmd)
+}
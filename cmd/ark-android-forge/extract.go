@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/ota"
+)
+
+var extractOutput string
+
+var extractCmd = &cobra.Command{
+	Use:   "extract <ota.zip> [partition...]",
+	Short: "Extract partition images from a full OTA's payload",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pkg, err := ota.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer pkg.Close()
+
+		dir := extractOutput
+		if dir == "" {
+			dir = strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+		}
+		extracted, err := ota.Extract(cmd.Context(), appCtx.runner, pkg, ota.ExtractOptions{Partitions: args[1:], Dir: dir})
+		for _, image := range extracted {
+			fmt.Printf("  %-20s %10s  %s\n", image.Name, formatBytes(image.Size), image.SHA256[:16])
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Extracted %d partition(s) to %s\n", len(extracted), dir)
+		return nil
+	},
+}
+
+func init() {
+	extractCmd.Flags().StringVar(&extractOutput, "output", "", "directory for the images (defaults to the zip's name without .zip)")
+	rootCmd.AddCommand(extractCmd)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/history"
+)
+
+var (
+	historyDevice string
+	historyMode   string
+	historySince  string
+	historyLimit  int
+	historyTrend  string
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Query the local build history",
+}
+
+var historyBuildsCmd = &cobra.Command{
+	Use:   "builds",
+	Short: "List recent builds with success rate, average duration and trend",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter, err := historyFilter()
+		if err != nil {
+			return err
+		}
+		records, err := history.Load(history.Path(appCtx.cfg))
+		if err != nil {
+			return err
+		}
+		records = history.Query(records, filter)
+		if len(records) == 0 {
+			fmt.Println("No builds recorded.")
+			return nil
+		}
+
+		recent := records
+		if historyLimit > 0 && len(recent) > historyLimit {
+			recent = recent[len(recent)-historyLimit:]
+		}
+		fmt.Println("Recent builds:")
+		for _, record := range recent {
+			fmt.Printf("  %s %-10s %-9s %-8s %8s  %s\n",
+				record.StartedAt.Local().Format("2006-01-02 15:04"), record.Device, record.Mode,
+				record.Result, formatDuration(record.Duration), record.ID)
+		}
+
+		fmt.Println("\nPer device and target:")
+		for _, summary := range history.Summarize(records) {
+			fmt.Printf("  %-10s %-24s %3d builds  %5.1f%% ok  avg %8s\n",
+				summary.Device, summary.Target, summary.Builds, summary.SuccessRate(), formatDuration(summary.AvgDuration))
+		}
+
+		points, err := history.Trend(records, historyTrend)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nTrend per %s:\n", historyTrend)
+		for _, point := range points {
+			rate := float64(point.Successes) * 100 / float64(point.Builds)
+			fmt.Printf("  %s %3d builds  %5.1f%% ok  avg %8s\n",
+				point.Period.Format("2006-01-02"), point.Builds, rate, formatDuration(point.AvgDuration))
+		}
+		return nil
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show one build with its artifacts and failure excerpt",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		records, err := history.Load(history.Path(appCtx.cfg))
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if record.ID != args[0] {
+				continue
+			}
+			fmt.Printf("Build %s: %s\n", record.ID, record.Result)
+			fmt.Printf("  Tree: %s-%s (%s)\n", record.Repository, record.Device, record.Mode)
+			fmt.Printf("  Lunch: %s, goals: %s\n", record.Combo, record.Target())
+			fmt.Printf("  Started: %s, took %s\n", record.StartedAt.Local().Format(time.RFC1123), formatDuration(record.Duration))
+			if record.Git.ManifestRev != "" {
+				fmt.Printf("  Manifest: %s\n", record.Git.ManifestRev)
+			}
+			if record.Git.Pinned != "" {
+				fmt.Printf("  Pinned manifest: %s\n", record.Git.Pinned)
+			}
+			if record.LogPath != "" {
+				fmt.Printf("  Log: %s\n", record.LogPath)
+			}
+			for _, artifact := range record.Artifacts {
+				fmt.Printf("  [ARTIFACT] %s %s\n", formatBytes(artifact.Size), artifact.Path)
+			}
+			if record.Error != "" {
+				fmt.Printf("  Error: %s\n", record.Error)
+			}
+			for _, line := range record.Excerpt {
+				fmt.Printf("  | %s\n", line)
+			}
+			return nil
+		}
+		return fmt.Errorf("no build %q in history", args[0])
+	},
+}
+
+func historyFilter() (history.Filter, error) {
+	filter := history.Filter{Device: historyDevice, Mode: historyMode}
+	if historySince == "" {
+		return filter, nil
+	}
+	since, err := parseSince(historySince, time.Now())
+	if err != nil {
+		return filter, err
+	}
+	filter.Since = since
+	return filter, nil
+}
+
+// parseSince accepts a date (2006-01-02), a day count (30d) or a Go
+// duration (12h) relative to now.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since %q (use 2006-01-02, 30d or 12h)", value)
+	}
+	return now.Add(-d), nil
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(time.Second).String()
+}
+
+func init() {
+	historyBuildsCmd.Flags().StringVar(&historyDevice, "device", "", "only builds of this device")
+	historyBuildsCmd.Flags().StringVar(&historyMode, "mode", "", "only builds of this mode (target, rom, images, recovery)")
+	historyBuildsCmd.Flags().StringVar(&historySince, "since", "", "only builds since a date (2006-01-02) or age (30d, 12h)")
+	historyBuildsCmd.Flags().IntVar(&historyLimit, "limit", 20, "number of recent builds to list")
+	historyBuildsCmd.Flags().StringVar(&historyTrend, "trend", "day", "trend bucket (day, week)")
+	historyCmd.AddCommand(historyBuildsCmd, historyShowCmd)
+	rootCmd.AddCommand(historyCmd)
+}
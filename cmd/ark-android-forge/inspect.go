@@ -0,0 +1,167 @@
+package main
+
+import (
+	"cmp"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/bootimg"
+	"github.com/koobie777/ark-android-forge/internal/ota"
+)
+
+var inspectDevice string
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Inspect build outputs",
+}
+
+var inspectOTACmd = &cobra.Command{
+	Use:   "ota <zip>",
+	Short: "Show an OTA package's metadata and payload, and verify its hashes",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pkg, err := ota.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer pkg.Close()
+
+		metadata := pkg.Metadata
+		kind := "full"
+		if pkg.Incremental() {
+			kind = "incremental"
+		}
+		fmt.Printf("Package:     %s (%s, %s)\n", pkg.Path, cmp.Or(metadata.Type, "unknown type"), kind)
+		fmt.Printf("Devices:     %s\n", cmp.Or(strings.Join(metadata.PreDevices, ", "), "-"))
+		if metadata.PreBuild != "" {
+			fmt.Printf("Pre-build:   %s\n", metadata.PreBuild)
+		}
+		fmt.Printf("Post-build:  %s\n", cmp.Or(metadata.PostBuild, "-"))
+		if metadata.PostTimestamp > 0 {
+			fmt.Printf("Built:       %s\n", time.Unix(metadata.PostTimestamp, 0).UTC().Format(time.RFC3339))
+		}
+		if metadata.PostSDKLevel != "" || metadata.PostSecurityPatch != "" {
+			fmt.Printf("SDK/patch:   %s / %s\n", cmp.Or(metadata.PostSDKLevel, "-"), cmp.Or(metadata.PostSecurityPatch, "-"))
+		}
+
+		problems := 0
+		if pkg.Payload != nil {
+			header, manifest := pkg.Payload.Header, pkg.Payload.Manifest
+			fmt.Printf("Payload:     version %d, minor %d, block size %d, manifest %s\n",
+				header.Version, manifest.MinorVersion, manifest.BlockSize, formatBytes(int64(header.ManifestSize)))
+			if manifest.PartialUpdate {
+				fmt.Println("             partial update")
+			}
+			fmt.Println()
+			fmt.Printf("  %-20s %10s %6s  %s\n", "PARTITION", "SIZE", "OPS", "SHA256")
+			for _, partition := range manifest.Partitions {
+				hash := hex.EncodeToString(partition.New.Hash)
+				if len(hash) > 16 {
+					hash = hash[:16]
+				}
+				fmt.Printf("  %-20s %10s %6d  %s\n", partition.Name, formatBytes(int64(partition.New.Size)), len(partition.Operations), hash)
+			}
+			fmt.Println()
+
+			checks, err := pkg.VerifyProperties()
+			if err != nil {
+				problems++
+				fmt.Printf("Properties:  %v\n", err)
+			}
+			for _, check := range checks {
+				if check.OK() {
+					fmt.Printf("  %-14s ok\n", check.Name)
+					continue
+				}
+				problems++
+				fmt.Printf("  %-14s MISMATCH  expected %s, got %s\n", check.Name, check.Expected, check.Actual)
+			}
+		}
+
+		if inspectDevice != "" {
+			if err := pkg.CheckDevice(inspectDevice); err != nil {
+				problems++
+				fmt.Printf("Device:      %v\n", err)
+			} else {
+				fmt.Printf("Device:      matches %s\n", inspectDevice)
+			}
+		}
+		if problems > 0 {
+			return fmt.Errorf("%s: %d problem(s) found", pkg.Path, problems)
+		}
+		return nil
+	},
+}
+
+var inspectImageCmd = &cobra.Command{
+	Use:   "image <img>",
+	Short: "Show a boot, recovery, init_boot or vendor_boot image header",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		image, err := bootimg.Open(args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Image:       %s (%s header v%d, page size %d)\n", args[0], image.Kind, image.HeaderVersion, image.PageSize)
+		if image.Name != "" {
+			fmt.Printf("Board:       %s\n", image.Name)
+		}
+		if image.OSVersion != "" || image.PatchLevel != "" {
+			fmt.Printf("OS version:  %s, patch level %s\n", cmp.Or(image.OSVersion, "-"), cmp.Or(image.PatchLevel, "-"))
+		}
+		if fingerprint := image.Fingerprint(); fingerprint != "" {
+			fmt.Printf("Fingerprint: %s\n", fingerprint)
+		}
+		if patch := image.SecurityPatch(); patch != "" && patch != image.PatchLevel {
+			fmt.Printf("AVB patch:   %s\n", patch)
+		}
+		fmt.Println()
+		for _, section := range []struct {
+			name string
+			size uint32
+		}{
+			{"kernel", image.KernelSize},
+			{"ramdisk", image.RamdiskSize},
+			{"second", image.SecondSize},
+			{"recovery dtbo", image.RecoveryDTBOSize},
+			{"dtb", image.DTBSize},
+			{"bootconfig", image.BootconfigSize},
+			{"signature", image.SignatureSize},
+		} {
+			if section.size > 0 {
+				fmt.Printf("  %-14s %10s\n", section.name, formatBytes(int64(section.size)))
+			}
+		}
+		for _, ramdisk := range image.VendorRamdisks {
+			fmt.Printf("  %-14s %10s  %s\n", "ramdisk "+cmp.Or(ramdisk.Name, "-"), formatBytes(int64(ramdisk.Size)), ramdisk.TypeName())
+		}
+		if image.Cmdline != "" {
+			fmt.Printf("\nCmdline:     %s\n", image.Cmdline)
+		}
+
+		if inspectDevice != "" {
+			built := ota.FingerprintDevice(image.Fingerprint())
+			switch {
+			case built == "":
+				fmt.Println("Device:      image has no fingerprint to check")
+			case built != inspectDevice:
+				return fmt.Errorf("%s: image fingerprint is for %s, not %s", args[0], built, inspectDevice)
+			default:
+				fmt.Printf("Device:      matches %s\n", inspectDevice)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	inspectCmd.PersistentFlags().StringVar(&inspectDevice, "device", "", "expected device codename")
+	inspectCmd.AddCommand(inspectOTACmd, inspectImageCmd)
+	rootCmd.AddCommand(inspectCmd)
+}
@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/rs/zerolog"
 )
 
 func main() {
 	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
-	if err := rootCmd.Execute(); err != nil {
+
+	// Cancel on Ctrl-C/SIGTERM so builds record their state and servers
+	// shut down cleanly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		logger.Error().Err(err).Msg("ark-android-forge execution failed")
 		os.Exit(1)
 	}
@@ -10,7 +10,7 @@ var menuCmd = &cobra.Command{
 	Use:   "menu",
 	Short: "Launch the interactive ARKFORGE command deck",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return ui.RunMenu(cmd.Context(), appCtx.cfg, appCtx.logger)
+		return ui.RunMenu(cmd.Context(), appCtx.cfg, appCtx.logger, appCtx.runner)
 	},
 }
 
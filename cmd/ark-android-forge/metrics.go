@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/metrics"
+)
+
+var metricsListen string
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Export build metrics for Prometheus",
+}
+
+var metricsServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve /metrics in the Prometheus text format",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := appCtx.cfg
+		if metricsListen == "" {
+			metricsListen = cfg.Metrics.Listen
+		}
+		collector := &metrics.Collector{
+			Config: cfg,
+			Disk:   &android.UsageScanner{TTL: 10 * time.Minute},
+		}
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", collector)
+		return listenAndServe(cmd.Context(), metricsListen, mux)
+	},
+}
+
+func init() {
+	metricsServeCmd.Flags().StringVar(&metricsListen, "listen", "", "address to listen on (defaults to metrics.listen)")
+	metricsCmd.AddCommand(metricsServeCmd)
+	rootCmd.AddCommand(metricsCmd)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/notify"
+)
+
+var notifyEvent string
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Manage build notifications",
+}
+
+var notifyTestCmd = &cobra.Command{
+	Use:   "test [target...]",
+	Short: "Send a sample notification to all or the named targets",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := appCtx.cfg
+		if err := notify.Validate(cfg); err != nil {
+			return err
+		}
+		targets := cfg.Notify.Targets
+		if len(args) > 0 {
+			targets = nil
+			for _, name := range args {
+				target := cfg.NotifyTargetByName(name)
+				if target == nil {
+					return fmt.Errorf("no notification target %q", name)
+				}
+				targets = append(targets, *target)
+			}
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("no notification targets configured (notifications.targets)")
+		}
+
+		ev, err := sampleEvent(cfg, notifyEvent)
+		if err != nil {
+			return err
+		}
+		notifier := notify.New(cfg, appCtx.logger)
+		failed := 0
+		for _, target := range targets {
+			if err := notifier.Send(cmd.Context(), target, ev); err != nil {
+				failed++
+				fmt.Printf("  %-16s %-9s FAIL  %v\n", target.Name, target.Type, err)
+				continue
+			}
+			fmt.Printf("  %-16s %-9s ok\n", target.Name, target.Type)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d notification targets failed", failed, len(targets))
+		}
+		return nil
+	},
+}
+
+// sampleEvent fakes an event of the given kind so formats can be previewed.
+func sampleEvent(cfg *config.Config, name string) (notify.Event, error) {
+	device := "device"
+	if len(cfg.Fleet) > 0 {
+		device = cfg.Fleet[0].Codename
+	}
+	ev := notify.Event{Name: name, Device: device, Target: "bacon", Duration: 2*time.Hour + 13*time.Minute}
+	switch name {
+	case notify.EventTest, notify.EventBuildStart:
+	case notify.EventSyncStart, notify.EventSyncSuccess:
+		ev.Device, ev.Target, ev.Tree = "", "", cfg.Build.Workspace
+	case notify.EventBuildSuccess:
+		ev.Artifacts = []notify.Artifact{{Name: "lineage-21.0-" + device + ".zip", Size: 1 << 30}}
+	case notify.EventSyncFailure:
+		ev.Device, ev.Target, ev.Tree = "", "", cfg.Build.Workspace
+		ev.Error = "exit status 1"
+	case notify.EventBuildFailure:
+		ev.Error = "exit status 1"
+		ev.Excerpt = []string{
+			"FAILED: out/soong/.intermediates/example/android_arm64/example.o",
+			"example.c:42:1: error: expected ';' after expression",
+			"ninja: build stopped: subcommand failed.",
+		}
+	default:
+		return notify.Event{}, fmt.Errorf("unknown event %q (want %s)", name, strings.Join([]string{
+			notify.EventTest, notify.EventBuildStart, notify.EventBuildSuccess, notify.EventBuildFailure,
+			notify.EventSyncStart, notify.EventSyncSuccess, notify.EventSyncFailure,
+		}, ", "))
+	}
+	return ev, nil
+}
+
+func init() {
+	notifyTestCmd.Flags().StringVar(&notifyEvent, "event", notify.EventTest, "sample event to send (test, build.start, build.success, build.failure, sync.*)")
+	notifyCmd.AddCommand(notifyTestCmd)
+	rootCmd.AddCommand(notifyCmd)
+}
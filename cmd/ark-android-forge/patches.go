@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/lock"
+	"github.com/koobie777/ark-android-forge/internal/patches"
+)
+
+var (
+	patchesDevice string
+	patchesRepo   string
+	patchesDryRun bool
+)
+
+var patchesCmd = &cobra.Command{
+	Use:   "patches",
+	Short: "Inspect and apply local patch sets declared in forge.yaml",
+}
+
+var patchesStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report which patches are applied, pending or conflicting",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := patchOptions()
+		if err != nil {
+			return err
+		}
+		entries, err := patches.Status(cmd.Context(), appCtx.runner, appCtx.cfg, opts)
+		printPatchEntries(entries)
+		return err
+	},
+}
+
+var patchesApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply pending patches and cherry-picks to the device tree",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := patchOptions()
+		if err != nil {
+			return err
+		}
+		if !opts.DryRun {
+			treeLock, err := lock.TryAcquire(android.LockPath(android.Tree{Dir: opts.SourceDir}))
+			if err != nil {
+				return fmt.Errorf("refusing to patch %s while a build holds the lock: %w", opts.SourceDir, err)
+			}
+			defer treeLock.Release()
+		}
+		entries, err := patches.Apply(cmd.Context(), appCtx.runner, appCtx.cfg, opts)
+		printPatchEntries(entries)
+		return err
+	},
+}
+
+func patchOptions() (patches.Options, error) {
+	tree, err := android.ResolveTree(appCtx.cfg, patchesDevice, patchesRepo)
+	if err != nil {
+		return patches.Options{}, err
+	}
+	return patches.Options{
+		SourceDir:  tree.Dir,
+		Repository: tree.Repository,
+		Device:     tree.Device,
+		DryRun:     patchesDryRun,
+	}, nil
+}
+
+func printPatchEntries(entries []patches.Entry) {
+	if len(entries) == 0 {
+		fmt.Println("No patch sets configured for this tree.")
+		return
+	}
+	for _, entry := range entries {
+		source := entry.Source
+		if entry.Kind == "patch" {
+			source = filepath.Base(source)
+		}
+		fmt.Printf("[%-8s] %-12s %-28s %-5s %s\n", entry.State, entry.Set, entry.Project, entry.Kind, source)
+		if entry.Detail != "" && entry.State != patches.StateApplied {
+			fmt.Printf("             %s\n", entry.Detail)
+		}
+	}
+}
+
+func init() {
+	patchesCmd.PersistentFlags().StringVar(&patchesDevice, "device", "", "device codename (defaults to fleet primary)")
+	patchesCmd.PersistentFlags().StringVar(&patchesRepo, "repo", "", "override repository directory inside workspace")
+	patchesApplyCmd.Flags().BoolVar(&patchesDryRun, "dry-run", false, "log git am/cherry-pick commands without running them")
+	patchesCmd.AddCommand(patchesStatusCmd, patchesApplyCmd)
+	rootCmd.AddCommand(patchesCmd)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/gerrit"
+)
+
+var (
+	pickDevice string
+	pickRepo   string
+	pickTopics []string
+	pickGerrit string
+	pickDryRun bool
+)
+
+var pickCmd = &cobra.Command{
+	Use:   "pick [change...]",
+	Short: "Cherry-pick unmerged Gerrit changes or topics into a device tree",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var numbers []int
+		for _, arg := range args {
+			number, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("invalid change number %q", arg)
+			}
+			numbers = append(numbers, number)
+		}
+
+		tree, err := android.ResolveTree(appCtx.cfg, pickDevice, pickRepo)
+		if err != nil {
+			return err
+		}
+
+		url := pickGerrit
+		if url == "" {
+			url = appCtx.cfg.Gerrit.URL
+		}
+		opts := gerrit.PickOptions{
+			SourceDir: tree.Dir,
+			Changes:   numbers,
+			Topics:    pickTopics,
+			DryRun:    pickDryRun,
+		}
+		picked, err := gerrit.Pick(cmd.Context(), appCtx.runner, appCtx.cfg, gerrit.NewClient(url), opts)
+		for _, change := range picked {
+			fmt.Printf("[PICKED] %-8d %-40s %s\n", change.Number, change.Path, change.Subject)
+		}
+		return err
+	},
+}
+
+func init() {
+	pickCmd.Flags().StringVar(&pickDevice, "device", "", "device codename (defaults to fleet primary)")
+	pickCmd.Flags().StringVar(&pickRepo, "repo", "", "override repository directory inside workspace")
+	pickCmd.Flags().StringSliceVar(&pickTopics, "topic", nil, "pick every open change in the Gerrit topic (repeatable)")
+	pickCmd.Flags().StringVar(&pickGerrit, "gerrit", "", "Gerrit base URL (defaults to gerrit.url)")
+	pickCmd.Flags().BoolVar(&pickDryRun, "dry-run", false, "resolve and fetch changes without cherry-picking")
+	rootCmd.AddCommand(pickCmd)
+}
@@ -1,23 +1,138 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/spf13/cobra"
 
 	"github.com/koobie777/ark-android-forge/internal/artifacts"
+	"github.com/koobie777/ark-android-forge/internal/signing"
 )
 
-var releasePath string
+var (
+	releasePath    string
+	releaseVersion string
+	releasePublish bool
+	releaseDryRun  bool
+)
 
 var releaseCmd = &cobra.Command{
 	Use:   "release",
-	Short: "Generate release metadata (artifacts manifest)",
+	Short: "Collect build artifacts into a versioned release with a manifest",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if publish := appCtx.cfg.Release.Publish; releasePublish && (publish.Endpoint == "" || publish.Bucket == "") {
+			return fmt.Errorf("set release.publish.endpoint and release.publish.bucket to publish")
+		}
+		// Only the upload is dry: the release is collected and written either
+		// way, since the upload plan lists its files.
+		if releaseDryRun && !releasePublish {
+			return fmt.Errorf("--dry-run only skips the upload of --publish; the release itself is always written")
+		}
+		manifest, err := artifacts.Release(cmd.Context(), appCtx.runner, appCtx.cfg, artifacts.ReleaseOptions{Version: releaseVersion})
+		if err != nil {
+			return err
+		}
+		output := releasePath
+		if output == "" {
+			output = artifacts.ManifestPath(manifest)
+		}
+		if err := artifacts.Write(output, manifest); err != nil {
+			return err
+		}
+
+		if len(manifest.Artifacts) == 0 {
+			fmt.Println("No build artifacts found in the fleet's product out directories.")
+		}
+		for _, artifact := range manifest.Artifacts {
+			fmt.Printf("  %-10s %-44s %10s  %s\n", artifact.Device, artifact.Name, formatBytes(artifact.Size), artifact.SHA256[:16])
+		}
+		if manifest.Checksums != nil && manifest.Checksums.Signature != "" {
+			fmt.Printf("Checksums signed (%s, key %s): %s\n", manifest.Checksums.Format, manifest.Checksums.KeyID, manifest.Checksums.Signature)
+		}
+		if manifest.Signing != nil {
+			fmt.Printf("OTAs checked against release key %s\n", manifest.Signing.Certificate)
+		}
+		for device, path := range manifest.Updater {
+			fmt.Printf("Updater JSON for %s: %s\n", device, path)
+		}
+		fmt.Printf("Release %s: %d artifacts, manifest %s\n", manifest.Release, len(manifest.Artifacts), output)
+
+		if !releasePublish {
+			return nil
+		}
+		if output != artifacts.ManifestPath(manifest) {
+			return fmt.Errorf("--publish needs the manifest inside the release directory; drop --output")
+		}
+		return publishRelease(cmd, manifest.Dir)
+	},
+}
+
+var releasePublishCmd = &cobra.Command{
+	Use:   "publish <release-dir>",
+	Short: "Upload an existing release to release.publish, resuming an interrupted publish",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return publishRelease(cmd, args[0])
+	},
+}
+
+func publishRelease(cmd *cobra.Command, dir string) error {
+	publish := appCtx.cfg.Release.Publish
+	published, err := artifacts.Publish(cmd.Context(), appCtx.runner, appCtx.cfg, artifacts.PublishOptions{
+		Dir:    dir,
+		DryRun: releaseDryRun,
+	})
+	for _, file := range published {
+		parts := ""
+		if file.Parts > 1 {
+			parts = fmt.Sprintf("%d parts", file.Parts)
+		}
+		fmt.Printf("  %-12s %10s %-9s %-26s %s\n", file.Action, formatBytes(file.Size), parts, file.ContentType, file.Key)
+	}
+	if err != nil {
+		return err
+	}
+	if releaseDryRun {
+		fmt.Printf("Dry run: %d files would be published to %s/%s\n", len(published), strings.TrimRight(publish.Endpoint, "/"), publish.Bucket)
+		return nil
+	}
+	fmt.Printf("Published %d files to %s/%s\n", len(published), strings.TrimRight(publish.Endpoint, "/"), publish.Bucket)
+	return nil
+}
+
+var releasePubkeyMinisign bool
+
+var releasePubkeyCmd = &cobra.Command{
+	Use:   "pubkey",
+	Short: "Print the public key release checksums are signed with",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		manifest := artifacts.Generate(appCtx.cfg)
-		return artifacts.Write(releasePath, manifest)
+		key, err := artifacts.PublicKey(appCtx.cfg.Release.Checksums)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return fmt.Errorf("set release.checksums.key to sign releases")
+		}
+		if releasePubkeyMinisign || appCtx.cfg.Release.Checksums.Sign == signing.FormatMinisign {
+			fmt.Print(key.Minisign())
+			return nil
+		}
+		encoded, err := key.PEM()
+		if err != nil {
+			return err
+		}
+		fmt.Print(encoded)
+		return nil
 	},
 }
 
 func init() {
-	releaseCmd.Flags().StringVar(&releasePath, "output", "artifacts/manifest.yaml", "path to write manifest")
+	releaseCmd.Flags().BoolVar(&releasePublish, "publish", false, "upload the release to release.publish")
+	releaseCmd.PersistentFlags().BoolVar(&releaseDryRun, "dry-run", false, "with --publish or 'release publish', list uploads without sending them (the release is still collected and written)")
+	releasePubkeyCmd.Flags().BoolVar(&releasePubkeyMinisign, "minisign", false, "print the key in minisign .pub format")
+	releaseCmd.AddCommand(releasePubkeyCmd, releasePublishCmd)
+	releaseCmd.Flags().StringVar(&releasePath, "output", "", "path to write manifest (defaults to <release.dir>/<version>/manifest.yaml)")
+	releaseCmd.Flags().StringVar(&releaseVersion, "version", "", "release name (defaults to the UTC time, e.g. 20240131-201500)")
 	rootCmd.AddCommand(releaseCmd)
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+)
+
+var resumeDryRun bool
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume [number|device]",
+	Short: "List interrupted builds or resume one with its original options",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		builds, err := android.Interrupted(appCtx.cfg)
+		if err != nil {
+			return err
+		}
+		if len(builds) == 0 {
+			fmt.Println("No interrupted builds found in the workspace.")
+			return nil
+		}
+		if len(args) == 0 {
+			printInterrupted(builds)
+			return nil
+		}
+
+		build, err := selectInterrupted(builds, args[0])
+		if err != nil {
+			return err
+		}
+		appCtx.logger.Info().
+			Str("tree", build.Tree.Dir).
+			Str("mode", build.State.Mode).
+			Str("combo", build.State.Combo).
+			Msg("resuming interrupted build")
+		return android.Resume(cmd.Context(), appCtx.runner, appCtx.cfg, build, resumeDryRun)
+	},
+}
+
+func printInterrupted(builds []android.InterruptedBuild) {
+	for i, build := range builds {
+		status := "interrupted"
+		switch {
+		case build.Running:
+			status = "running"
+		case build.Legacy:
+			status = "legacy"
+		case build.State.Status == android.StatusFailed:
+			status = "failed"
+		}
+
+		fmt.Printf("%d) %-28s [%s]\n", i+1, build.Tree.Dir, status)
+		if build.State.Mode != "" {
+			fmt.Printf("     Mode: %s | Lunch: %s | Goals: %s\n", build.State.Mode, build.State.Combo, strings.Join(build.State.Goals, " "))
+			fmt.Printf("     Started: %s | PID: %d\n", build.State.StartedAt.Format("2006-01-02 15:04"), build.State.PID)
+		}
+		if !build.LastActivity.IsZero() {
+			fmt.Printf("     Last activity: %s\n", build.LastActivity.Format("2006-01-02 15:04"))
+		}
+		if build.Progress != "" {
+			fmt.Printf("     Progress: %s\n", build.Progress)
+		} else if build.NinjaEntries > 0 {
+			fmt.Printf("     Progress: ~%d targets completed\n", build.NinjaEntries)
+		}
+		if build.State.LogPath != "" {
+			fmt.Printf("     Log: %s\n", build.State.LogPath)
+		}
+		if build.State.Error != "" {
+			fmt.Printf("     Error: %s\n", build.State.Error)
+		}
+	}
+}
+
+// selectInterrupted accepts a 1-based list index or a device codename.
+func selectInterrupted(builds []android.InterruptedBuild, choice string) (android.InterruptedBuild, error) {
+	if n, err := strconv.Atoi(choice); err == nil {
+		if n < 1 || n > len(builds) {
+			return android.InterruptedBuild{}, fmt.Errorf("no interrupted build #%d", n)
+		}
+		return builds[n-1], nil
+	}
+	for _, build := range builds {
+		if strings.EqualFold(build.Tree.Device, choice) && build.Resumable() {
+			return build, nil
+		}
+	}
+	return android.InterruptedBuild{}, fmt.Errorf("no resumable build for %q", choice)
+}
+
+func init() {
+	resumeCmd.Flags().BoolVar(&resumeDryRun, "dry-run", false, "log the resumed build command without running it")
+	rootCmd.AddCommand(resumeCmd)
+}
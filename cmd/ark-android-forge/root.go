@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"time"
 
@@ -15,9 +16,10 @@ import (
 )
 
 type appState struct {
-	cfg    *config.Config
-	logger zerolog.Logger
-	runner *execx.Runner
+	cfg         *config.Config
+	logger      zerolog.Logger
+	loggerReady bool
+	runner      *execx.Runner
 }
 
 var (
@@ -36,7 +38,7 @@ var (
 
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
-			return ui.RunMenu(ctx, appCtx.cfg, appCtx.logger)
+			return ui.RunMenu(ctx, appCtx.cfg, appCtx.logger, appCtx.runner)
 		},
 		SilenceUsage:  true,
 		SilenceErrors: true,
@@ -55,14 +57,14 @@ func init() {
 	rootCmd.PersistentFlags().BoolVar(&nonInteractive, "non-interactive", false, "disable interactive menu and require explicit sub-command")
 
 	cobra.OnInitialize(func() {
-		if (appCtx.logger == zerolog.Logger{}) {
+		if !appCtx.loggerReady {
 			initLogger()
 		}
 	})
 }
 
 func bootstrapOnce() error {
-	if (appCtx.logger == zerolog.Logger{}) {
+	if !appCtx.loggerReady {
 		initLogger()
 	}
 
@@ -82,11 +84,15 @@ func bootstrapOnce() error {
 }
 
 func initLogger() {
-	output := zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
+	appCtx.loggerReady = true
+	appCtx.logger = zerolog.New(logOutput()).With().Timestamp().Logger()
+}
+
+// logOutput is the writer behind the app logger: JSON with --json, console
+// formatting otherwise.
+func logOutput() io.Writer {
 	if jsonLogs {
-		appCtx.logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
-		return
+		return os.Stderr
 	}
-
-	appCtx.logger = zerolog.New(output).With().Timestamp().Logger()
+	return zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/pipeline"
+)
+
+var (
+	runDryRun bool
+	runResume bool
+	runFrom   string
+	runList   bool
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run <pipeline>",
+	Short: "Run a pipeline defined under pipelines: in forge.yaml",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if runList {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := appCtx.cfg
+		if runList {
+			if len(cfg.Pipelines) == 0 {
+				fmt.Println("No pipelines configured.")
+			}
+			for _, p := range cfg.Pipelines {
+				status := "never run"
+				if last, err := pipeline.LoadRun(cfg, p.Name); err == nil {
+					status = fmt.Sprintf("last run %s: %s", last.StartedAt.Local().Format("2006-01-02 15:04"), last.Status)
+				}
+				fmt.Printf("%-16s %d stage(s), %s\n", p.Name, len(p.Stages), status)
+			}
+			return nil
+		}
+		if runResume && runFrom != "" {
+			return fmt.Errorf("--resume and --from are mutually exclusive")
+		}
+
+		p, err := pipeline.Lookup(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		if runDryRun {
+			fmt.Printf("Plan for pipeline %s:\n", p.Name)
+			for i, stage := range p.Stages {
+				fmt.Printf("  %d. %-14s %s\n", i+1, pipeline.StageName(stage, i), pipeline.Describe(cfg, *p, stage))
+			}
+			fmt.Println()
+		}
+
+		opts := pipeline.Options{DryRun: runDryRun, Resume: runResume, From: runFrom}
+		run, err := pipeline.Execute(cmd.Context(), appCtx.runner, cfg, p.Name, opts, func(stage pipeline.StageRun) {
+			switch stage.Status {
+			case pipeline.StatusRunning:
+				fmt.Printf("==> [%s] %s\n", stage.Name, stage.Type)
+			case pipeline.StatusFailed:
+				fmt.Printf("[FAILED]  %s after %s (attempt %d): %s\n", stage.Name, formatDuration(stage.Duration), stage.Attempts, stage.Error)
+			default:
+				fmt.Printf("[%s] %s %s %s\n", stage.Status, stage.Name, formatDuration(stage.Duration), stage.Detail)
+			}
+		})
+		if len(run.Stages) > 0 {
+			fmt.Printf("\nPipeline %s: %s\n", run.Pipeline, run.Status)
+			for _, stage := range run.Stages {
+				fmt.Printf("  %-14s %-8s %8s\n", stage.Name, stage.Status, formatDuration(stage.Duration))
+			}
+		}
+		if err != nil && !runDryRun {
+			return fmt.Errorf("%w (re-run with --resume to continue from the failed stage)", err)
+		}
+		return err
+	},
+}
+
+func init() {
+	runCmd.Flags().BoolVar(&runDryRun, "dry-run", false, "print the plan and log commands without running them")
+	runCmd.Flags().BoolVar(&runResume, "resume", false, "continue the last run from the stage that failed")
+	runCmd.Flags().StringVar(&runFrom, "from", "", "start at this stage, skipping the ones before it")
+	runCmd.Flags().BoolVar(&runList, "list", false, "list configured pipelines and their last run")
+	rootCmd.AddCommand(runCmd)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/api"
+)
+
+var (
+	serveListen string
+	serveToken  string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the JSON HTTP API for dashboards and bots",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		server, err := newAPIServer(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return listenAndServe(cmd.Context(), serveListen, server)
+	},
+}
+
+// newAPIServer builds the API server with the token from --token,
+// ARK_API_TOKEN or api.token, generating a one-off token if none is set.
+func newAPIServer(ctx context.Context) (*api.Server, error) {
+	cfg := appCtx.cfg
+	if serveListen == "" {
+		serveListen = cfg.API.Listen
+	}
+	token := cmp.Or(serveToken, os.Getenv("ARK_API_TOKEN"), cfg.API.Token)
+	if token == "" {
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("generate api token: %w", err)
+		}
+		token = hex.EncodeToString(buf)
+		fmt.Fprintf(os.Stderr, "No api token configured; using generated token for this session: %s\n", token)
+	}
+	return api.NewServer(cfg, token, api.NewJobs(ctx, logOutput()))
+}
+
+// listenAndServe serves handler until ctx is cancelled, then shuts down
+// gracefully.
+func listenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		appCtx.logger.Info().Str("addr", addr).Msg("serve: listening")
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("serve: %w", err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveListen, "listen", "", "address to listen on (defaults to api.listen)")
+	serveCmd.Flags().StringVar(&serveToken, "token", "", "bearer token clients must send (defaults to ARK_API_TOKEN or api.token)")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/secret"
+	"github.com/koobie777/ark-android-forge/internal/signing"
+)
+
+var (
+	signDevice  string
+	signRepo    string
+	signDryRun  bool
+	keygenNames []string
+)
+
+var signCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Sign the newest target-files package with the release keys and build a signed OTA",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := android.Sign(cmd.Context(), appCtx.runner, appCtx.cfg, android.SignOptions{
+			Device:       signDevice,
+			RepoOverride: signRepo,
+			DryRun:       signDryRun,
+		})
+		if err != nil {
+			return err
+		}
+		if signDryRun {
+			return nil
+		}
+		fmt.Printf("Signed target-files: %s\n", result.TargetFiles)
+		fmt.Printf("Signed OTA:          %s\n", result.Package)
+		fmt.Printf("Release key:         %s\n", result.Certificate)
+		return nil
+	},
+}
+
+var signKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate a make_key compatible key set in signing.keys",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := appCtx.cfg
+		if cfg.Signing.Keys == "" {
+			return fmt.Errorf("set signing.keys to the directory the keys should go to")
+		}
+		password, err := secret.Resolve(cfg.Signing.Password)
+		if err != nil {
+			return fmt.Errorf("signing password: %w", err)
+		}
+		names, err := signing.Generate(cmd.Context(), appCtx.runner, signing.GenerateOptions{
+			Dir:      cfg.Signing.Keys,
+			Names:    keygenNames,
+			Subject:  cfg.Signing.Subject,
+			Password: password,
+		})
+		for _, name := range names {
+			fmt.Printf("  %s\n", signing.KeyPath(cfg.Signing.Keys, name))
+		}
+		if err != nil {
+			return err
+		}
+		if password.Empty() {
+			fmt.Println("Keys are not password protected; set signing.password to encrypt new key sets.")
+		}
+		cert, err := signing.LoadCertificate(signing.CertPath(cfg.Signing.Keys, signing.ReleaseKey))
+		if err == nil {
+			fmt.Printf("Release key: %s\n", signing.Fingerprint(cert))
+		}
+		fmt.Printf("Generated %d keys in %s. Back them up: builds signed with them can only be updated by them.\n", len(names), cfg.Signing.Keys)
+		return nil
+	},
+}
+
+func init() {
+	signCmd.Flags().StringVar(&signDevice, "device", "", "device codename to sign (defaults to fleet primary)")
+	signCmd.Flags().StringVar(&signRepo, "repo", "", "override repository directory inside workspace")
+	signCmd.Flags().BoolVar(&signDryRun, "dry-run", false, "log commands without running them")
+	signKeygenCmd.Flags().StringSliceVar(&keygenNames, "names", nil, "keys to generate (defaults to the LineageOS key set)")
+	signCmd.AddCommand(signKeygenCmd)
+	rootCmd.AddCommand(signCmd)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koobie777/ark-android-forge/internal/artifacts"
+	"github.com/koobie777/ark-android-forge/internal/signing"
+)
+
+var verifyPublicKey string
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <release-dir>",
+	Short: "Check a release directory against its manifest, checksums and signature",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var publicKey *signing.PublicKey
+		if verifyPublicKey != "" {
+			key, err := signing.LoadPublicKey(verifyPublicKey)
+			if err != nil {
+				return err
+			}
+			publicKey = &key
+		} else {
+			key, err := artifacts.PublicKey(appCtx.cfg.Release.Checksums)
+			if err != nil {
+				return err
+			}
+			publicKey = key
+		}
+
+		findings, err := artifacts.Verify(args[0], publicKey)
+		if err != nil {
+			return err
+		}
+		problems := 0
+		for _, finding := range findings {
+			if finding.OK() {
+				fmt.Printf("  %-60s ok\n", finding.File)
+				continue
+			}
+			problems++
+			fmt.Printf("  %-60s FAILED  %s\n", finding.File, finding.Problem)
+		}
+		if publicKey != nil {
+			fmt.Printf("Public key: %s\n", publicKey.KeyID())
+		}
+		if problems > 0 {
+			return fmt.Errorf("%s: %d problem(s) found", args[0], problems)
+		}
+		fmt.Printf("%s: %d files verified\n", args[0], len(findings))
+		return nil
+	},
+}
+
+func init() {
+	verifyCmd.Flags().StringVar(&verifyPublicKey, "public-key", "", "PEM or minisign public key (defaults to release.checksums.publicKey)")
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -5,70 +5,283 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/koobie777/ark-android-forge/internal/ccache"
 	"github.com/koobie777/ark-android-forge/internal/config"
 	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/lock"
+	"github.com/koobie777/ark-android-forge/internal/notify"
+	"github.com/koobie777/ark-android-forge/internal/patches"
 )
 
-// BuildOptions describe how to launch a build.
+// BuildOptions describe how to launch a build. TreeDir, when set, builds in
+// that tree instead of <workspace>/<repo>-<device> (fleet devices sharing a ROM).
 type BuildOptions struct {
 	Device       string
 	Target       string
 	Variant      string
+	Product      string
+	Release      string
 	RepoOverride string
+	TreeDir      string
 	DryRun       bool
+	SkipPatches  bool
+	Env          map[string]string
 }
 
 // Build runs envsetup + lunch + m/mka for the requested device.
 func Build(ctx context.Context, runner *execx.Runner, cfg *config.Config, opts BuildOptions) error {
+	plan, err := prepareBuild(ctx, runner, cfg, opts)
+	if err != nil {
+		return err
+	}
+	if plan.opts.Target == "" {
+		plan.opts.Target = cfg.Build.DefaultType
+	}
+	// Resume rebuilds the recorded target, not whatever the default is then.
+	plan.state.Options.Target = plan.opts.Target
+	plan.state.Mode = ModeTarget
+	return runGoals(ctx, runner, cfg, plan, "m", plan.opts.Target)
+}
+
+// buildPlan is a validated build: resolved tree, lunch combo and options.
+type buildPlan struct {
+	tree  Tree
+	combo LunchCombo
+	opts  BuildOptions
+	state BuildState
+}
+
+// ProductOut returns the product output directory of a tree.
+func ProductOut(tree Tree) string {
+	return filepath.Join(tree.Dir, "out", "target", "product", tree.Device)
+}
+
+// prepareBuild resolves the tree and lunch combo, so every build mode fails
+// early on the same checks. Patch sets are applied later by runGoals, under
+// the tree lock.
+func prepareBuild(ctx context.Context, runner *execx.Runner, cfg *config.Config, opts BuildOptions) (buildPlan, error) {
 	if runner == nil {
-		return fmt.Errorf("runner is nil")
+		return buildPlan{}, fmt.Errorf("runner is nil")
 	}
 	if cfg == nil {
-		return fmt.Errorf("config is nil")
+		return buildPlan{}, fmt.Errorf("config is nil")
 	}
 
-	if opts.Device == "" {
-		if len(cfg.Fleet) > 0 {
-			opts.Device = cfg.Fleet[0].Codename
-		} else {
-			return fmt.Errorf("device required")
-		}
+	var tree Tree
+	var err error
+	if opts.TreeDir != "" {
+		tree, err = ResolveTree(cfg, opts.Device, opts.RepoOverride)
+		tree.Dir = opts.TreeDir
+	} else {
+		tree, err = DetectTree(cfg, opts.Device, opts.RepoOverride)
 	}
-	if opts.Target == "" {
-		opts.Target = cfg.Build.DefaultType
+	if err != nil {
+		return buildPlan{}, err
 	}
+	opts.Device = tree.Device
 	if opts.Variant == "" {
 		opts.Variant = "userdebug"
 	}
 
-	repoName := opts.RepoOverride
-	if repoName == "" {
-		if device := cfg.DeviceByCodename(opts.Device); device != nil && device.Repository != "" {
-			repoName = device.Repository
+	sourceDir := tree.Dir
+	envsetup := filepath.Join(sourceDir, "build", "envsetup.sh")
+	if _, err := os.Stat(envsetup); err != nil {
+		return buildPlan{}, fmt.Errorf("envsetup missing in %s: %w", sourceDir, err)
+	}
+
+	info, err := DiscoverLunch(sourceDir, opts.Device)
+	if err != nil {
+		return buildPlan{}, err
+	}
+	combo, err := ResolveLunch(info, tree.Repository, opts.Device, opts.Product, opts.Release, opts.Variant)
+	if err != nil {
+		return buildPlan{}, err
+	}
+
+	return buildPlan{tree: tree, combo: combo, opts: opts, state: BuildState{Options: opts}}, nil
+}
+
+// CommandTimeout bounds build, sync and signing commands by build.timeout.
+// Without one they run until cancelled: a clean ROM build takes hours.
+func CommandTimeout(cfg *config.Config) time.Duration {
+	if cfg.Build.Timeout > 0 {
+		return cfg.Build.Timeout
+	}
+	return execx.NoTimeout
+}
+
+// runGoals sources envsetup, runs lunch and invokes make (m or mka) with the
+// goals in a single soong invocation. Real runs hold the tree and host locks,
+// are tracked in out/build_in_progress and teed to a log under
+// out/arkforge-logs.
+func runGoals(ctx context.Context, runner *execx.Runner, cfg *config.Config, plan buildPlan, command string, goals ...string) error {
+	script := soongScript(plan.combo, command, goals, cfg.Jobs)
+
+	env := map[string]string{
+		"ARK_COMMANDER": cfg.Commander,
+	}
+	cacheEnv, err := ccache.Env(cfg, plan.tree.Repository)
+	if err != nil {
+		return err
+	}
+	for k, v := range cacheEnv {
+		env[k] = v
+	}
+	for k, v := range plan.opts.Env {
+		env[k] = v
+	}
+
+	cmd := execx.Command{
+		Name:    "bash",
+		Args:    []string{"-lc", script},
+		Dir:     plan.tree.Dir,
+		DryRun:  plan.opts.DryRun,
+		Env:     env,
+		Timeout: CommandTimeout(cfg),
+	}
+	if plan.opts.DryRun {
+		if err := applyPatches(ctx, runner, cfg, plan); err != nil {
+			return err
+		}
+		return runner.Run(ctx, cmd)
+	}
+
+	treeLock, err := lock.TryAcquire(LockPath(plan.tree))
+	if err != nil {
+		return fmt.Errorf("another build is running in %s: %w", plan.tree.Dir, err)
+	}
+	defer treeLock.Release()
+	releaseHost, err := AcquireHostLock()
+	if err != nil {
+		return err
+	}
+	defer releaseHost()
+	if err := applyPatches(ctx, runner, cfg, plan); err != nil {
+		return err
+	}
+
+	state := plan.state
+	state.Combo = plan.combo.String()
+	state.Goals = goals
+	state.StartedAt = time.Now().UTC()
+	state.PID = os.Getpid()
+	state.Status = StatusRunning
+	logDir := filepath.Join(plan.tree.Dir, "out", "arkforge-logs")
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		return fmt.Errorf("prepare log dir: %w", err)
+	}
+	state.LogPath = filepath.Join(logDir, fmt.Sprintf("%s-%s.log", state.StartedAt.Format("20060102-150405"), state.Mode))
+	if err := writeState(plan.tree, state); err != nil {
+		return err
+	}
+	cmd.LogPath = state.LogPath
+
+	if cfg.CCache.Enabled {
+		if err := ccache.Configure(ctx, runner, cfg, plan.tree.Repository); err != nil {
+			return err
+		}
+		cacheDir := ccache.Dir(cfg, plan.tree.Repository)
+		done := ccache.Track(cacheDir)
+		if before, err := ccache.Snapshot(ctx, runner, cacheDir); err == nil {
+			defer recordCacheStats(ctx, runner, cacheDir, plan.tree, state.Mode, before, done)
 		} else {
-			repoName = "android"
+			defer done()
 		}
 	}
 
-	sourceDir := filepath.Join(cfg.Build.Workspace, fmt.Sprintf("%s-%s", repoName, opts.Device))
-	envsetup := filepath.Join(sourceDir, "build", "envsetup.sh")
-	if _, err := os.Stat(envsetup); err != nil {
-		return fmt.Errorf("envsetup missing in %s: %w", sourceDir, err)
+	sendNotification(ctx, runner, cfg, notify.Event{
+		Name:   notify.EventBuildStart,
+		Device: plan.tree.Device,
+		Target: strings.Join(goals, " "),
+		Tree:   plan.tree.Dir,
+	})
+	err = runner.Run(ctx, cmd)
+	record := recordHistory(ctx, runner, cfg, plan, state, err)
+	sendNotification(ctx, runner, cfg, buildEvent(record, plan.tree))
+	if err != nil {
+		state.Status = StatusFailed
+		state.Error = err.Error()
+		if werr := writeState(plan.tree, state); werr != nil {
+			return fmt.Errorf("%w (updating build state also failed: %v)", err, werr)
+		}
+		return err
+	}
+	if err := os.Remove(StatePath(plan.tree)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("clear build state: %w", err)
 	}
+	return nil
+}
+
+// applyPatches applies the tree's patch sets unless the build skips them.
+// Real builds call it holding the tree lock, so two builds never patch the
+// same tree at once.
+func applyPatches(ctx context.Context, runner *execx.Runner, cfg *config.Config, plan buildPlan) error {
+	if plan.opts.SkipPatches {
+		return nil
+	}
+	opts := patches.Options{
+		SourceDir:  plan.tree.Dir,
+		Repository: plan.tree.Repository,
+		Device:     plan.tree.Device,
+		DryRun:     plan.opts.DryRun,
+	}
+	if _, err := patches.Apply(ctx, runner, cfg, opts); err != nil {
+		return fmt.Errorf("apply patches: %w", err)
+	}
+	return nil
+}
 
-	script := fmt.Sprintf("set -euo pipefail; source build/envsetup.sh && lunch %s-%s && m %s -j%d",
-		opts.Device, opts.Variant, opts.Target, cfg.Jobs)
+func soongScript(combo LunchCombo, command string, goals []string, jobs int) string {
+	return fmt.Sprintf("set -euo pipefail; source build/envsetup.sh && lunch %s && %s %s -j%d",
+		combo, command, strings.Join(goals, " "), jobs)
+}
 
-	cmd := execx.Command{
-		Name:   "bash",
-		Args:   []string{"-lc", script},
-		Dir:    sourceDir,
-		DryRun: opts.DryRun,
-		Env: map[string]string{
-			"ARK_COMMANDER": cfg.Commander,
-		},
+// recordCacheStats stores the ccache delta of a build, marked shared when a
+// parallel build used the same cache. Stats are informational, so failures
+// are ignored rather than failing the build.
+func recordCacheStats(ctx context.Context, runner *execx.Runner, cacheDir string, tree Tree, mode string, before ccache.Stats, done func() bool) {
+	after, err := ccache.Snapshot(ctx, runner, cacheDir)
+	shared := done()
+	if err != nil {
+		return
 	}
+	_ = ccache.Record(cacheDir, ccache.BuildStats{
+		Time:       time.Now().UTC(),
+		Device:     tree.Device,
+		Repository: tree.Repository,
+		Mode:       mode,
+		Delta:      after.Sub(before),
+		Before:     before,
+		After:      after,
+		Shared:     shared,
+	})
+}
 
-	return runner.Run(ctx, cmd)
+// CacheRepositories lists the repositories per-ROM caches may be keyed by:
+// the fleet's, the default of a plain build and those of workspace trees.
+func CacheRepositories(cfg *config.Config) []string {
+	seen := map[string]bool{}
+	var repos []string
+	add := func(repo string) {
+		if repo != "" && !seen[repo] {
+			seen[repo] = true
+			repos = append(repos, repo)
+		}
+	}
+	for _, device := range cfg.Fleet {
+		add(device.Repository)
+	}
+	if tree, err := ResolveTree(cfg, "", ""); err == nil {
+		add(tree.Repository)
+	}
+	add("android")
+	if trees, err := WorkspaceTrees(cfg, ""); err == nil {
+		for _, tree := range trees {
+			add(tree.Repository)
+		}
+	}
+	return repos
 }
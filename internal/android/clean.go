@@ -0,0 +1,174 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/koobie777/ark-android-forge/internal/ccache"
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/lock"
+)
+
+// CleanMode selects how much of a tree's build output is removed.
+type CleanMode string
+
+const (
+	// CleanLight runs m installclean, dropping installed files but keeping
+	// intermediates.
+	CleanLight CleanMode = "light"
+	// CleanDevice removes out/target/product/<device>.
+	CleanDevice CleanMode = "device"
+	// CleanFull removes the whole out/ directory.
+	CleanFull CleanMode = "full"
+	// CleanCache clears the ccache used by the tree.
+	CleanCache CleanMode = "cache"
+)
+
+// CleanPlan describes what a clean will remove before it runs.
+type CleanPlan struct {
+	Mode        CleanMode
+	Tree        Tree
+	Path        string
+	Reclaimable int64
+	Destructive bool
+}
+
+// PlanClean resolves the paths a clean touches and how much space it frees.
+// Light cleans are estimated from the product out dir minus intermediates.
+func PlanClean(cfg *config.Config, tree Tree, mode CleanMode) (CleanPlan, error) {
+	plan := CleanPlan{Mode: mode, Tree: tree, Destructive: mode != CleanLight}
+
+	var err error
+	switch mode {
+	case CleanLight:
+		plan.Path = ProductOut(tree)
+		plan.Reclaimable, err = installedSize(plan.Path)
+	case CleanDevice:
+		plan.Path = ProductOut(tree)
+		plan.Reclaimable, err = DirSize(plan.Path)
+	case CleanFull:
+		plan.Path = filepath.Join(tree.Dir, "out")
+		plan.Reclaimable, err = DirSize(plan.Path)
+	case CleanCache:
+		plan.Path = ccache.Dir(cfg, tree.Repository)
+		plan.Reclaimable, err = DirSize(plan.Path)
+	default:
+		return CleanPlan{}, fmt.Errorf("unknown clean mode %q (light, device, full, cache)", mode)
+	}
+	if err != nil {
+		return CleanPlan{}, fmt.Errorf("measure %s: %w", plan.Path, err)
+	}
+	return plan, nil
+}
+
+// Clean executes a plan while holding the tree's build lock, so it refuses
+// to run while a build is in progress.
+func Clean(ctx context.Context, runner *execx.Runner, cfg *config.Config, plan CleanPlan, dryRun bool) error {
+	if runner == nil {
+		return fmt.Errorf("runner is nil")
+	}
+	treeLock, err := lock.TryAcquire(LockPath(plan.Tree))
+	if err != nil {
+		return fmt.Errorf("refusing to clean %s while a build holds the lock: %w", plan.Tree.Dir, err)
+	}
+	defer treeLock.Release()
+
+	switch plan.Mode {
+	case CleanLight:
+		return installClean(ctx, runner, cfg, plan.Tree, dryRun)
+	case CleanDevice, CleanFull:
+		if dryRun {
+			return runner.Run(ctx, execx.Command{Name: "rm", Args: []string{"-rf", plan.Path}, DryRun: true})
+		}
+		if err := os.RemoveAll(plan.Path); err != nil {
+			return fmt.Errorf("remove %s: %w", plan.Path, err)
+		}
+		return nil
+	case CleanCache:
+		if err := cacheIdle(cfg, plan); err != nil {
+			return err
+		}
+		return ccache.Clear(ctx, runner, plan.Path, dryRun)
+	}
+	return fmt.Errorf("unknown clean mode %q", plan.Mode)
+}
+
+// cacheIdle refuses to clear a cache other builds may be using: one another
+// tree of the workspace is building with, one outside the workspace (other
+// workspaces may share it, and their builds cannot be seen from here), or
+// any cache while another process holds the host build lock.
+func cacheIdle(cfg *config.Config, plan CleanPlan) error {
+	workspace, err := filepath.Abs(cfg.Build.Workspace)
+	if err != nil {
+		return fmt.Errorf("resolve workspace: %w", err)
+	}
+	if rel, err := filepath.Rel(workspace, plan.Path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("refusing to clear %s: it is outside the workspace and may be shared with other workspaces; clear it by hand with CCACHE_DIR=%s ccache --clear once nothing builds", plan.Path, plan.Path)
+	}
+	// A daemon running a clean stage holds the host lock itself.
+	if hostLock := HostLockPath(); lock.Held(hostLock) && lock.Holder(hostLock) != os.Getpid() {
+		return fmt.Errorf("refusing to clear %s while a build runs on this host (%s)", plan.Path, hostLock)
+	}
+	trees, err := WorkspaceTrees(cfg, "")
+	if err != nil {
+		return err
+	}
+	for _, tree := range trees {
+		if tree.Dir == plan.Tree.Dir || ccache.Dir(cfg, tree.Repository) != plan.Path {
+			continue
+		}
+		if lock.Held(LockPath(tree)) {
+			return fmt.Errorf("refusing to clear %s while a build in %s uses it", plan.Path, tree.Dir)
+		}
+	}
+	return nil
+}
+
+func installClean(ctx context.Context, runner *execx.Runner, cfg *config.Config, tree Tree, dryRun bool) error {
+	info, err := DiscoverLunch(tree.Dir, tree.Device)
+	if err != nil {
+		return err
+	}
+	combo, err := ResolveLunch(info, tree.Repository, tree.Device, "", "", "userdebug")
+	if err != nil {
+		return err
+	}
+	cmd := execx.Command{
+		Name:   "bash",
+		Args:   []string{"-lc", soongScript(combo, "m", []string{"installclean"}, cfg.Jobs)},
+		Dir:    tree.Dir,
+		DryRun: dryRun,
+		Env: map[string]string{
+			"ARK_COMMANDER": cfg.Commander,
+		},
+	}
+	return runner.Run(ctx, cmd)
+}
+
+// installedSize approximates what installclean frees: everything in the
+// product out dir except obj/ and symbols/ intermediates.
+func installedSize(productOut string) (int64, error) {
+	entries, err := os.ReadDir(productOut)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		if entry.Name() == "obj" || entry.Name() == "symbols" || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		size, err := DirSize(filepath.Join(productOut, entry.Name()))
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package android
+
+import "syscall"
+
+// DiskSpace returns the free and total bytes of the filesystem holding path.
+func DiskSpace(path string) (free, size int64) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0
+	}
+	return int64(st.Bavail) * int64(st.Bsize), int64(st.Blocks) * int64(st.Bsize)
+}
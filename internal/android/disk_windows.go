@@ -0,0 +1,8 @@
+//go:build windows
+
+package android
+
+// DiskSpace is not reported on Windows.
+func DiskSpace(path string) (free, size int64) {
+	return 0, 0
+}
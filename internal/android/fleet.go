@@ -0,0 +1,181 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+)
+
+// Fleet targets that map to build entry points; any other target is passed
+// to m like 'build --target'.
+const (
+	FleetROM      = "rom"
+	FleetImages   = "images"
+	FleetRecovery = "recovery"
+)
+
+// FleetJob is one device × target cell of a batch build.
+type FleetJob struct {
+	Device string
+	Target string
+	Tree   Tree
+}
+
+// FleetResult is the outcome of a fleet job.
+type FleetResult struct {
+	FleetJob
+	Duration time.Duration
+	Err      error
+}
+
+// SelectDevices returns the codenames to batch build: the explicit list if
+// given, otherwise the fleet filtered by role (empty role: whole fleet).
+func SelectDevices(cfg *config.Config, role string, devices []string) ([]string, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config is nil")
+	}
+	if len(devices) > 0 {
+		return devices, nil
+	}
+	var selected []string
+	for _, device := range cfg.Fleet {
+		if role == "" || strings.EqualFold(device.Role, role) {
+			selected = append(selected, device.Codename)
+		}
+	}
+	if len(selected) == 0 {
+		if role != "" {
+			return nil, fmt.Errorf("no fleet devices with role %q", role)
+		}
+		return nil, fmt.Errorf("fleet is empty")
+	}
+	return selected, nil
+}
+
+// PlanFleet expands devices × targets into jobs. A device whose own tree is
+// not synced reuses the tree of another device on the same ROM, so one sync
+// can serve several devices.
+func PlanFleet(cfg *config.Config, devices, targets []string) ([]FleetJob, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config is nil")
+	}
+	if len(targets) == 0 {
+		targets = []string{cfg.Build.DefaultType}
+	}
+
+	trees := map[string]Tree{}
+	shared := map[string]string{}
+	for _, device := range devices {
+		tree, err := DetectTree(cfg, device, "")
+		if err != nil {
+			return nil, err
+		}
+		trees[device] = tree
+		if synced(tree) && shared[tree.Repository] == "" {
+			shared[tree.Repository] = tree.Dir
+		}
+	}
+
+	var jobs []FleetJob
+	for _, device := range devices {
+		tree := trees[device]
+		if !synced(tree) && shared[tree.Repository] != "" {
+			tree.Dir = shared[tree.Repository]
+		}
+		for _, target := range targets {
+			jobs = append(jobs, FleetJob{Device: device, Target: target, Tree: tree})
+		}
+	}
+	return jobs, nil
+}
+
+// RunFleet runs the jobs, continuing past failures. Jobs sharing a tree run
+// one after another; up to parallel trees build at once. Results keep the
+// job order. done, if set, is called as each job finishes.
+func RunFleet(ctx context.Context, runner *execx.Runner, cfg *config.Config, jobs []FleetJob, opts BuildOptions, parallel int, done func(FleetResult)) []FleetResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	groups := map[string][]int{}
+	var order []string
+	for i, job := range jobs {
+		key := job.Tree.Dir
+		if job.Target == FleetRecovery {
+			key = "recovery:" + job.Device
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	results := make([]FleetResult, len(jobs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	for _, key := range order {
+		wg.Add(1)
+		go func(indexes []int) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			for _, i := range indexes {
+				result := FleetResult{FleetJob: jobs[i]}
+				if err := ctx.Err(); err != nil {
+					result.Err = err
+				} else {
+					start := time.Now()
+					result.Err = runFleetJob(ctx, runner, cfg, jobs[i], opts)
+					result.Duration = time.Since(start)
+				}
+				results[i] = result
+				if done != nil {
+					mu.Lock()
+					done(result)
+					mu.Unlock()
+				}
+			}
+		}(groups[key])
+	}
+	wg.Wait()
+	return results
+}
+
+func runFleetJob(ctx context.Context, runner *execx.Runner, cfg *config.Config, job FleetJob, opts BuildOptions) error {
+	opts.Device = job.Device
+	opts.RepoOverride = job.Tree.Repository
+	opts.TreeDir = job.Tree.Dir
+	// The fleet target selects the entry point; each one then builds its
+	// own catalog goal. Only plain make goals are passed through.
+	opts.Target = ""
+
+	switch job.Target {
+	case FleetROM:
+		_, err := BuildROM(ctx, runner, cfg, opts)
+		return err
+	case FleetImages:
+		_, err := BuildImages(ctx, runner, cfg, opts, nil)
+		return err
+	case FleetRecovery:
+		opts.RepoOverride = ""
+		opts.TreeDir = ""
+		_, err := BuildRecovery(ctx, runner, cfg, RecoveryOptions{Build: opts})
+		return err
+	}
+	opts.Target = job.Target
+	return Build(ctx, runner, cfg, opts)
+}
+
+func synced(tree Tree) bool {
+	_, err := os.Stat(filepath.Join(tree.Dir, "build", "envsetup.sh"))
+	return err == nil
+}
@@ -0,0 +1,127 @@
+package android
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/history"
+)
+
+// excerptLines bounds the failure excerpt kept per build.
+const excerptLines = 30
+
+var failureLineRegexp = regexp.MustCompile(`(^FAILED: |error:|^ninja: build stopped)`)
+
+// recordHistory appends a finished build to the history store and returns
+// the record. History is informational, so failures are ignored rather than
+// failing the build.
+func recordHistory(ctx context.Context, runner *execx.Runner, cfg *config.Config, plan buildPlan, state BuildState, runErr error) history.Record {
+	finished := time.Now().UTC()
+	record := history.Record{
+		ID:         state.StartedAt.Format("20060102-150405") + "-" + plan.tree.Device + "-" + state.Mode,
+		StartedAt:  state.StartedAt,
+		FinishedAt: finished,
+		Duration:   finished.Sub(state.StartedAt),
+		Device:     plan.tree.Device,
+		Repository: plan.tree.Repository,
+		Mode:       state.Mode,
+		Combo:      state.Combo,
+		Goals:      state.Goals,
+		Variant:    plan.combo.Variant,
+		Result:     history.ResultSuccess,
+		Git:        gitSnapshot(ctx, runner, plan.tree, state),
+		LogPath:    state.LogPath,
+	}
+	record.Host, _ = os.Hostname()
+	if runErr != nil {
+		record.Result = history.ResultFailure
+		record.Error = runErr.Error()
+		record.Excerpt = failureExcerpt(state.LogPath)
+	} else {
+		record.Artifacts = builtArtifacts(ProductOut(plan.tree), state.StartedAt)
+	}
+	_ = history.Append(history.Path(cfg), record)
+	return record
+}
+
+// gitSnapshot records the manifest revision and, when repo is available, a
+// pinned manifest next to the build log so the sources can be reproduced.
+func gitSnapshot(ctx context.Context, runner *execx.Runner, tree Tree, state BuildState) history.GitSnapshot {
+	var snapshot history.GitSnapshot
+	manifests := filepath.Join(tree.Dir, ".repo", "manifests")
+	if out, err := runner.Output(ctx, execx.Command{Name: "git", Args: []string{"-C", manifests, "rev-parse", "HEAD"}}); err == nil {
+		snapshot.ManifestRev = strings.TrimSpace(out)
+	}
+	if state.LogPath == "" {
+		return snapshot
+	}
+	pinned := strings.TrimSuffix(state.LogPath, ".log") + "-manifest.xml"
+	if _, err := runner.Output(ctx, execx.Command{Name: "repo", Args: []string{"manifest", "-r", "-o", pinned}, Dir: tree.Dir}); err == nil {
+		snapshot.Pinned = pinned
+	}
+	return snapshot
+}
+
+// builtArtifacts lists the images and zips in productOut written since the
+// build started.
+func builtArtifacts(productOut string, since time.Time) []history.Artifact {
+	entries, err := os.ReadDir(productOut)
+	if err != nil {
+		return nil
+	}
+	var artifacts []history.Artifact
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || (!strings.HasSuffix(name, ".img") && !strings.HasSuffix(name, ".zip")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().Before(since) {
+			continue
+		}
+		artifacts = append(artifacts, history.Artifact{Path: filepath.Join(productOut, name), Size: info.Size()})
+	}
+	return artifacts
+}
+
+// failureExcerpt returns the log lines from the first ninja failure, or the
+// last lines of the log when no failure marker is found.
+func failureExcerpt(logPath string) []string {
+	if logPath == "" {
+		return nil
+	}
+	file, err := os.Open(logPath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	const tail = 256 * 1024
+	if info, err := file.Stat(); err == nil && info.Size() > tail {
+		_, _ = file.Seek(info.Size()-tail, 0)
+	}
+
+	var lines []string
+	first := -1
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first < 0 && failureLineRegexp.MatchString(line) {
+			first = len(lines)
+		}
+		lines = append(lines, line)
+	}
+
+	if first >= 0 {
+		return lines[first:min(first+excerptLines, len(lines))]
+	}
+	return lines[max(0, len(lines)-excerptLines):]
+}
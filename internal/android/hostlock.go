@@ -0,0 +1,52 @@
+package android
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/koobie777/ark-android-forge/internal/lock"
+)
+
+// HostLockPath is the host-wide build lock in the temp dir. Real builds,
+// signing runs and scheduler daemons running jobs hold it, so a manual build
+// and a daemon job never start multi-hour builds side by side.
+func HostLockPath() string {
+	return filepath.Join(os.TempDir(), "ark-android-forge-host.lock")
+}
+
+var hostLock struct {
+	sync.Mutex
+	held  *lock.Lock
+	users int
+}
+
+// AcquireHostLock takes the host lock, or joins it when this process already
+// holds it: the parallel builds of a fleet run and the builds of a daemon
+// job share it, and only other processes are kept out. The returned func
+// releases this use.
+func AcquireHostLock() (func(), error) {
+	hostLock.Lock()
+	defer hostLock.Unlock()
+	if hostLock.users == 0 {
+		held, err := lock.TryAcquire(HostLockPath())
+		if err != nil {
+			return nil, fmt.Errorf("another build is running on this host: %w", err)
+		}
+		hostLock.held = held
+	}
+	hostLock.users++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			hostLock.Lock()
+			defer hostLock.Unlock()
+			if hostLock.users--; hostLock.users == 0 {
+				hostLock.held.Release()
+				hostLock.held = nil
+			}
+		})
+	}, nil
+}
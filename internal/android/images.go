@@ -0,0 +1,100 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+)
+
+// Image names a standalone image that can be built without a full ROM.
+type Image string
+
+const (
+	ImageBoot       Image = "boot"
+	ImageRecovery   Image = "recovery"
+	ImageVendorBoot Image = "vendor_boot"
+	ImageInitBoot   Image = "init_boot"
+)
+
+// imageGoals maps each image to its soong goal.
+var imageGoals = map[Image]string{
+	ImageBoot:       "bootimage",
+	ImageRecovery:   "recoveryimage",
+	ImageVendorBoot: "vendorbootimage",
+	ImageInitBoot:   "initbootimage",
+}
+
+// ImageResult is a verified image produced by BuildImages.
+type ImageResult struct {
+	Image Image
+	Path  string
+	Size  int64
+}
+
+// BuildImages builds the requested images in one soong invocation and
+// verifies each was (re)written by this build. Boot and recovery are built
+// when no image is requested, matching the legacy bash builder.
+func BuildImages(ctx context.Context, runner *execx.Runner, cfg *config.Config, opts BuildOptions, images []Image) ([]ImageResult, error) {
+	if len(images) == 0 {
+		images = []Image{ImageBoot, ImageRecovery}
+	}
+	goals := make([]string, 0, len(images))
+	for _, image := range images {
+		goal, ok := imageGoals[image]
+		if !ok {
+			return nil, fmt.Errorf("unknown image %q", image)
+		}
+		goals = append(goals, goal)
+	}
+
+	plan, err := prepareBuild(ctx, runner, cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.state.Mode = ModeImages
+	plan.state.Images = images
+	start := time.Now()
+	if err := runGoals(ctx, runner, cfg, plan, "m", goals...); err != nil {
+		return nil, err
+	}
+	if plan.opts.DryRun {
+		return nil, nil
+	}
+	return verifyImages(ProductOut(plan.tree), images, start)
+}
+
+// rebuiltSince reports whether a build output was written at or after
+// since, allowing for filesystems with one second mtime resolution.
+func rebuiltSince(info os.FileInfo, since time.Time) bool {
+	return !info.ModTime().Before(since.Add(-time.Second))
+}
+
+func verifyImages(productOut string, images []Image, since time.Time) ([]ImageResult, error) {
+	var results []ImageResult
+	var problems []string
+	for _, image := range images {
+		path := filepath.Join(productOut, string(image)+".img")
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s.img missing", image))
+		case info.Size() == 0:
+			problems = append(problems, fmt.Sprintf("%s.img is empty", image))
+		case !rebuiltSince(info, since):
+			problems = append(problems, fmt.Sprintf("%s.img was not rebuilt", image))
+		default:
+			results = append(results, ImageResult{Image: image, Path: path, Size: info.Size()})
+		}
+	}
+	if len(problems) > 0 {
+		return results, fmt.Errorf("image verification failed in %s: %s", productOut, strings.Join(problems, "; "))
+	}
+	return results, nil
+}
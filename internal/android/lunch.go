@@ -0,0 +1,321 @@
+package android
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Variants lists the build variants accepted by lunch.
+var Variants = []string{"user", "userdebug", "eng"}
+
+// romPrefixes maps repository names to the product prefix their device
+// trees use, for trees that declare several products for one device.
+var romPrefixes = map[string]string{
+	"lineageos": "lineage",
+	"lineage":   "lineage",
+	"evolution": "lineage",
+	"yaap":      "yaap",
+	"aosp":      "aosp",
+	"twrp":      "twrp",
+	"orangefox": "twrp",
+}
+
+var (
+	assignRegexp  = regexp.MustCompile(`^\s*(PRODUCT_MAKEFILES|COMMON_LUNCH_CHOICES)\s*[:+]?=\s*(.*)$`)
+	releaseRegexp = regexp.MustCompile(`^[a-z]{2}[0-9][a-z]$`)
+)
+
+// LunchCombo is a single product[-release]-variant choice.
+type LunchCombo struct {
+	Product string
+	Release string
+	Variant string
+}
+
+// String renders the combo as lunch expects it.
+func (c LunchCombo) String() string {
+	if c.Release == "" {
+		return fmt.Sprintf("%s-%s", c.Product, c.Variant)
+	}
+	return fmt.Sprintf("%s-%s-%s", c.Product, c.Release, c.Variant)
+}
+
+// LunchInfo is what a tree advertises for one device.
+type LunchInfo struct {
+	DeviceDir       string
+	Products        []string
+	Choices         []LunchCombo
+	Releases        []string
+	RequiresRelease bool
+}
+
+// DiscoverLunch scans device/*/<device>/AndroidProducts.mk and the tree's
+// release configs for valid lunch choices.
+func DiscoverLunch(sourceDir, device string) (LunchInfo, error) {
+	matches, err := filepath.Glob(filepath.Join(sourceDir, "device", "*", device, "AndroidProducts.mk"))
+	if err != nil {
+		return LunchInfo{}, fmt.Errorf("scan device trees: %w", err)
+	}
+	if len(matches) == 0 {
+		msg := fmt.Sprintf("no AndroidProducts.mk for %s under %s", device, filepath.Join(sourceDir, "device"))
+		if hint := suggest(device, knownDevices(sourceDir)); hint != "" {
+			msg += fmt.Sprintf(" (did you mean %s?)", hint)
+		}
+		return LunchInfo{}, fmt.Errorf("%s", msg)
+	}
+
+	info := LunchInfo{DeviceDir: filepath.Dir(matches[0])}
+	products, choices, err := parseAndroidProducts(matches[0])
+	if err != nil {
+		return LunchInfo{}, err
+	}
+	info.Products = products
+	info.Choices = choices
+	for _, choice := range choices {
+		if !slices.Contains(info.Products, choice.Product) {
+			info.Products = append(info.Products, choice.Product)
+		}
+	}
+	info.Releases, info.RequiresRelease = releaseConfigs(sourceDir)
+	return info, nil
+}
+
+// ResolveLunch picks and validates the lunch combo for a build. Empty
+// product and release are inferred from the device tree and ROM.
+func ResolveLunch(info LunchInfo, repository, device, product, release, variant string) (LunchCombo, error) {
+	if !slices.Contains(Variants, variant) {
+		return LunchCombo{}, fmt.Errorf("invalid variant %q%s", variant, didYouMean(variant, Variants))
+	}
+
+	if product == "" {
+		product = inferProduct(info, repository, device)
+		if product == "" {
+			return LunchCombo{}, fmt.Errorf("no product for %s in %s", device, info.DeviceDir)
+		}
+	} else if len(info.Products) > 0 && !slices.Contains(info.Products, product) {
+		return LunchCombo{}, fmt.Errorf("product %q not declared in %s%s", product, info.DeviceDir, didYouMean(product, info.Products))
+	}
+
+	if release == "" {
+		for _, choice := range info.Choices {
+			if choice.Product == product && choice.Release != "" {
+				release = choice.Release
+				break
+			}
+		}
+	}
+	if release == "" && info.RequiresRelease {
+		var err error
+		if release, err = latestRelease(info.Releases); err != nil {
+			return LunchCombo{}, err
+		}
+	}
+	if release != "" && len(info.Releases) > 0 && !slices.Contains(info.Releases, release) {
+		return LunchCombo{}, fmt.Errorf("release config %q not found%s", release, didYouMean(release, info.Releases))
+	}
+
+	return LunchCombo{Product: product, Release: release, Variant: variant}, nil
+}
+
+func parseAndroidProducts(path string) ([]string, []LunchCombo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var products []string
+	var choices []LunchCombo
+	var current string
+	var logical strings.Builder
+	scanner := bufio.NewScanner(file)
+	flush := func() {
+		line := logical.String()
+		logical.Reset()
+		if m := assignRegexp.FindStringSubmatch(line); m != nil {
+			current = m[1]
+			line = m[2]
+		} else if current == "" {
+			return
+		}
+		for _, word := range strings.Fields(line) {
+			switch current {
+			case "PRODUCT_MAKEFILES":
+				word = filepath.Base(word)
+				if strings.HasSuffix(word, ".mk") {
+					products = appendUnique(products, strings.TrimSuffix(word, ".mk"))
+				}
+			case "COMMON_LUNCH_CHOICES":
+				if combo, ok := parseCombo(word); ok {
+					choices = append(choices, combo)
+				}
+			}
+		}
+		current = ""
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		if strings.HasSuffix(strings.TrimSpace(line), "\\") {
+			logical.WriteString(strings.TrimSuffix(strings.TrimSpace(line), "\\"))
+			logical.WriteString(" ")
+			continue
+		}
+		logical.WriteString(line)
+		flush()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	flush()
+	return products, choices, nil
+}
+
+func parseCombo(word string) (LunchCombo, bool) {
+	parts := strings.Split(word, "-")
+	switch len(parts) {
+	case 2:
+		return LunchCombo{Product: parts[0], Variant: parts[1]}, true
+	case 3:
+		return LunchCombo{Product: parts[0], Release: parts[1], Variant: parts[2]}, true
+	}
+	return LunchCombo{}, false
+}
+
+// releaseConfigs lists release config names. Trees that ship build/release
+// need product-release-variant combos.
+func releaseConfigs(sourceDir string) ([]string, bool) {
+	_, err := os.Stat(filepath.Join(sourceDir, "build", "release"))
+	required := err == nil
+
+	patterns := []string{
+		filepath.Join(sourceDir, "build", "release", "release_configs", "*.textproto"),
+		filepath.Join(sourceDir, "build", "release", "build_config", "*.scl"),
+		filepath.Join(sourceDir, "vendor", "*", "build", "release", "release_configs", "*.textproto"),
+	}
+	var releases []string
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			name := strings.TrimSuffix(filepath.Base(match), filepath.Ext(match))
+			if name == "root" || strings.HasPrefix(name, "all_") {
+				continue
+			}
+			releases = appendUnique(releases, name)
+		}
+	}
+	sort.Strings(releases)
+	return releases, required
+}
+
+// latestRelease prefers the newest platform release (e.g. ap2a < ap4a <
+// bp1a) and falls back to trunk_staging when the tree ships it.
+func latestRelease(releases []string) (string, error) {
+	var latest string
+	for _, release := range releases {
+		if releaseRegexp.MatchString(release) && release > latest {
+			latest = release
+		}
+	}
+	switch {
+	case latest != "":
+		return latest, nil
+	case slices.Contains(releases, "trunk_staging"):
+		return "trunk_staging", nil
+	case len(releases) == 0:
+		return "", fmt.Errorf("tree needs a release config but none was found under build/release; pass --release")
+	}
+	return "", fmt.Errorf("no platform release config among %s; pass --release", strings.Join(releases, ", "))
+}
+
+func inferProduct(info LunchInfo, repository, device string) string {
+	var candidates []string
+	for _, product := range info.Products {
+		if product == device || strings.HasSuffix(product, "_"+device) {
+			candidates = append(candidates, product)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	if prefix, ok := romPrefixes[strings.ToLower(repository)]; ok {
+		for _, candidate := range candidates {
+			if candidate == prefix+"_"+device {
+				return candidate
+			}
+		}
+	}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, strings.ToLower(repository)+"_") {
+			return candidate
+		}
+	}
+	return candidates[0]
+}
+
+func knownDevices(sourceDir string) []string {
+	matches, _ := filepath.Glob(filepath.Join(sourceDir, "device", "*", "*", "AndroidProducts.mk"))
+	var devices []string
+	for _, match := range matches {
+		devices = appendUnique(devices, filepath.Base(filepath.Dir(match)))
+	}
+	return devices
+}
+
+func didYouMean(input string, options []string) string {
+	if hint := suggest(input, options); hint != "" {
+		return fmt.Sprintf(" (did you mean %s?)", hint)
+	}
+	if len(options) > 0 {
+		return fmt.Sprintf(" (valid: %s)", strings.Join(options, ", "))
+	}
+	return ""
+}
+
+// suggest returns the closest option within a small edit distance.
+func suggest(input string, options []string) string {
+	best := ""
+	bestDist := len(input)/2 + 2
+	for _, option := range options {
+		if d := levenshtein(input, option); d < bestDist {
+			best, bestDist = option, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func appendUnique(list []string, value string) []string {
+	if slices.Contains(list, value) {
+		return list
+	}
+	return append(list, value)
+}
@@ -0,0 +1,52 @@
+package android
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/history"
+	"github.com/koobie777/ark-android-forge/internal/notify"
+)
+
+// notifyTimeout bounds the time a notification may add to a build or sync,
+// retries included.
+const notifyTimeout = 2 * time.Minute
+
+// sendNotification delivers ev. It still runs when ctx was cancelled, so an
+// interrupted build is reported, and failures are only logged.
+func sendNotification(ctx context.Context, runner *execx.Runner, cfg *config.Config, ev notify.Event) {
+	if len(cfg.Notify.Targets) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+	defer cancel()
+	_ = notify.New(cfg, runner.Logger()).Notify(ctx, ev)
+}
+
+// buildEvent describes a finished build from its history record.
+func buildEvent(record history.Record, tree Tree) notify.Event {
+	ev := notify.Event{
+		Name:     notify.EventBuildSuccess,
+		Device:   record.Device,
+		Target:   record.Target(),
+		Tree:     tree.Dir,
+		Duration: record.Duration,
+		Error:    record.Error,
+		Excerpt:  record.Excerpt,
+		Build:    record.ID,
+	}
+	if record.Result != history.ResultSuccess {
+		ev.Name = notify.EventBuildFailure
+	}
+	for _, artifact := range record.Artifacts {
+		ev.Artifacts = append(ev.Artifacts, notify.Artifact{
+			Name: filepath.Base(artifact.Path),
+			Path: artifact.Path,
+			Size: artifact.Size,
+		})
+	}
+	return ev
+}
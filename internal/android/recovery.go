@@ -0,0 +1,227 @@
+package android
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+)
+
+// recoveryPartitions maps the partition a recovery ships in to the image
+// that has to be built. Devices without a recovery partition carry the
+// recovery ramdisk in vendor_boot (or boot on older A/B devices).
+var recoveryPartitions = map[string]Image{
+	"recovery":    ImageRecovery,
+	"vendor_boot": ImageVendorBoot,
+	"boot":        ImageBoot,
+}
+
+// RecoveryOptions configures a TWRP or OrangeFox build.
+type RecoveryOptions struct {
+	Build     BuildOptions
+	Flavor    string
+	Partition string
+	Init      bool
+}
+
+// RecoveryResult describes the packaged recovery image.
+type RecoveryResult struct {
+	Flavor  string
+	Image   ImageResult
+	Package string
+	Extras  []string
+}
+
+// BuildRecovery builds a recovery from its minimal manifest tree
+// (<workspace>/<flavor>-<device>), exporting flavor and device environment
+// (FOX_* for OrangeFox), then copies the image to a versioned filename.
+func BuildRecovery(ctx context.Context, runner *execx.Runner, cfg *config.Config, opts RecoveryOptions) (RecoveryResult, error) {
+	if runner == nil {
+		return RecoveryResult{}, fmt.Errorf("runner is nil")
+	}
+	if cfg == nil {
+		return RecoveryResult{}, fmt.Errorf("config is nil")
+	}
+
+	tree, err := ResolveTree(cfg, opts.Build.Device, "")
+	if err != nil {
+		return RecoveryResult{}, err
+	}
+	var deviceCfg config.DeviceRecovery
+	if device := cfg.DeviceByCodename(tree.Device); device != nil {
+		deviceCfg = device.Recovery
+	}
+
+	flavorName := cmp.Or(opts.Flavor, deviceCfg.Flavor, "twrp")
+	flavor := cfg.RecoveryFlavorByName(flavorName)
+	if flavor == nil {
+		return RecoveryResult{}, fmt.Errorf("unknown recovery flavor %q", flavorName)
+	}
+	partition := cmp.Or(opts.Partition, deviceCfg.Partition, "recovery")
+	image, ok := recoveryPartitions[partition]
+	if !ok {
+		return RecoveryResult{}, fmt.Errorf("unsupported recovery partition %q (recovery, vendor_boot, boot)", partition)
+	}
+
+	tree, err = ResolveTree(cfg, tree.Device, flavor.Name)
+	if err != nil {
+		return RecoveryResult{}, err
+	}
+	if opts.Init {
+		if err := initRecoveryTree(ctx, runner, cfg, tree, *flavor, opts.Build.DryRun); err != nil {
+			return RecoveryResult{}, err
+		}
+		if _, err := os.Stat(filepath.Join(tree.Dir, "build", "envsetup.sh")); err != nil && opts.Build.DryRun {
+			return RecoveryResult{Flavor: flavor.Name}, nil
+		}
+	}
+
+	env := map[string]string{}
+	for k, v := range flavor.Env {
+		env[strings.ToUpper(k)] = v
+	}
+	for k, v := range deviceCfg.Env {
+		env[strings.ToUpper(k)] = v
+	}
+	if deviceCfg.Version != "" && strings.EqualFold(flavor.Name, "orangefox") {
+		env["FOX_VERSION"] = deviceCfg.Version
+	}
+
+	buildOpts := opts.Build
+	buildOpts.Device = tree.Device
+	buildOpts.RepoOverride = flavor.Name
+	buildOpts.TreeDir = tree.Dir
+	if buildOpts.Variant == "" {
+		buildOpts.Variant = "eng"
+	}
+	buildOpts.Env = mergeEnv(env, buildOpts.Env)
+
+	plan, err := prepareBuild(ctx, runner, cfg, buildOpts)
+	if err != nil {
+		return RecoveryResult{}, err
+	}
+	plan.state.Mode = ModeRecovery
+	plan.state.Recovery = &opts
+	start := time.Now()
+	if err := runGoals(ctx, runner, cfg, plan, "m", imageGoals[image]); err != nil {
+		return RecoveryResult{}, err
+	}
+
+	result := RecoveryResult{Flavor: flavor.Name}
+	if plan.opts.DryRun {
+		return result, nil
+	}
+
+	productOut := ProductOut(plan.tree)
+	images, err := verifyImages(productOut, []Image{image}, start)
+	if err != nil {
+		return result, err
+	}
+	result.Image = images[0]
+
+	version := cmp.Or(env["FOX_VERSION"], deviceCfg.Version)
+	name := packageName(cmp.Or(flavor.Label, flavor.Name), version, tree.Device, start)
+	result.Package, err = copyFile(result.Image.Path, filepath.Join(cfg.Recovery.Output, name+".img"))
+	if err != nil {
+		return result, err
+	}
+
+	// OrangeFox also emits a flashable zip next to the image. An older zip is
+	// left over from an earlier build and is not published with this image.
+	if zip, _ := newestMatch(productOut, "OrangeFox-*.zip"); zip != "" && strings.EqualFold(flavor.Name, "orangefox") && freshFile(zip, start) {
+		extra, err := copyFile(zip, filepath.Join(cfg.Recovery.Output, filepath.Base(zip)))
+		if err != nil {
+			return result, err
+		}
+		result.Extras = append(result.Extras, extra)
+	}
+	return result, nil
+}
+
+// initRecoveryTree runs repo init/sync for a recovery tree that has not been
+// synced yet. Existing trees are left untouched.
+func initRecoveryTree(ctx context.Context, runner *execx.Runner, cfg *config.Config, tree Tree, flavor config.RecoveryFlavor, dryRun bool) error {
+	if _, err := os.Stat(filepath.Join(tree.Dir, ".repo")); err == nil {
+		return nil
+	}
+	if flavor.Manifest == "" {
+		return fmt.Errorf("recovery flavor %s has no manifest", flavor.Name)
+	}
+	if !dryRun {
+		if err := os.MkdirAll(tree.Dir, 0o755); err != nil {
+			return fmt.Errorf("prepare recovery tree: %w", err)
+		}
+	}
+
+	env := map[string]string{"ARK_COMMANDER": cfg.Commander}
+	initArgs := []string{"init", "--depth=1", "-u", flavor.Manifest}
+	if flavor.Branch != "" {
+		initArgs = append(initArgs, "-b", flavor.Branch)
+	}
+	steps := []execx.Command{
+		{Name: "repo", Args: initArgs, Dir: tree.Dir, DryRun: dryRun, Env: env},
+		{Name: "repo", Args: []string{"sync", "--current-branch", "--no-tags", "--no-clone-bundle", fmt.Sprintf("--jobs=%d", cfg.Jobs)}, Dir: tree.Dir, DryRun: dryRun, Env: env, Timeout: CommandTimeout(cfg)},
+	}
+	for _, step := range steps {
+		if err := runner.Run(ctx, step); err != nil {
+			return fmt.Errorf("init %s tree: %w", flavor.Name, err)
+		}
+	}
+	return nil
+}
+
+func freshFile(path string, since time.Time) bool {
+	info, err := os.Stat(path)
+	return err == nil && rebuiltSince(info, since)
+}
+
+func packageName(label, version, device string, at time.Time) string {
+	parts := []string{label}
+	if version != "" {
+		parts = append(parts, version)
+	}
+	parts = append(parts, device, at.UTC().Format("20060102-1504"))
+	return strings.Join(parts, "-")
+}
+
+func copyFile(src, dst string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", fmt.Errorf("create %s: %w", filepath.Dir(dst), err)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", fmt.Errorf("create %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return "", fmt.Errorf("copy %s: %w", src, err)
+	}
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("close %s: %w", dst, err)
+	}
+	return dst, nil
+}
+
+func mergeEnv(base, override map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,144 @@
+package android
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+)
+
+// fallbackROMTarget is used for repositories missing from the catalog.
+var fallbackROMTarget = config.ROMTarget{
+	Command: "m",
+	Target:  "otapackage",
+	Package: "*-ota*.zip",
+	Images:  []string{"boot.img", "vendor_boot.img", "dtbo.img"},
+	Payload: true,
+}
+
+// ROMResult lists the validated outputs of a ROM build.
+type ROMResult struct {
+	ProductOut string
+	Package    string
+	Images     []string
+	Missing    []string
+}
+
+// ROMTargetFor returns the catalog entry point for a repository, falling back
+// to AOSP's otapackage.
+func ROMTargetFor(cfg *config.Config, repository string) config.ROMTarget {
+	if repo := cfg.RepositoryByName(repository); repo != nil && repo.ROM.Target != "" {
+		rom := repo.ROM
+		if rom.Command == "" {
+			rom.Command = "m"
+		}
+		if rom.Package == "" {
+			rom.Package = fallbackROMTarget.Package
+		}
+		return rom
+	}
+	return fallbackROMTarget
+}
+
+// BuildROM compiles the full ROM through the repository's entry point (m
+// bacon, mka yaap, ...) and validates the OTA package and images it expects.
+func BuildROM(ctx context.Context, runner *execx.Runner, cfg *config.Config, opts BuildOptions) (ROMResult, error) {
+	plan, err := prepareBuild(ctx, runner, cfg, opts)
+	if err != nil {
+		return ROMResult{}, err
+	}
+
+	rom := ROMTargetFor(cfg, plan.tree.Repository)
+	if plan.opts.Target != "" {
+		rom.Target = plan.opts.Target
+	}
+	plan.state.Mode = ModeROM
+	if err := runGoals(ctx, runner, cfg, plan, rom.Command, rom.Target); err != nil {
+		return ROMResult{}, err
+	}
+	if plan.opts.DryRun {
+		return ROMResult{ProductOut: ProductOut(plan.tree)}, nil
+	}
+	return ValidateROM(ProductOut(plan.tree), rom)
+}
+
+// ValidateROM checks that productOut holds the OTA package and images the
+// ROM target promises.
+func ValidateROM(productOut string, rom config.ROMTarget) (ROMResult, error) {
+	result := ROMResult{ProductOut: productOut}
+
+	pkg, err := newestMatch(productOut, rom.Package)
+	if err != nil {
+		return result, err
+	}
+	if pkg == "" {
+		result.Missing = append(result.Missing, rom.Package)
+	} else {
+		result.Package = pkg
+		if rom.Payload {
+			ok, err := zipHasEntry(pkg, "payload.bin")
+			if err != nil {
+				return result, err
+			}
+			if !ok {
+				result.Missing = append(result.Missing, filepath.Base(pkg)+":payload.bin")
+			}
+		}
+	}
+
+	for _, image := range rom.Images {
+		path := filepath.Join(productOut, image)
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			result.Missing = append(result.Missing, image)
+			continue
+		}
+		result.Images = append(result.Images, path)
+	}
+
+	if len(result.Missing) > 0 {
+		return result, fmt.Errorf("rom outputs missing in %s: %s", productOut, strings.Join(result.Missing, ", "))
+	}
+	return result, nil
+}
+
+func newestMatch(dir, pattern string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return "", fmt.Errorf("match %s: %w", pattern, err)
+	}
+	var newest string
+	var newestMod int64
+	for _, match := range matches {
+		// OTA builds also leave *-target_files*.zip next to the package.
+		if strings.Contains(filepath.Base(match), "target_files") {
+			continue
+		}
+		info, err := os.Stat(match)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if mod := info.ModTime().UnixNano(); newest == "" || mod > newestMod {
+			newest, newestMod = match, mod
+		}
+	}
+	return newest, nil
+}
+
+func zipHasEntry(path, name string) (bool, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return false, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		if file.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
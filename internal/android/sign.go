@@ -0,0 +1,175 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/lock"
+	"github.com/koobie777/ark-android-forge/internal/secret"
+	"github.com/koobie777/ark-android-forge/internal/signing"
+)
+
+// SignedDir holds the signed target-files and OTA, below the product out
+// directory.
+const SignedDir = "signed"
+
+// SignOptions selects the tree to sign.
+type SignOptions struct {
+	Device       string
+	RepoOverride string
+	DryRun       bool
+}
+
+// SignResult lists the signed outputs and the release key's certificate.
+type SignResult struct {
+	TargetFiles string
+	Package     string
+	Certificate string
+}
+
+// Sign re-signs the newest target-files package of a tree with the
+// configured keys and builds an OTA from it, using the releasetools built by
+// 'm target-files-package otatools'. Outputs land in <product out>/signed.
+func Sign(ctx context.Context, runner *execx.Runner, cfg *config.Config, opts SignOptions) (SignResult, error) {
+	if runner == nil {
+		return SignResult{}, fmt.Errorf("runner is nil")
+	}
+	if cfg == nil {
+		return SignResult{}, fmt.Errorf("config is nil")
+	}
+	if cfg.Signing.Keys == "" {
+		return SignResult{}, fmt.Errorf("signing.keys is not set; create a key set with 'sign keygen'")
+	}
+	keys, err := filepath.Abs(cfg.Signing.Keys)
+	if err != nil {
+		return SignResult{}, fmt.Errorf("resolve key dir: %w", err)
+	}
+	cert, err := signing.LoadCertificate(signing.CertPath(keys, signing.ReleaseKey))
+	if err != nil {
+		return SignResult{}, fmt.Errorf("release key: %w", err)
+	}
+
+	tree, err := DetectTree(cfg, opts.Device, opts.RepoOverride)
+	if err != nil {
+		return SignResult{}, err
+	}
+	// The tools run inside the tree, so every path handed to them is absolute.
+	if tree.Dir, err = filepath.Abs(tree.Dir); err != nil {
+		return SignResult{}, fmt.Errorf("resolve tree: %w", err)
+	}
+	productOut := ProductOut(tree)
+	targetFiles, err := newestTargetFiles(filepath.Join(productOut, "obj", "PACKAGING", "target_files_intermediates"))
+	if err != nil {
+		return SignResult{}, err
+	}
+	if targetFiles == "" {
+		return SignResult{}, fmt.Errorf("no target-files package for %s; build 'target-files-package otatools' first", tree.Device)
+	}
+	tools := filepath.Join(tree.Dir, "out", "host", "linux-x86", "bin")
+	for _, tool := range []string{"sign_target_files_apks", "ota_from_target_files"} {
+		if _, err := os.Stat(filepath.Join(tools, tool)); err != nil {
+			return SignResult{}, fmt.Errorf("%s not built in %s; build 'otatools' first", tool, tools)
+		}
+	}
+
+	// Keep the ROM package's file name so Updater entries stay recognisable.
+	name := tree.Device + "-ota-signed.zip"
+	if pkg, err := newestMatch(productOut, ROMTargetFor(cfg, tree.Repository).Package); err == nil && pkg != "" {
+		name = filepath.Base(pkg)
+	}
+	outDir := filepath.Join(productOut, SignedDir)
+	result := SignResult{
+		TargetFiles: filepath.Join(outDir, tree.Device+"-target_files-signed.zip"),
+		Package:     filepath.Join(outDir, name),
+		Certificate: signing.Fingerprint(cert),
+	}
+
+	env := map[string]string{
+		"PATH": tools + string(os.PathListSeparator) + os.Getenv("PATH"),
+	}
+	commands := []execx.Command{
+		{
+			Name: filepath.Join(tools, "sign_target_files_apks"),
+			Args: append(append([]string{"-o", "-d", keys}, cfg.Signing.Args...), targetFiles, result.TargetFiles),
+		},
+		{
+			Name: filepath.Join(tools, "ota_from_target_files"),
+			Args: append(append([]string{"-k", filepath.Join(keys, signing.ReleaseKey)}, cfg.Signing.OTAArgs...), result.TargetFiles, result.Package),
+		},
+	}
+	if opts.DryRun {
+		for _, cmd := range commands {
+			cmd.Dir, cmd.Env, cmd.DryRun = tree.Dir, env, true
+			if err := runner.Run(ctx, cmd); err != nil {
+				return SignResult{}, err
+			}
+		}
+		return result, nil
+	}
+
+	treeLock, err := lock.TryAcquire(LockPath(tree))
+	if err != nil {
+		return SignResult{}, fmt.Errorf("another build is running in %s: %w", tree.Dir, err)
+	}
+	defer treeLock.Release()
+	releaseHost, err := AcquireHostLock()
+	if err != nil {
+		return SignResult{}, err
+	}
+	defer releaseHost()
+
+	if cfg.Signing.Password != "" {
+		password, err := secret.Resolve(cfg.Signing.Password)
+		if err != nil {
+			return SignResult{}, fmt.Errorf("signing password: %w", err)
+		}
+		pwFile, err := signing.PasswordFile(keys, password)
+		if err != nil {
+			return SignResult{}, err
+		}
+		defer os.Remove(pwFile)
+		env[signing.PasswordFileEnv] = pwFile
+	}
+
+	logDir := filepath.Join(tree.Dir, "out", "arkforge-logs")
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		return SignResult{}, fmt.Errorf("prepare log dir: %w", err)
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return SignResult{}, fmt.Errorf("create signed dir: %w", err)
+	}
+	logPath := filepath.Join(logDir, time.Now().UTC().Format("20060102-150405")+"-sign.log")
+	for _, cmd := range commands {
+		cmd.Dir, cmd.Env, cmd.LogPath, cmd.Timeout = tree.Dir, env, logPath, CommandTimeout(cfg)
+		if err := runner.Run(ctx, cmd); err != nil {
+			return SignResult{}, fmt.Errorf("%s: %w (log: %s)", filepath.Base(cmd.Name), err, logPath)
+		}
+	}
+	return result, nil
+}
+
+// newestTargetFiles returns the newest unsigned *-target_files*.zip in dir.
+func newestTargetFiles(dir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*-target_files*.zip"))
+	if err != nil {
+		return "", err
+	}
+	var newest string
+	var newestMod time.Time
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || !info.Mode().IsRegular() || strings.Contains(filepath.Base(match), "signed") {
+			continue
+		}
+		if newest == "" || info.ModTime().After(newestMod) {
+			newest, newestMod = match, info.ModTime()
+		}
+	}
+	return newest, nil
+}
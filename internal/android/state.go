@@ -0,0 +1,234 @@
+package android
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/lock"
+)
+
+// Build modes recorded in the in-progress state so a resume re-enters the
+// same entry point.
+const (
+	ModeTarget   = "target"
+	ModeROM      = "rom"
+	ModeImages   = "images"
+	ModeRecovery = "recovery"
+)
+
+const (
+	StatusRunning = "running"
+	StatusFailed  = "failed"
+)
+
+// stateFile keeps the legacy bash marker name so both tools agree on which
+// trees hold an unfinished build.
+const stateFile = "build_in_progress"
+
+var ninjaProgressRegexp = regexp.MustCompile(`\[\s*(\d+)% (\d+)/(\d+)`)
+
+// BuildState is written to out/build_in_progress while a build runs and is
+// removed once it succeeds.
+type BuildState struct {
+	Mode      string           `json:"mode"`
+	Options   BuildOptions     `json:"options"`
+	Images    []Image          `json:"images,omitempty"`
+	Recovery  *RecoveryOptions `json:"recovery,omitempty"`
+	Combo     string           `json:"combo"`
+	Goals     []string         `json:"goals"`
+	StartedAt time.Time        `json:"startedAt"`
+	PID       int              `json:"pid"`
+	LogPath   string           `json:"logPath"`
+	Status    string           `json:"status"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// InterruptedBuild is a tree whose last build did not finish.
+type InterruptedBuild struct {
+	Tree         Tree
+	State        BuildState
+	Running      bool
+	Legacy       bool
+	LastActivity time.Time
+	NinjaEntries int
+	Progress     string
+}
+
+// Resumable reports whether the build can be restarted with its options.
+func (b InterruptedBuild) Resumable() bool {
+	return !b.Running && !b.Legacy && b.State.Mode != ""
+}
+
+// StatePath returns the in-progress marker of a tree.
+func StatePath(tree Tree) string {
+	return filepath.Join(tree.Dir, "out", stateFile)
+}
+
+func writeState(tree Tree, state BuildState) error {
+	if err := os.MkdirAll(filepath.Join(tree.Dir, "out"), 0o755); err != nil {
+		return fmt.Errorf("prepare out dir: %w", err)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal build state: %w", err)
+	}
+	if err := os.WriteFile(StatePath(tree), data, 0o644); err != nil {
+		return fmt.Errorf("write build state: %w", err)
+	}
+	return nil
+}
+
+// ReadState loads the in-progress state of a tree. An empty marker left by
+// the bash engine yields a zero state and no error.
+func ReadState(tree Tree) (BuildState, error) {
+	data, err := os.ReadFile(StatePath(tree))
+	if err != nil {
+		return BuildState{}, err
+	}
+	var state BuildState
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return state, nil
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return BuildState{}, fmt.Errorf("decode build state: %w", err)
+	}
+	return state, nil
+}
+
+// Interrupted scans the workspace for trees with a build_in_progress marker.
+func Interrupted(cfg *config.Config) ([]InterruptedBuild, error) {
+	trees, err := WorkspaceTrees(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var builds []InterruptedBuild
+	for _, tree := range trees {
+		state, err := ReadState(tree)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("%s: %w", tree.Dir, err)
+		}
+
+		build := InterruptedBuild{Tree: tree, State: state, Legacy: state.Mode == ""}
+		build.Running = state.Status == StatusRunning && lock.Held(LockPath(tree))
+		build.LastActivity = lastActivity(tree, state)
+		build.NinjaEntries = ninjaEntries(filepath.Join(tree.Dir, "out", ".ninja_log"))
+		build.Progress = ninjaProgress(state.LogPath)
+		builds = append(builds, build)
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].LastActivity.After(builds[j].LastActivity) })
+	return builds, nil
+}
+
+// Resume re-runs an interrupted build through the entry point and options
+// it was started with.
+func Resume(ctx context.Context, runner *execx.Runner, cfg *config.Config, build InterruptedBuild, dryRun bool) error {
+	if !build.Resumable() {
+		return fmt.Errorf("build in %s cannot be resumed", build.Tree.Dir)
+	}
+	state := build.State
+	opts := state.Options
+	opts.DryRun = dryRun
+
+	switch state.Mode {
+	case ModeTarget:
+		return Build(ctx, runner, cfg, opts)
+	case ModeROM:
+		_, err := BuildROM(ctx, runner, cfg, opts)
+		return err
+	case ModeImages:
+		_, err := BuildImages(ctx, runner, cfg, opts, state.Images)
+		return err
+	case ModeRecovery:
+		if state.Recovery == nil {
+			return fmt.Errorf("recovery build state missing options")
+		}
+		recovery := *state.Recovery
+		recovery.Build.DryRun = dryRun
+		recovery.Init = false
+		_, err := BuildRecovery(ctx, runner, cfg, recovery)
+		return err
+	}
+	return fmt.Errorf("unknown build mode %q", state.Mode)
+}
+
+func lastActivity(tree Tree, state BuildState) time.Time {
+	var latest time.Time
+	candidates := []string{
+		StatePath(tree),
+		filepath.Join(tree.Dir, "out", ".ninja_log"),
+		filepath.Join(tree.Dir, "out", "soong.log"),
+		state.LogPath,
+	}
+	for _, path := range candidates {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// ninjaEntries counts the targets recorded in .ninja_log, as the bash
+// resume module did.
+func ninjaEntries(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" && !strings.HasPrefix(line, "#") {
+			count++
+		}
+	}
+	return count
+}
+
+// ninjaProgress returns the last "[ 42% 1234/5678]" status in the build log.
+func ninjaProgress(logPath string) string {
+	if logPath == "" {
+		return ""
+	}
+	file, err := os.Open(logPath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	// Only the tail matters; multi-hour logs can be large.
+	const tail = 256 * 1024
+	if info, err := file.Stat(); err == nil && info.Size() > tail {
+		_, _ = file.Seek(info.Size()-tail, 0)
+	}
+
+	var progress string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if m := ninjaProgressRegexp.FindStringSubmatch(scanner.Text()); m != nil {
+			progress = fmt.Sprintf("%s%% (%s/%s)", m[1], m[2], m[3])
+		}
+	}
+	return progress
+}
@@ -5,13 +5,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/koobie777/ark-android-forge/internal/config"
 	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/history"
+	"github.com/koobie777/ark-android-forge/internal/notify"
 )
 
-// SyncOptions configures repo sync runs.
+// SyncOptions configures repo sync runs. Dir defaults to the workspace.
 type SyncOptions struct {
+	Dir      string
 	Manifest string
 	Force    bool
 	DryRun   bool
@@ -26,7 +30,11 @@ func RepoSync(ctx context.Context, runner *execx.Runner, cfg *config.Config, opt
 		return fmt.Errorf("config is nil")
 	}
 
-	if err := os.MkdirAll(cfg.Build.Workspace, 0o755); err != nil {
+	dir := opts.Dir
+	if dir == "" {
+		dir = cfg.Build.Workspace
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("prepare workspace: %w", err)
 	}
 
@@ -40,14 +48,39 @@ func RepoSync(ctx context.Context, runner *execx.Runner, cfg *config.Config, opt
 	}
 
 	cmd := execx.Command{
-		Name:   "repo",
-		Args:   args,
-		Dir:    cfg.Build.Workspace,
-		DryRun: opts.DryRun,
+		Name:    "repo",
+		Args:    args,
+		Dir:     dir,
+		DryRun:  opts.DryRun,
+		Timeout: CommandTimeout(cfg),
 		Env: map[string]string{
 			"ARK_COMMANDER": cfg.Commander,
 		},
 	}
 
-	return runner.Run(ctx, cmd)
+	if opts.DryRun {
+		return runner.Run(ctx, cmd)
+	}
+
+	started := time.Now().UTC()
+	sendNotification(ctx, runner, cfg, notify.Event{Name: notify.EventSyncStart, Tree: dir})
+	err := runner.Run(ctx, cmd)
+	finished := time.Now().UTC()
+
+	// Sync history feeds metrics; failing to record it is not a sync error.
+	record := history.SyncRecord{
+		StartedAt:  started,
+		FinishedAt: finished,
+		Duration:   finished.Sub(started),
+		Dir:        dir,
+		Result:     history.ResultSuccess,
+	}
+	ev := notify.Event{Name: notify.EventSyncSuccess, Tree: dir, Duration: record.Duration}
+	if err != nil {
+		record.Result, record.Error = history.ResultFailure, err.Error()
+		ev.Name, ev.Error = notify.EventSyncFailure, err.Error()
+	}
+	_ = history.AppendSync(history.SyncPath(cfg), record)
+	sendNotification(ctx, runner, cfg, ev)
+	return err
 }
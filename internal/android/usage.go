@@ -0,0 +1,58 @@
+package android
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TreeUsage is the disk usage of a workspace tree.
+type TreeUsage struct {
+	Tree      string    `json:"tree"`
+	Device    string    `json:"device"`
+	Total     int64     `json:"total"`
+	Out       int64     `json:"out"`
+	ScannedAt time.Time `json:"scannedAt,omitempty"`
+	Scanning  bool      `json:"scanning"`
+}
+
+// UsageScanner measures tree sizes in the background and caches them for
+// TTL, so callers never wait for a walk of a synced tree.
+type UsageScanner struct {
+	TTL time.Duration
+
+	mu    sync.Mutex
+	trees map[string]*TreeUsage
+}
+
+// Usage returns the last measured usage of tree, starting a scan when it is
+// missing or older than TTL. A tree never scanned reports zero sizes.
+func (s *UsageScanner) Usage(tree Tree) TreeUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.trees == nil {
+		s.trees = map[string]*TreeUsage{}
+	}
+	usage, ok := s.trees[tree.Dir]
+	if !ok {
+		usage = &TreeUsage{Tree: tree.Dir, Device: tree.Device}
+		s.trees[tree.Dir] = usage
+	}
+	if !usage.Scanning && time.Since(usage.ScannedAt) > s.TTL {
+		usage.Scanning = true
+		go s.scan(tree.Dir)
+	}
+	return *usage
+}
+
+func (s *UsageScanner) scan(dir string) {
+	total, _ := DirSize(dir)
+	out, _ := DirSize(filepath.Join(dir, "out"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := s.trees[dir]
+	usage.Total, usage.Out = total, out
+	usage.ScannedAt = time.Now().UTC()
+	usage.Scanning = false
+}
@@ -0,0 +1,135 @@
+package android
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+)
+
+// Tree identifies a ROM source tree inside the configured workspace.
+type Tree struct {
+	Repository string
+	Device     string
+	Dir        string
+}
+
+// ResolveTree maps a device (defaulting to the fleet primary) and optional
+// repository override to its <workspace>/<repo>-<device> source tree.
+func ResolveTree(cfg *config.Config, device, repoOverride string) (Tree, error) {
+	if cfg == nil {
+		return Tree{}, fmt.Errorf("config is nil")
+	}
+
+	if device == "" {
+		if len(cfg.Fleet) == 0 {
+			return Tree{}, fmt.Errorf("device required")
+		}
+		device = cfg.Fleet[0].Codename
+	}
+
+	repoName := repoOverride
+	if repoName == "" {
+		if fleetDevice := cfg.DeviceByCodename(device); fleetDevice != nil && fleetDevice.Repository != "" {
+			repoName = fleetDevice.Repository
+		} else {
+			repoName = "android"
+		}
+	}
+
+	return Tree{
+		Repository: repoName,
+		Device:     device,
+		Dir:        filepath.Join(cfg.Build.Workspace, fmt.Sprintf("%s-%s", repoName, device)),
+	}, nil
+}
+
+// LockPath is the lock held by builds and clean operations on a tree. It
+// lives outside out/ so a full clean cannot remove it.
+func LockPath(tree Tree) string {
+	return filepath.Join(tree.Dir, ".arkforge", "build.lock")
+}
+
+// DirSize sums the apparent size of regular files below path.
+func DirSize(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(_ string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+// DetectTree resolves the tree like ResolveTree but, when no repository is
+// forced and the expected directory is absent, falls back to the single
+// <repo>-<device> tree synced in the workspace.
+func DetectTree(cfg *config.Config, device, repoOverride string) (Tree, error) {
+	tree, err := ResolveTree(cfg, device, repoOverride)
+	if err != nil || repoOverride != "" {
+		return tree, err
+	}
+	if _, err := os.Stat(tree.Dir); err == nil {
+		return tree, nil
+	}
+
+	trees, err := WorkspaceTrees(cfg, tree.Device)
+	if err != nil {
+		return Tree{}, err
+	}
+	switch len(trees) {
+	case 0:
+		return tree, nil
+	case 1:
+		return trees[0], nil
+	}
+	var names []string
+	for _, candidate := range trees {
+		names = append(names, candidate.Repository)
+	}
+	return Tree{}, fmt.Errorf("multiple trees for %s (%s); pick one with --repo", tree.Device, strings.Join(names, ", "))
+}
+
+// WorkspaceTrees lists synced trees in the workspace, optionally only those
+// for one device. A tree is a <repo>-<device> directory with build/envsetup.sh.
+func WorkspaceTrees(cfg *config.Config, device string) ([]Tree, error) {
+	entries, err := os.ReadDir(cfg.Build.Workspace)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("scan workspace: %w", err)
+	}
+
+	var trees []Tree
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		idx := strings.LastIndex(entry.Name(), "-")
+		if idx <= 0 || idx == len(entry.Name())-1 {
+			continue
+		}
+		repo, codename := entry.Name()[:idx], entry.Name()[idx+1:]
+		if device != "" && !strings.EqualFold(codename, device) {
+			continue
+		}
+		dir := filepath.Join(cfg.Build.Workspace, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, "build", "envsetup.sh")); err != nil {
+			continue
+		}
+		trees = append(trees, Tree{Repository: repo, Device: codename, Dir: dir})
+	}
+	return trees, nil
+}
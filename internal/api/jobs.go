@@ -0,0 +1,239 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/koobie777/ark-android-forge/internal/execx"
+)
+
+// maxLogLines bounds the log kept in memory per job; older lines are
+// dropped but remain in the build log files.
+const maxLogLines = 10000
+
+// Job statuses.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSuccess   = "success"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is an operation started through the API.
+type Job struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	Params     any       `json:"params,omitempty"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"createdAt"`
+	StartedAt  time.Time `json:"startedAt,omitempty"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Result     any       `json:"result,omitempty"`
+
+	cancel context.CancelFunc
+	log    *jobLog
+}
+
+// JobFunc does the work of a job with a runner whose output feeds the job log.
+type JobFunc func(ctx context.Context, runner *execx.Runner) (any, error)
+
+// Jobs tracks API jobs and their live logs.
+type Jobs struct {
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	next   int
+	base   context.Context
+	output io.Writer
+}
+
+// NewJobs returns a job manager whose jobs are cancelled with ctx. Job logs
+// are also written to output, the server's log.
+func NewJobs(ctx context.Context, output io.Writer) *Jobs {
+	return &Jobs{jobs: map[string]*Job{}, base: ctx, output: output}
+}
+
+// Start runs fn in the background and returns a snapshot of the new job.
+func (j *Jobs) Start(kind string, params any, fn JobFunc) Job {
+	ctx, cancel := context.WithCancel(j.base)
+
+	j.mu.Lock()
+	j.next++
+	job := &Job{
+		ID:        fmt.Sprintf("%s-%d", kind, j.next),
+		Kind:      kind,
+		Params:    params,
+		Status:    JobQueued,
+		CreatedAt: time.Now().UTC(),
+		cancel:    cancel,
+		log:       newJobLog(),
+	}
+	j.jobs[job.ID] = job
+	snapshot := *job
+	j.mu.Unlock()
+
+	// Each job logs to its own stream as well as the server log.
+	console := zerolog.ConsoleWriter{Out: job.log, NoColor: true, TimeFormat: time.TimeOnly}
+	logger := zerolog.New(zerolog.MultiLevelWriter(console, j.output)).With().Timestamp().Str("job", job.ID).Logger()
+	runner := execx.NewRunner(logger)
+
+	go func() {
+		defer cancel()
+		j.update(func() {
+			job.Status = JobRunning
+			job.StartedAt = time.Now().UTC()
+		})
+		result, err := fn(ctx, runner)
+		j.update(func() {
+			job.FinishedAt = time.Now().UTC()
+			job.Result = result
+			switch {
+			case err == nil:
+				job.Status = JobSuccess
+			case ctx.Err() != nil:
+				job.Status = JobCancelled
+				job.Error = err.Error()
+			default:
+				job.Status = JobFailed
+				job.Error = err.Error()
+			}
+		})
+		if err != nil {
+			logger.Error().Err(err).Msg("api: job failed")
+		} else {
+			logger.Info().Msg("api: job finished")
+		}
+		job.log.close()
+	}()
+	return snapshot
+}
+
+func (j *Jobs) update(fn func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn()
+}
+
+// Get returns a snapshot of a job.
+func (j *Jobs) Get(id string) (Job, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List returns snapshots of all jobs, newest first.
+func (j *Jobs) List() []Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	jobs := make([]Job, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].CreatedAt.After(jobs[b].CreatedAt) })
+	return jobs
+}
+
+// Cancel stops a running job.
+func (j *Jobs) Cancel(id string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// Follow returns the log lines of a job from cursor on, the next cursor, a
+// channel closed when more lines arrive, and whether the log is complete.
+func (j *Jobs) Follow(id string, cursor int) (lines []string, next int, changed <-chan struct{}, done bool, ok bool) {
+	j.mu.Lock()
+	job, ok := j.jobs[id]
+	j.mu.Unlock()
+	if !ok {
+		return nil, cursor, nil, true, false
+	}
+	lines, next, changed, done = job.log.since(cursor)
+	return lines, next, changed, done, true
+}
+
+// jobLog is an append-only line buffer that wakes followers on writes.
+type jobLog struct {
+	mu      sync.Mutex
+	lines   []string
+	dropped int
+	partial []byte
+	changed chan struct{}
+	done    bool
+}
+
+func newJobLog() *jobLog {
+	return &jobLog{changed: make(chan struct{})}
+}
+
+var _ io.Writer = (*jobLog)(nil)
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.partial = append(l.partial, p...)
+	for {
+		idx := bytes.IndexByte(l.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		l.lines = append(l.lines, string(l.partial[:idx]))
+		l.partial = l.partial[idx+1:]
+	}
+	// Trim in batches so a full log is not copied on every write.
+	if over := len(l.lines) - maxLogLines; over > maxLogLines/10 {
+		l.lines = append([]string(nil), l.lines[over:]...)
+		l.dropped += over
+	}
+	l.notify()
+	return len(p), nil
+}
+
+func (l *jobLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.partial) > 0 {
+		l.lines = append(l.lines, string(l.partial))
+		l.partial = nil
+	}
+	l.done = true
+	l.notify()
+}
+
+func (l *jobLog) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// since returns lines from the absolute index cursor on.
+func (l *jobLog) since(cursor int) ([]string, int, <-chan struct{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start := cursor - l.dropped
+	if start < 0 {
+		start = 0
+	}
+	if start > len(l.lines) {
+		start = len(l.lines)
+	}
+	lines := append([]string(nil), l.lines[start:]...)
+	return lines, l.dropped + len(l.lines), l.changed, l.done
+}
@@ -0,0 +1,413 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/artifacts"
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/history"
+	"github.com/koobie777/ark-android-forge/internal/pipeline"
+)
+
+// Server exposes ARKFORGE operations as a JSON API under /api/v1.
+type Server struct {
+	cfg   *config.Config
+	token string
+	jobs  *Jobs
+	mux   *http.ServeMux
+	disk  *android.UsageScanner
+}
+
+// NewServer returns an API server. Every request must carry token as a
+// bearer token (or ?token= for EventSource clients).
+func NewServer(cfg *config.Config, token string, jobs *Jobs) (*Server, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config is nil")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("api token is empty")
+	}
+	// Walking a synced tree takes minutes, so sizes are reused for a while.
+	disk := &android.UsageScanner{TTL: 10 * time.Minute}
+	s := &Server{cfg: cfg, token: token, jobs: jobs, mux: http.NewServeMux(), disk: disk}
+	s.mux.HandleFunc("GET /api/v1/fleet", s.handleFleet)
+	s.mux.HandleFunc("POST /api/v1/sync", s.handleSync)
+	s.mux.HandleFunc("POST /api/v1/builds", s.handleBuild)
+	s.mux.HandleFunc("POST /api/v1/release", s.handleRelease)
+	s.mux.HandleFunc("POST /api/v1/pipelines/{name}", s.handlePipeline)
+	s.mux.HandleFunc("GET /api/v1/builds/active", s.handleActive)
+	s.mux.HandleFunc("GET /api/v1/jobs", s.handleJobs)
+	s.mux.HandleFunc("GET /api/v1/jobs/{id}", s.handleJob)
+	s.mux.HandleFunc("POST /api/v1/jobs/{id}/cancel", s.handleCancel)
+	s.mux.HandleFunc("GET /api/v1/jobs/{id}/logs", s.handleLogs)
+	s.mux.HandleFunc("GET /api/v1/history", s.handleHistory)
+	s.mux.HandleFunc("GET /api/v1/history/summary", s.handleHistorySummary)
+	s.mux.HandleFunc("GET /api/v1/history/{id}/log", s.handleHistoryLog)
+	s.mux.HandleFunc("GET /api/v1/history/{id}/artifacts/{index}", s.handleHistoryArtifact)
+	s.mux.HandleFunc("GET /api/v1/disk", s.handleDisk)
+	return s, nil
+}
+
+// Handle registers an extra route behind the same authentication.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// ServeHTTP authenticates the request and dispatches it.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="arkforge"`)
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	supplied := r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); header != "" {
+		supplied = strings.TrimPrefix(header, "Bearer ")
+	}
+	return supplied != "" && subtle.ConstantTimeCompare([]byte(supplied), []byte(s.token)) == 1
+}
+
+// DeviceInfo is a fleet device with the state of its tree.
+type DeviceInfo struct {
+	Name       string `json:"name"`
+	Codename   string `json:"codename"`
+	Role       string `json:"role"`
+	Repository string `json:"repository"`
+	Tree       string `json:"tree"`
+	Synced     bool   `json:"synced"`
+	Building   bool   `json:"building"`
+}
+
+func (s *Server) handleFleet(w http.ResponseWriter, r *http.Request) {
+	interrupted, err := android.Interrupted(s.cfg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	running := map[string]bool{}
+	for _, build := range interrupted {
+		if build.Running {
+			running[build.Tree.Dir] = true
+		}
+	}
+
+	devices := make([]DeviceInfo, 0, len(s.cfg.Fleet))
+	for _, device := range s.cfg.Fleet {
+		info := DeviceInfo{Name: device.Name, Codename: device.Codename, Role: device.Role, Repository: device.Repository}
+		if tree, err := android.DetectTree(s.cfg, device.Codename, ""); err == nil {
+			info.Tree = tree.Dir
+			_, statErr := os.Stat(filepath.Join(tree.Dir, "build", "envsetup.sh"))
+			info.Synced = statErr == nil
+			info.Building = running[tree.Dir]
+		}
+		devices = append(devices, info)
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+// SyncRequest is the body of POST /api/v1/sync.
+type SyncRequest struct {
+	Device string `json:"device"`
+	Force  bool   `json:"force"`
+	DryRun bool   `json:"dryRun"`
+}
+
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	var req SyncRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	tree, err := android.DetectTree(s.cfg, req.Device, "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	job := s.jobs.Start("sync", req, func(ctx context.Context, runner *execx.Runner) (any, error) {
+		opts := android.SyncOptions{Dir: tree.Dir, Force: req.Force, DryRun: req.DryRun}
+		return nil, android.RepoSync(ctx, runner, s.cfg, opts)
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// BuildRequest is the body of POST /api/v1/builds. Targets follow fleet
+// builds: rom, images and recovery select those builds, anything else is
+// passed to m.
+type BuildRequest struct {
+	Devices   []string `json:"devices"`
+	Targets   []string `json:"targets"`
+	Variant   string   `json:"variant"`
+	Product   string   `json:"product"`
+	Release   string   `json:"release"`
+	NoPatches bool     `json:"noPatches"`
+	Parallel  int      `json:"parallel"`
+	DryRun    bool     `json:"dryRun"`
+}
+
+// BuildResult is the outcome of one device × target of a build job.
+type BuildResult struct {
+	Device   string        `json:"device"`
+	Target   string        `json:"target"`
+	Tree     string        `json:"tree"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func (s *Server) handleBuild(w http.ResponseWriter, r *http.Request) {
+	var req BuildRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	devices := req.Devices
+	if len(devices) == 0 {
+		tree, err := android.ResolveTree(s.cfg, "", "")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		devices = []string{tree.Device}
+	}
+	// Products and release configs are per tree, like on the CLI.
+	if len(devices) > 1 && (req.Product != "" || req.Release != "") {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("product and release select one device's lunch combo; drop them to build %d devices", len(devices)))
+		return
+	}
+	jobs, err := android.PlanFleet(s.cfg, devices, req.Targets)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job := s.jobs.Start("build", req, func(ctx context.Context, runner *execx.Runner) (any, error) {
+		opts := android.BuildOptions{
+			Variant:     req.Variant,
+			Product:     req.Product,
+			Release:     req.Release,
+			SkipPatches: req.NoPatches,
+			DryRun:      req.DryRun,
+		}
+		var results []BuildResult
+		failed := 0
+		for _, result := range android.RunFleet(ctx, runner, s.cfg, jobs, opts, req.Parallel, nil) {
+			entry := BuildResult{Device: result.Device, Target: result.Target, Tree: result.Tree.Dir, Duration: result.Duration}
+			if result.Err != nil {
+				entry.Error = result.Err.Error()
+				failed++
+			}
+			results = append(results, entry)
+		}
+		if failed > 0 {
+			return results, fmt.Errorf("%d of %d builds failed", failed, len(results))
+		}
+		return results, nil
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// ReleaseRequest is the body of POST /api/v1/release. The manifest is
+// always written inside the release directory; clients cannot choose paths.
+type ReleaseRequest struct {
+	Version string `json:"version"`
+}
+
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	var req ReleaseRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	job := s.jobs.Start("release", req, func(ctx context.Context, runner *execx.Runner) (any, error) {
+		manifest, err := artifacts.Release(ctx, runner, s.cfg, artifacts.ReleaseOptions{Version: req.Version})
+		if err != nil {
+			return nil, err
+		}
+		if err := artifacts.Write(artifacts.ManifestPath(manifest), manifest); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// PipelineRequest is the body of POST /api/v1/pipelines/{name}.
+type PipelineRequest struct {
+	DryRun bool   `json:"dryRun"`
+	Resume bool   `json:"resume"`
+	From   string `json:"from"`
+}
+
+func (s *Server) handlePipeline(w http.ResponseWriter, r *http.Request) {
+	var req PipelineRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	name := r.PathValue("name")
+	if _, err := pipeline.Lookup(s.cfg, name); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	job := s.jobs.Start("pipeline", map[string]any{"name": name, "request": req}, func(ctx context.Context, runner *execx.Runner) (any, error) {
+		opts := pipeline.Options{DryRun: req.DryRun, Resume: req.Resume, From: req.From}
+		return pipeline.Execute(ctx, runner, s.cfg, name, opts, nil)
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.jobs.List())
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %q", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if !s.jobs.Cancel(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %q", r.PathValue("id")))
+		return
+	}
+	job, _ := s.jobs.Get(r.PathValue("id"))
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleLogs streams a job's log as server-sent events: one "log" event per
+// line, then an "end" event once the job finished. The event id is the line
+// cursor, so reconnecting clients resume via Last-Event-ID.
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+	cursor, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	if _, _, _, _, found := s.jobs.Follow(id, cursor); !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %q", id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		lines, next, changed, done, _ := s.jobs.Follow(id, cursor)
+		for i, line := range lines {
+			fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", cursor+i+1, line)
+		}
+		cursor = next
+		if done {
+			job, _ := s.jobs.Get(id)
+			data, _ := json.Marshal(job)
+			fmt.Fprintf(w, "event: end\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+	}
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	records, err := s.historyRecords(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+// HistorySummary is the body of GET /api/v1/history/summary.
+type HistorySummary struct {
+	Summaries []history.Summary    `json:"summaries"`
+	Trend     []history.TrendPoint `json:"trend"`
+}
+
+func (s *Server) handleHistorySummary(w http.ResponseWriter, r *http.Request) {
+	records, err := s.historyRecords(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	trend, err := history.Trend(records, r.URL.Query().Get("trend"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, HistorySummary{Summaries: history.Summarize(records), Trend: trend})
+}
+
+// historyRecords loads history filtered by ?device=, ?mode= and ?since=
+// (RFC 3339 time).
+func (s *Server) historyRecords(r *http.Request) ([]history.Record, error) {
+	query := r.URL.Query()
+	filter := history.Filter{Device: query.Get("device"), Mode: query.Get("mode")}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since %q: %w", since, err)
+		}
+		filter.Since = t
+	}
+	records, err := history.Load(history.Path(s.cfg))
+	if err != nil {
+		return nil, err
+	}
+	return history.Query(records, filter), nil
+}
+
+// decode reads an optional JSON body; an empty body leaves v unchanged.
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decode request: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/history"
+	"github.com/koobie777/ark-android-forge/internal/scheduler"
+)
+
+// ActiveBuild is a tree with an unfinished build.
+type ActiveBuild struct {
+	Tree       string    `json:"tree"`
+	Device     string    `json:"device"`
+	Repository string    `json:"repository"`
+	Mode       string    `json:"mode"`
+	Combo      string    `json:"combo"`
+	Goals      []string  `json:"goals"`
+	StartedAt  time.Time `json:"startedAt"`
+	Running    bool      `json:"running"`
+	Progress   string    `json:"progress"`
+	LogPath    string    `json:"logPath"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Activity is the body of GET /api/v1/builds/active: builds running or
+// interrupted in the workspace, API jobs in flight and the daemon queue.
+type Activity struct {
+	Builds []ActiveBuild   `json:"builds"`
+	Jobs   []Job           `json:"jobs"`
+	Queue  []scheduler.Job `json:"queue"`
+}
+
+func (s *Server) handleActive(w http.ResponseWriter, r *http.Request) {
+	interrupted, err := android.Interrupted(s.cfg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	activity := Activity{Builds: []ActiveBuild{}, Jobs: []Job{}, Queue: []scheduler.Job{}}
+	for _, build := range interrupted {
+		activity.Builds = append(activity.Builds, ActiveBuild{
+			Tree:       build.Tree.Dir,
+			Device:     build.Tree.Device,
+			Repository: build.Tree.Repository,
+			Mode:       build.State.Mode,
+			Combo:      build.State.Combo,
+			Goals:      build.State.Goals,
+			StartedAt:  build.State.StartedAt,
+			Running:    build.Running,
+			Progress:   build.Progress,
+			LogPath:    build.State.LogPath,
+			Error:      build.State.Error,
+		})
+	}
+	for _, job := range s.jobs.List() {
+		if job.Status == JobQueued || job.Status == JobRunning {
+			activity.Jobs = append(activity.Jobs, job)
+		}
+	}
+	if state, err := scheduler.LoadState(s.cfg); err == nil {
+		activity.Queue = append(activity.Queue, state.Queue...)
+	}
+	writeJSON(w, http.StatusOK, activity)
+}
+
+// DiskUsage is the body of GET /api/v1/disk.
+type DiskUsage struct {
+	Workspace string              `json:"workspace"`
+	Free      int64               `json:"free"`
+	Size      int64               `json:"size"`
+	Trees     []android.TreeUsage `json:"trees"`
+}
+
+func (s *Server) handleDisk(w http.ResponseWriter, r *http.Request) {
+	trees, err := android.WorkspaceTrees(s.cfg, "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	usage := DiskUsage{Workspace: s.cfg.Build.Workspace, Trees: []android.TreeUsage{}}
+	usage.Free, usage.Size = android.DiskSpace(s.cfg.Build.Workspace)
+	for _, tree := range trees {
+		usage.Trees = append(usage.Trees, s.disk.Usage(tree))
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// handleHistoryLog serves the build log recorded for a history entry.
+func (s *Server) handleHistoryLog(w http.ResponseWriter, r *http.Request) {
+	record, ok := s.historyRecord(w, r)
+	if !ok {
+		return
+	}
+	if record.LogPath == "" {
+		writeError(w, http.StatusNotFound, os.ErrNotExist)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeFile(w, r, record.LogPath)
+}
+
+// handleHistoryArtifact serves the n-th artifact of a history entry.
+func (s *Server) handleHistoryArtifact(w http.ResponseWriter, r *http.Request) {
+	record, ok := s.historyRecord(w, r)
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 || index >= len(record.Artifacts) {
+		writeError(w, http.StatusNotFound, os.ErrNotExist)
+		return
+	}
+	path := record.Artifacts[index].Path
+	w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(filepath.Base(path)))
+	http.ServeFile(w, r, path)
+}
+
+// historyRecord looks up {id}. Files are only served from paths stored in
+// the history, never from client input.
+func (s *Server) historyRecord(w http.ResponseWriter, r *http.Request) (history.Record, bool) {
+	records, err := history.Load(history.Path(s.cfg))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return history.Record{}, false
+	}
+	id := r.PathValue("id")
+	for _, record := range records {
+		if record.ID == id {
+			return record, true
+		}
+	}
+	writeError(w, http.StatusNotFound, os.ErrNotExist)
+	return history.Record{}, false
+}
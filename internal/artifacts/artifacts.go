@@ -1,6 +1,7 @@
 package artifacts
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -8,16 +9,41 @@ import (
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/koobie777/ark-android-forge/internal/android"
 	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/gerrit"
+	"github.com/koobie777/ark-android-forge/internal/history"
+	"github.com/koobie777/ark-android-forge/internal/signing"
 )
 
-// Manifest captures high-level release metadata.
+// Manifest captures high-level release metadata. Artifact paths are
+// relative to Dir.
 type Manifest struct {
-	GeneratedAt time.Time             `yaml:"generatedAt"`
-	Commander   string                `yaml:"commander"`
-	Version     string                `yaml:"version"`
-	Devices     []config.FleetDevice  `yaml:"devices"`
-	Notes       map[string]string     `yaml:"notes,omitempty"`
+	GeneratedAt time.Time                        `yaml:"generatedAt"`
+	Commander   string                           `yaml:"commander"`
+	Version     string                           `yaml:"version"`
+	Release     string                           `yaml:"release,omitempty"`
+	Dir         string                           `yaml:"dir,omitempty"`
+	Devices     []config.FleetDevice             `yaml:"devices"`
+	Artifacts   []Artifact                       `yaml:"artifacts,omitempty"`
+	Signing     *Signing                         `yaml:"signing,omitempty"`
+	Checksums   *Checksums                       `yaml:"checksums,omitempty"`
+	Updater     map[string]string                `yaml:"updater,omitempty"`
+	Picks       map[string][]gerrit.PickedChange `yaml:"picks,omitempty"`
+	Notes       map[string]string                `yaml:"notes,omitempty"`
+}
+
+// Signing identifies the release key OTAs were checked against.
+type Signing struct {
+	Certificate string `yaml:"certificate"`
+	Subject     string `yaml:"subject"`
+}
+
+// ReleaseOptions configures Release. Version names the release directory
+// and defaults to the UTC time.
+type ReleaseOptions struct {
+	Version string
 }
 
 // Generate builds a manifest from the current configuration.
@@ -30,10 +56,114 @@ func Generate(cfg *config.Config) Manifest {
 	}
 }
 
+// Release collects each fleet device's artifacts into
+// <release.dir>/<version>/<device> and generates the manifest, including
+// the Gerrit changes still picked into each tree, plus the checksum files. With
+// release.updater.url set it also updates each device's LineageOS Updater
+// JSON.
+func Release(ctx context.Context, runner *execx.Runner, cfg *config.Config, opts ReleaseOptions) (Manifest, error) {
+	if cfg == nil {
+		return Manifest{}, fmt.Errorf("config is nil")
+	}
+	manifest := Generate(cfg)
+	manifest.Release = opts.Version
+	if manifest.Release == "" {
+		manifest.Release = manifest.GeneratedAt.Format("20060102-150405")
+	}
+	// The version names a directory below release.dir and nothing else.
+	if manifest.Release != filepath.Base(manifest.Release) || manifest.Release == "." || manifest.Release == ".." {
+		return Manifest{}, fmt.Errorf("invalid release version %q", manifest.Release)
+	}
+	manifest.Dir = filepath.Join(cfg.Release.Dir, manifest.Release)
+
+	var releaseCert string
+	if cfg.Signing.Keys != "" {
+		cert, err := signing.LoadCertificate(signing.CertPath(cfg.Signing.Keys, signing.ReleaseKey))
+		if err != nil {
+			return Manifest{}, fmt.Errorf("release key: %w", err)
+		}
+		releaseCert = signing.Fingerprint(cert)
+		manifest.Signing = &Signing{Certificate: releaseCert, Subject: cert.Subject.String()}
+	}
+
+	records, err := history.Load(history.Path(cfg))
+	if err != nil {
+		return Manifest{}, err
+	}
+	for _, device := range cfg.Fleet {
+		tree, err := android.ResolveTree(cfg, device.Codename, "")
+		if err != nil {
+			return Manifest{}, err
+		}
+		collected, err := collectDevice(tree, cfg.Release.Artifacts, manifest.Dir, cfg.Release.Link, lastBuild(records, tree), releaseCert)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("%s: %w", device.Codename, err)
+		}
+		manifest.Artifacts = append(manifest.Artifacts, collected...)
+
+		picks, err := gerrit.CurrentPicks(ctx, runner, tree.Dir)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("%s: %w", device.Codename, err)
+		}
+		if len(picks) > 0 {
+			if manifest.Picks == nil {
+				manifest.Picks = map[string][]gerrit.PickedChange{}
+			}
+			manifest.Picks[device.Codename] = picks
+		}
+	}
+
+	if len(manifest.Artifacts) > 0 {
+		checksums, err := writeChecksums(cfg.Release.Checksums, manifest)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("checksums: %w", err)
+		}
+		manifest.Checksums = checksums
+	}
+
+	if cfg.Release.Updater.URL != "" {
+		for _, device := range cfg.Fleet {
+			path, err := writeUpdater(cfg, manifest, device.Codename)
+			if err != nil {
+				return Manifest{}, fmt.Errorf("%s: updater json: %w", device.Codename, err)
+			}
+			if path != "" {
+				if manifest.Updater == nil {
+					manifest.Updater = map[string]string{}
+				}
+				manifest.Updater[device.Codename] = path
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// ManifestPath is where Write stores a manifest by default: inside its
+// release directory.
+func ManifestPath(manifest Manifest) string {
+	if manifest.Dir == "" {
+		return "artifacts/manifest.yaml"
+	}
+	return filepath.Join(manifest.Dir, "manifest.yaml")
+}
+
+// Load reads a manifest written by Write.
+func Load(path string) (Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("read manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("parse manifest: %w", err)
+	}
+	return manifest, nil
+}
+
 // Write stores the manifest on disk.
 func Write(path string, manifest Manifest) error {
 	if path == "" {
-		path = "artifacts/manifest.yaml"
+		path = ManifestPath(manifest)
 	}
 	dir := filepath.Dir(path)
 	if dir != "." && dir != "" {
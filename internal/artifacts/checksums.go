@@ -0,0 +1,137 @@
+package artifacts
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/signing"
+)
+
+// Checksum files of a release. SumsFile lists every artifact by its path
+// below the release directory, in sha256sum format; each artifact also
+// gets <name>.sha256sum beside it.
+const (
+	SumsFile  = "SHA256SUMS"
+	SumSuffix = ".sha256sum"
+)
+
+// Checksums records the checksum file of a release and its signature.
+type Checksums struct {
+	File      string `yaml:"file"`
+	Signature string `yaml:"signature,omitempty"`
+	Format    string `yaml:"format,omitempty"`
+	KeyID     string `yaml:"keyId,omitempty"`
+}
+
+// sumLine is one line of sha256sum output.
+func sumLine(sum, name string) string {
+	return fmt.Sprintf("%s  %s\n", sum, name)
+}
+
+// writeChecksums writes the per-artifact and combined checksum files of a
+// release and signs SHA256SUMS when release.checksums.sign is set.
+func writeChecksums(cfg config.ChecksumConfig, manifest Manifest) (*Checksums, error) {
+	var sums strings.Builder
+	for _, artifact := range manifest.Artifacts {
+		path := filepath.Join(manifest.Dir, filepath.FromSlash(artifact.Path))
+		if err := writeFile(path+SumSuffix, []byte(sumLine(artifact.SHA256, artifact.Name))); err != nil {
+			return nil, err
+		}
+		sums.WriteString(sumLine(artifact.SHA256, artifact.Path))
+	}
+	data := []byte(sums.String())
+	if err := writeFile(filepath.Join(manifest.Dir, SumsFile), data); err != nil {
+		return nil, err
+	}
+
+	checksums := &Checksums{File: SumsFile}
+	if cfg.Sign == "" {
+		return checksums, nil
+	}
+	if cfg.Key == "" {
+		return nil, fmt.Errorf("release.checksums.key is required to sign with %s", cfg.Sign)
+	}
+	key, err := signing.LoadPrivateKey(cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	var signature []byte
+	switch cfg.Sign {
+	case signing.FormatEd25519:
+		checksums.Signature = SumsFile + ".sig"
+		signature = ed25519.Sign(key, data)
+	case signing.FormatMinisign:
+		checksums.Signature = SumsFile + ".minisig"
+		comment := fmt.Sprintf("timestamp:%d\tfile:%s\trelease:%s", time.Now().Unix(), SumsFile, manifest.Release)
+		signature = signing.SignMinisign(key, data, comment)
+	default:
+		return nil, fmt.Errorf("unsupported release.checksums.sign %q (want %s or %s)", cfg.Sign, signing.FormatEd25519, signing.FormatMinisign)
+	}
+	if err := writeFile(filepath.Join(manifest.Dir, checksums.Signature), signature); err != nil {
+		return nil, err
+	}
+	checksums.Format = cfg.Sign
+	checksums.KeyID = signing.NewPublicKey(key.Public().(ed25519.PublicKey)).KeyID()
+	return checksums, nil
+}
+
+// writeFile replaces path through a temporary file.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// parseSums reads sha256sum output into name -> hex digest. Binary mode
+// markers ("*name") are accepted.
+func parseSums(data []byte) (map[string]string, error) {
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok || len(sum) != 64 {
+			return nil, fmt.Errorf("malformed checksum line %q", line)
+		}
+		name = strings.TrimPrefix(strings.TrimPrefix(name, " "), "*")
+		sums[name] = strings.ToLower(sum)
+	}
+	return sums, scanner.Err()
+}
+
+// PublicKey returns the key releases are verified against:
+// release.checksums.publicKey, or the public half of release.checksums.key.
+// It is nil when neither is set.
+func PublicKey(cfg config.ChecksumConfig) (*signing.PublicKey, error) {
+	switch {
+	case cfg.PublicKey != "":
+		key, err := signing.LoadPublicKey(cfg.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return &key, nil
+	case cfg.Key != "":
+		private, err := signing.LoadPrivateKey(cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		key := signing.NewPublicKey(private.Public().(ed25519.PublicKey))
+		return &key, nil
+	}
+	return nil, nil
+}
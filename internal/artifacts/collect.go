@@ -0,0 +1,294 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/bootimg"
+	"github.com/koobie777/ark-android-forge/internal/history"
+	"github.com/koobie777/ark-android-forge/internal/ota"
+	"github.com/koobie777/ark-android-forge/internal/signing"
+)
+
+// Artifact types.
+const (
+	TypeOTA         = "ota"
+	TypeTargetFiles = "target-files"
+	TypeImage       = "image"
+)
+
+// Artifact is a build output copied into a release.
+type Artifact struct {
+	Device      string `yaml:"device"`
+	Name        string `yaml:"name"`
+	Path        string `yaml:"path"`
+	Size        int64  `yaml:"size"`
+	SHA256      string `yaml:"sha256"`
+	Type        string `yaml:"type"`
+	Partition   string `yaml:"partition,omitempty"`
+	BuildType   string `yaml:"buildType,omitempty"`
+	Variant     string `yaml:"variant,omitempty"`
+	Tree        string `yaml:"tree"`
+	Repository  string `yaml:"repository"`
+	ManifestRev string `yaml:"manifestRev,omitempty"`
+	BuildID     string `yaml:"buildId,omitempty"`
+	Certificate string `yaml:"certificate,omitempty"`
+}
+
+// collectDevice copies the configured artifacts of one tree into
+// <root>/<device>. Files with identical content (lineage-*.zip is a hard
+// link of the -ota zip) are collected once, under the first pattern that
+// matched. With releaseCert set, OTAs must be signed by that certificate.
+func collectDevice(tree android.Tree, patterns []string, root string, link bool, build *history.Record, releaseCert string) ([]Artifact, error) {
+	productOut := android.ProductOut(tree)
+	sources, err := matchArtifacts(productOut, patterns)
+	if err != nil || len(sources) == 0 {
+		return nil, err
+	}
+	dir := filepath.Join(root, tree.Device)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create release dir: %w", err)
+	}
+
+	variant := buildProp(filepath.Join(productOut, "system", "build.prop"), "ro.build.type")
+	systemPatch := buildProp(filepath.Join(productOut, "system", "build.prop"), "ro.build.version.security_patch")
+	vendorPatch := buildProp(filepath.Join(productOut, "vendor", "build.prop"), "ro.vendor.build.security_patch")
+	seen := map[string]bool{}
+	var collected []Artifact
+	for _, src := range sources {
+		kind, partition := classify(filepath.Base(src))
+		var cert string
+		var err error
+		switch {
+		case kind == TypeOTA:
+			cert, err = checkOTA(src, tree.Device, releaseCert)
+		case bootPartitions[partition]:
+			patch := systemPatch
+			if partition == "vendor_boot" {
+				patch = vendorPatch
+			}
+			err = checkImage(src, tree.Device, patch)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(src), err)
+		}
+		dst := filepath.Join(dir, filepath.Base(src))
+		sum, size, err := place(src, dst, link)
+		if err != nil {
+			return nil, err
+		}
+		if seen[sum] {
+			if err := os.Remove(dst); err != nil {
+				return nil, fmt.Errorf("remove duplicate artifact: %w", err)
+			}
+			continue
+		}
+		seen[sum] = true
+
+		artifact := Artifact{
+			Device:      tree.Device,
+			Name:        filepath.Base(src),
+			Path:        filepath.ToSlash(filepath.Join(tree.Device, filepath.Base(src))),
+			Size:        size,
+			SHA256:      sum,
+			Type:        kind,
+			Partition:   partition,
+			Certificate: cert,
+			Tree:        tree.Dir,
+			Repository:  tree.Repository,
+			Variant:     variant,
+		}
+		if build != nil {
+			artifact.BuildType = build.Mode
+			artifact.BuildID = build.ID
+			artifact.ManifestRev = build.Git.ManifestRev
+			if build.Variant != "" {
+				artifact.Variant = build.Variant
+			}
+		}
+		collected = append(collected, artifact)
+	}
+	return collected, nil
+}
+
+// matchArtifacts returns the newest file per pattern, in pattern order,
+// skipping hard links of files already taken.
+func matchArtifacts(productOut string, patterns []string) ([]string, error) {
+	var sources []string
+	var taken []os.FileInfo
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(productOut, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid artifact pattern %q: %w", pattern, err)
+		}
+		var newest string
+		var newestInfo os.FileInfo
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil || !info.Mode().IsRegular() || sameAsAny(info, taken) {
+				continue
+			}
+			if newestInfo == nil || info.ModTime().After(newestInfo.ModTime()) {
+				newest, newestInfo = match, info
+			}
+		}
+		if newest != "" {
+			taken = append(taken, newestInfo)
+			sources = append(sources, newest)
+		}
+	}
+	return sources, nil
+}
+
+func sameAsAny(info os.FileInfo, infos []os.FileInfo) bool {
+	for _, other := range infos {
+		if os.SameFile(info, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// place hard-links or copies src to dst and returns its SHA-256 and size.
+// Linking falls back to copying across filesystems.
+func place(src, dst string, link bool) (string, int64, error) {
+	if link {
+		_ = os.Remove(dst)
+		if err := os.Link(src, dst); err == nil {
+			return hashFile(dst)
+		}
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", 0, fmt.Errorf("open artifact: %w", err)
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return "", 0, fmt.Errorf("create artifact copy: %w", err)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", 0, fmt.Errorf("copy %s: %w", filepath.Base(src), err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return "", 0, fmt.Errorf("store artifact: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("open artifact: %w", err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("hash %s: %w", filepath.Base(path), err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// classify derives the artifact type, and the partition of images.
+func classify(name string) (string, string) {
+	switch {
+	case strings.HasSuffix(name, ".img"):
+		return TypeImage, strings.TrimSuffix(name, ".img")
+	case strings.Contains(name, "target_files"):
+		return TypeTargetFiles, ""
+	default:
+		return TypeOTA, ""
+	}
+}
+
+// lastBuild returns the newest successful build of the tree, if any.
+// records are oldest first, as history.Load returns them.
+func lastBuild(records []history.Record, tree android.Tree) *history.Record {
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.Device == tree.Device && record.Repository == tree.Repository && record.Result == history.ResultSuccess {
+			return &record
+		}
+	}
+	return nil
+}
+
+// buildProp reads one key from a build.prop style file.
+func buildProp(path, key string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	return ota.ReadProps(file)[key]
+}
+
+// checkOTA refuses packages built for another device, with an unreadable
+// payload or, when releaseCert is set, signed by another key, so a mixed-up
+// out directory or a test-key build never reaches the mirror. It returns
+// the fingerprint of the signing certificate.
+func checkOTA(path, device, releaseCert string) (string, error) {
+	pkg, err := ota.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer pkg.Close()
+	if err := pkg.CheckDevice(device); err != nil {
+		return "", err
+	}
+
+	cert, err := pkg.Certificate()
+	if err != nil {
+		if releaseCert != "" {
+			return "", fmt.Errorf("cannot check the release key signature: %w", err)
+		}
+		return "", nil
+	}
+	fingerprint := signing.Fingerprint(cert)
+	if releaseCert != "" && fingerprint != releaseCert {
+		return "", fmt.Errorf("signed by %s, not the release key %s; release the output of 'sign' (%s/*.zip)",
+			fingerprint[:16], releaseCert[:16], android.SignedDir)
+	}
+	return fingerprint, nil
+}
+
+// bootPartitions are the images checkImage can parse.
+var bootPartitions = map[string]bool{"boot": true, "init_boot": true, "recovery": true, "vendor_boot": true}
+
+// checkImage refuses boot images signed for another device or carrying a
+// security patch level other than the build's.
+func checkImage(path, device, patch string) error {
+	image, err := bootimg.Open(path)
+	if err != nil {
+		return err
+	}
+	if built := ota.FingerprintDevice(image.Fingerprint()); built != "" && built != device {
+		return fmt.Errorf("image fingerprint is for %s, not %s", built, device)
+	}
+	if level := image.SecurityPatch(); level != "" && patch != "" && !samePatchLevel(level, patch) {
+		return fmt.Errorf("security patch level %s does not match the build's %s", level, patch)
+	}
+	return nil
+}
+
+// samePatchLevel compares patch levels, at month precision when one of
+// them (a boot header's) has no day.
+func samePatchLevel(a, b string) bool {
+	n := min(len(a), len(b))
+	return a[:n] == b[:n]
+}
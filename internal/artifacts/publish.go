@@ -0,0 +1,443 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/s3"
+	"github.com/koobie777/ark-android-forge/internal/secret"
+)
+
+// S3 multipart limits, and the part size used when release.publish.partSize
+// is unset.
+const (
+	defaultPartSize = 64 << 20
+	minPartSize     = 5 << 20
+	maxParts        = 10000
+)
+
+// Retries and first backoff used when release.publish leaves them unset.
+const (
+	defaultRetries = 4
+	defaultBackoff = 2 * time.Second
+)
+
+// SHA256Metadata is the user metadata key holding an object's hex SHA-256.
+const SHA256Metadata = "sha256"
+
+// Publish outcomes.
+const (
+	PublishUploaded  = "uploaded"
+	PublishResumed   = "resumed"
+	PublishUnchanged = "unchanged"
+	PublishPlanned   = "would upload"
+)
+
+// PublishOptions selects the release directory to publish. DryRun lists the
+// uploads without contacting the endpoint.
+type PublishOptions struct {
+	Dir    string
+	DryRun bool
+}
+
+// Published is one file of a publish.
+type Published struct {
+	Path        string
+	Key         string
+	Size        int64
+	ContentType string
+	SHA256      string
+	Parts       int
+	Action      string
+}
+
+// Publish uploads a release directory, and the Updater JSON its manifest
+// names, to release.publish. Artifacts go first and the checksums, manifest
+// and Updater JSON last, so a mirror never advertises a build it does not
+// hold. Objects that already exist with the same size and SHA-256 are
+// skipped and multipart uploads left by an interrupted publish are resumed,
+// so a failed publish is simply run again.
+func Publish(ctx context.Context, runner *execx.Runner, cfg *config.Config, opts PublishOptions) ([]Published, error) {
+	if runner == nil {
+		return nil, fmt.Errorf("runner is nil")
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("config is nil")
+	}
+	publish := cfg.Release.Publish
+	if publish.Endpoint == "" || publish.Bucket == "" {
+		return nil, fmt.Errorf("release.publish.endpoint and release.publish.bucket must be set to publish")
+	}
+	manifest, err := Load(filepath.Join(opts.Dir, "manifest.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	files, err := publishFiles(opts.Dir, manifest, publish.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	partSize := int64(publish.PartSize) << 20
+	if partSize == 0 {
+		partSize = defaultPartSize
+	}
+	if partSize < minPartSize {
+		return nil, fmt.Errorf("release.publish.partSize must be at least %d MiB", minPartSize>>20)
+	}
+
+	for i := range files {
+		file := &files[i]
+		file.Parts = partCount(file.Size, filePartSize(file.Size, partSize))
+		if opts.DryRun {
+			file.Action = PublishPlanned
+			continue
+		}
+		if file.SHA256, _, err = hashFile(file.Path); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DryRun {
+		return files, nil
+	}
+
+	accessKey, err := secret.Resolve(publish.AccessKey)
+	if err != nil {
+		return nil, fmt.Errorf("publish access key: %w", err)
+	}
+	secretKey, err := secret.Resolve(publish.SecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("publish secret key: %w", err)
+	}
+	client, err := s3.NewClient(publish.Endpoint, publish.Region, accessKey, secretKey)
+	if err != nil {
+		return nil, err
+	}
+	policy := retryPolicy{retries: publish.Retries, backoff: publish.Backoff}
+	if policy.retries <= 0 {
+		policy.retries = defaultRetries
+	}
+	if policy.backoff <= 0 {
+		policy.backoff = defaultBackoff
+	}
+	logger := runner.Logger()
+	for i := range files {
+		file := &files[i]
+		action, err := publishFile(ctx, client, policy, publish.Bucket, file, partSize)
+		if err != nil {
+			return files[:i], fmt.Errorf("publish %s: %w", file.Key, err)
+		}
+		file.Action = action
+		logger.Info().Str("key", file.Key).Int64("size", file.Size).Str("action", action).Msg("published")
+	}
+	return files, nil
+}
+
+// publishFiles lists the files of a release directory, in upload order,
+// with their object keys.
+func publishFiles(dir string, manifest Manifest, prefix string) ([]Published, error) {
+	release := manifest.Release
+	if release == "" {
+		release = filepath.Base(filepath.Clean(dir))
+	}
+	base := path.Join(strings.Trim(prefix, "/"), release)
+
+	var files []Published
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || strings.HasSuffix(file, ".tmp") {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		files = append(files, Published{
+			Path:        file,
+			Key:         path.Join(base, filepath.ToSlash(rel)),
+			Size:        info.Size(),
+			ContentType: contentType(file),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list release: %w", err)
+	}
+	sort.SliceStable(files, func(i, j int) bool { return publishOrder(files[i].Path) < publishOrder(files[j].Path) })
+
+	devices := make([]string, 0, len(manifest.Updater))
+	for device := range manifest.Updater {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	for _, device := range devices {
+		file := manifest.Updater[device]
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("updater json for %s: %w", device, err)
+		}
+		files = append(files, Published{
+			Path:        file,
+			Key:         path.Join(strings.Trim(prefix, "/"), "updater", filepath.Base(file)),
+			Size:        info.Size(),
+			ContentType: contentType(file),
+		})
+	}
+	return files, nil
+}
+
+// publishOrder puts artifacts before the files describing them.
+func publishOrder(file string) int {
+	name := filepath.Base(file)
+	switch {
+	case name == "manifest.yaml":
+		return 3
+	case strings.HasPrefix(name, SumsFile):
+		return 2
+	case strings.HasSuffix(name, SumSuffix):
+		return 1
+	}
+	return 0
+}
+
+// contentType picks the Content-Type an object is served with.
+func contentType(file string) string {
+	name := filepath.Base(file)
+	switch {
+	case strings.HasPrefix(name, SumsFile) && filepath.Ext(name) != ".sig",
+		strings.HasSuffix(name, SumSuffix):
+		return "text/plain; charset=utf-8"
+	}
+	switch filepath.Ext(name) {
+	case ".zip":
+		return "application/zip"
+	case ".img", ".bin", ".sig":
+		return "application/octet-stream"
+	case ".json":
+		return "application/json"
+	case ".yaml", ".yml":
+		return "application/yaml"
+	}
+	if kind := mime.TypeByExtension(filepath.Ext(name)); kind != "" {
+		return kind
+	}
+	return "application/octet-stream"
+}
+
+// partCount is the number of parts a file is uploaded in; 1 means a
+// single PUT.
+func partCount(size, partSize int64) int {
+	if size <= partSize {
+		return 1
+	}
+	return int((size + partSize - 1) / partSize)
+}
+
+// filePartSize grows the part size of files that would need more parts
+// than S3 allows.
+func filePartSize(size, partSize int64) int64 {
+	if size > partSize*maxParts {
+		return (size + maxParts - 1) / maxParts
+	}
+	return partSize
+}
+
+// publishFile uploads one file unless the bucket already holds it.
+func publishFile(ctx context.Context, client *s3.Client, policy retryPolicy, bucket string, file *Published, partSize int64) (string, error) {
+	existing, err := client.Head(ctx, bucket, file.Key)
+	switch {
+	case err == nil:
+		if existing.Size == file.Size && existing.Metadata[SHA256Metadata] == file.SHA256 {
+			return PublishUnchanged, nil
+		}
+	case !errors.Is(err, s3.ErrNotFound):
+		return "", err
+	}
+
+	headers := map[string]string{
+		"Content-Type":                 file.ContentType,
+		s3.MetaPrefix + SHA256Metadata: file.SHA256,
+	}
+	if filepath.Ext(file.Path) == ".json" {
+		// Updater clients poll this; caches must not hide a new build.
+		headers["Cache-Control"] = "no-cache"
+	}
+	handle, err := os.Open(file.Path)
+	if err != nil {
+		return "", fmt.Errorf("open %s: %w", file.Path, err)
+	}
+	defer handle.Close()
+
+	if file.Parts == 1 {
+		err := policy.do(ctx, func() error {
+			return client.Put(ctx, bucket, file.Key, io.NewSectionReader(handle, 0, file.Size), headers)
+		})
+		if err != nil {
+			return "", err
+		}
+		return PublishUploaded, nil
+	}
+	if file.Parts > maxParts {
+		partSize = (file.Size + maxParts - 1) / maxParts
+		file.Parts = partCount(file.Size, filePartSize(file.Size, partSize))
+	}
+	return uploadParts(ctx, client, policy, bucket, file, handle, partSize, headers)
+}
+
+// uploadParts runs a multipart upload, continuing the newest unfinished
+// upload of the key when every part it holds matches the local data by
+// size and ETag (the MD5 of an unencrypted part). Other unfinished uploads
+// of the key are aborted.
+func uploadParts(ctx context.Context, client *s3.Client, policy retryPolicy, bucket string, file *Published, handle *os.File, partSize int64, headers map[string]string) (string, error) {
+	section := func(number int) *io.SectionReader {
+		offset := int64(number-1) * partSize
+		return io.NewSectionReader(handle, offset, min(partSize, file.Size-offset))
+	}
+
+	pending, err := client.ListMultipartUploads(ctx, bucket, file.Key)
+	if err != nil {
+		return "", err
+	}
+	var uploadID string
+	kept := map[int]s3.Part{}
+	if len(pending) > 0 {
+		newest := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		parts, err := client.ListParts(ctx, bucket, file.Key, newest.UploadID)
+		if err != nil {
+			return "", err
+		}
+		uploadID = newest.UploadID
+		for _, part := range parts {
+			if part.Number > file.Parts {
+				continue
+			}
+			matches, err := samePart(section(part.Number), part)
+			if err != nil {
+				return "", err
+			}
+			if !matches {
+				pending, uploadID = append(pending, newest), ""
+				clear(kept)
+				break
+			}
+			kept[part.Number] = part
+		}
+	}
+	for _, stale := range pending {
+		if err := client.AbortMultipartUpload(ctx, bucket, file.Key, stale.UploadID); err != nil {
+			return "", err
+		}
+	}
+
+	action := PublishResumed
+	if uploadID == "" {
+		action = PublishUploaded
+		if uploadID, err = client.CreateMultipartUpload(ctx, bucket, file.Key, headers); err != nil {
+			return "", err
+		}
+	}
+
+	parts := make([]s3.Part, 0, file.Parts)
+	for number := 1; number <= file.Parts; number++ {
+		if part, ok := kept[number]; ok {
+			parts = append(parts, part)
+			continue
+		}
+		body := section(number)
+		var etag string
+		err := policy.do(ctx, func() error {
+			var err error
+			etag, err = client.UploadPart(ctx, bucket, file.Key, uploadID, number, body)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, s3.Part{Number: number, ETag: etag, Size: body.Size()})
+	}
+	if err := client.CompleteMultipartUpload(ctx, bucket, file.Key, uploadID, parts); err != nil {
+		return "", err
+	}
+	// A resumed upload carries the metadata it was created with, which is
+	// only this file's if the file did not change after its first parts.
+	if action == PublishResumed {
+		object, err := client.Head(ctx, bucket, file.Key)
+		if err != nil {
+			return "", err
+		}
+		if object.Metadata[SHA256Metadata] != file.SHA256 {
+			return "", fmt.Errorf("resumed upload was started for a different file (sha256 %s); publish again to replace it", object.Metadata[SHA256Metadata])
+		}
+	}
+	return action, nil
+}
+
+// samePart reports whether an uploaded part holds the local data.
+func samePart(section *io.SectionReader, part s3.Part) (bool, error) {
+	if part.Size != section.Size() {
+		return false, nil
+	}
+	sum, err := md5Section(section)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(sum, part.ETag), nil
+}
+
+func md5Section(section *io.SectionReader) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(section, 0, section.Size())); err != nil {
+		return "", fmt.Errorf("hash part: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// retryPolicy retries uploads that fail with a network error or a
+// server-side S3 error, backing off exponentially so a server asking to
+// slow down gets the chance to.
+type retryPolicy struct {
+	retries int
+	backoff time.Duration
+}
+
+func (p retryPolicy) do(ctx context.Context, fn func() error) error {
+	delay := p.backoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var s3err *s3.Error
+		if errors.As(err, &s3err) && s3err.Status < 500 {
+			return err
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || attempt >= p.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (gave up: %v)", err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
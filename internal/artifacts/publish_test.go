@@ -0,0 +1,648 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/s3"
+)
+
+const (
+	testAccessKey = "forge-access"
+	testSecretKey = "forge-secret/key+1"
+	testBucket    = "builds"
+)
+
+// fakeS3 is a path-style S3 endpoint holding one bucket in memory. It
+// verifies every request's SigV4 signature on its own and can fail part
+// uploads on demand.
+type fakeS3 struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	uploads map[string]*fakeUpload
+	nextID  int
+	// failPart maps a part number to the status its uploads fail with;
+	// failOnce drops the entry after the first failure.
+	failPart map[int]int
+	failOnce bool
+	// requests logs "METHOD action key" for every signed request.
+	requests []string
+	aborted  []string
+}
+
+type fakeObject struct {
+	data   []byte
+	header http.Header
+}
+
+type fakeUpload struct {
+	key       string
+	header    http.Header
+	parts     map[int][]byte
+	initiated time.Time
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, string) {
+	t.Helper()
+	fake := &fakeS3{t: t, objects: map[string]fakeObject{}, uploads: map[string]*fakeUpload{}, failPart: map[int]int{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server.URL
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := verifySigV4(r, body); err != nil {
+		f.error(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != testBucket {
+		f.error(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+	query := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	action := "object"
+	switch {
+	case query.Has("uploads"):
+		action = "uploads"
+	case query.Has("partNumber"):
+		action = "part" + query.Get("partNumber")
+	case query.Has("uploadId"):
+		action = "upload"
+	}
+	f.requests = append(f.requests, r.Method+" "+action+" "+key)
+
+	switch {
+	case r.Method == http.MethodHead:
+		object, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for name, values := range object.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("ETag", `"`+md5Hex(object.data)+`"`)
+	case r.Method == http.MethodGet && action == "uploads":
+		type upload struct {
+			Key       string
+			UploadId  string
+			Initiated string
+		}
+		var result struct {
+			XMLName xml.Name `xml:"ListMultipartUploadsResult"`
+			Uploads []upload `xml:"Upload"`
+		}
+		for id, up := range f.uploads {
+			if strings.HasPrefix(up.key, query.Get("prefix")) {
+				result.Uploads = append(result.Uploads, upload{up.key, id, up.initiated.Format(time.RFC3339Nano)})
+			}
+		}
+		sort.Slice(result.Uploads, func(i, j int) bool { return result.Uploads[i].UploadId < result.Uploads[j].UploadId })
+		f.xml(w, result)
+	case r.Method == http.MethodGet && action == "upload":
+		up, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchUpload", query.Get("uploadId"))
+			return
+		}
+		type part struct {
+			PartNumber int
+			ETag       string
+			Size       int
+		}
+		var result struct {
+			XMLName xml.Name `xml:"ListPartsResult"`
+			Parts   []part   `xml:"Part"`
+		}
+		for number, data := range up.parts {
+			result.Parts = append(result.Parts, part{number, `"` + md5Hex(data) + `"`, len(data)})
+		}
+		sort.Slice(result.Parts, func(i, j int) bool { return result.Parts[i].PartNumber < result.Parts[j].PartNumber })
+		f.xml(w, result)
+	case r.Method == http.MethodPost && action == "uploads":
+		id := f.startUpload(key, metadata(r.Header))
+		f.xml(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadId string
+		}{UploadId: id})
+	case r.Method == http.MethodPut && strings.HasPrefix(action, "part"):
+		up, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchUpload", query.Get("uploadId"))
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if status, fail := f.failPart[number]; fail {
+			if f.failOnce {
+				delete(f.failPart, number)
+			}
+			f.error(w, status, "InjectedFailure", "part "+strconv.Itoa(number))
+			return
+		}
+		up.parts[number] = body
+		w.Header().Set("ETag", `"`+md5Hex(body)+`"`)
+	case r.Method == http.MethodPost && action == "upload":
+		id := query.Get("uploadId")
+		up, ok := f.uploads[id]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchUpload", id)
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			f.error(w, http.StatusBadRequest, "MalformedXML", err.Error())
+			return
+		}
+		var data []byte
+		for _, part := range complete.Parts {
+			stored, ok := up.parts[part.PartNumber]
+			if !ok || `"`+md5Hex(stored)+`"` != part.ETag {
+				f.error(w, http.StatusBadRequest, "InvalidPart", strconv.Itoa(part.PartNumber))
+				return
+			}
+			data = append(data, stored...)
+		}
+		f.objects[key] = fakeObject{data, up.header}
+		delete(f.uploads, id)
+		f.xml(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		}{})
+	case r.Method == http.MethodDelete && action == "upload":
+		f.aborted = append(f.aborted, query.Get("uploadId"))
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{body, metadata(r.Header)}
+		w.Header().Set("ETag", `"`+md5Hex(body)+`"`)
+	default:
+		f.error(w, http.StatusNotImplemented, "NotImplemented", r.Method+" "+action)
+	}
+}
+
+// startUpload registers an upload; IDs and initiation times both increase.
+func (f *fakeS3) startUpload(key string, header http.Header) string {
+	f.nextID++
+	id := fmt.Sprintf("upload-%03d", f.nextID)
+	f.uploads[id] = &fakeUpload{
+		key:       key,
+		header:    header,
+		parts:     map[int][]byte{},
+		initiated: time.Date(2024, 6, 7, 12, 0, f.nextID, 0, time.UTC),
+	}
+	return id
+}
+
+func (f *fakeS3) xml(w http.ResponseWriter, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		f.t.Errorf("encode response: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(data)
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+// count returns how many logged requests start with prefix.
+func (f *fakeS3) count(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, request := range f.requests {
+		if strings.HasPrefix(request, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeS3) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests, f.aborted = nil, nil
+}
+
+// metadata keeps the headers S3 stores with an object.
+func metadata(header http.Header) http.Header {
+	kept := http.Header{}
+	for name, values := range header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, s3.MetaPrefix) || lower == "content-type" || lower == "cache-control" {
+			kept[name] = values
+		}
+	}
+	return kept
+}
+
+// verifySigV4 recomputes the request signature from the raw request
+// following the SigV4 specification.
+func verifySigV4(r *http.Request, body []byte) error {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("not signed with SigV4")
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(auth, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[name] = value
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != testAccessKey || credential[3] != "s3" || credential[4] != "aws4_request" {
+		return fmt.Errorf("bad credential %q", fields["Credential"])
+	}
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if sum := sha256.Sum256(body); payload != hex.EncodeToString(sum[:]) {
+		return errors.New("payload hash mismatch")
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if !strings.HasPrefix(amzDate, credential[1]) {
+		return errors.New("credential date does not match x-amz-date")
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+		if !contains(signed, required) {
+			return fmt.Errorf("%s is not signed", required)
+		}
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return err
+	}
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+
+	canonical := strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		strings.Join(pairs, "&"),
+		headers.String(),
+		fields["SignedHeaders"],
+		payload,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	scope := strings.Join(credential[1:], "/")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range credential[1:] {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	if hex.EncodeToString(mac.Sum(nil)) != fields["Signature"] {
+		return fmt.Errorf("signature mismatch for\n%s", canonical)
+	}
+	return nil
+}
+
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte("-_.~", c) >= 0:
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func contains(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// publishEnv is a release directory with a 12 MiB image, uploaded in 5 MiB
+// parts, plus checksums and a manifest.
+type publishEnv struct {
+	fake   *fakeS3
+	cfg    *config.Config
+	runner *execx.Runner
+	dir    string
+	image  []byte
+}
+
+const imageKey = "lineage/21 nightly/bacon/system+vendor.img"
+
+func newPublishEnv(t *testing.T) *publishEnv {
+	t.Helper()
+	fake, endpoint := newFakeS3(t)
+	cfg := config.Default()
+	cfg.Release.Publish = config.PublishConfig{
+		Endpoint:  endpoint,
+		Bucket:    testBucket,
+		Prefix:    "/lineage/",
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+		PartSize:  5,
+		Backoff:   time.Millisecond,
+	}
+
+	dir := filepath.Join(t.TempDir(), "21 nightly")
+	image := make([]byte, 12<<20)
+	for i := range image {
+		image[i] = byte(i * 7 / 3)
+	}
+	env := &publishEnv{fake: fake, cfg: cfg, runner: execx.NewRunner(zerolog.Nop()), dir: dir, image: image}
+	env.write(t, "bacon/system+vendor.img", image)
+	env.write(t, "bacon/lineage-21.zip", []byte("ota"))
+	env.write(t, SumsFile, []byte("sums\n"))
+	if err := Write(filepath.Join(dir, "manifest.yaml"), Manifest{Release: "21 nightly"}); err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func (e *publishEnv) write(t *testing.T, name string, data []byte) {
+	t.Helper()
+	path := filepath.Join(e.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (e *publishEnv) publish() ([]Published, error) {
+	return Publish(context.Background(), e.runner, e.cfg, PublishOptions{Dir: e.dir})
+}
+
+func actions(files []Published) map[string]string {
+	result := map[string]string{}
+	for _, file := range files {
+		result[file.Key] = file.Action
+	}
+	return result
+}
+
+func TestPublishUploadsThenSkipsUnchanged(t *testing.T) {
+	env := newPublishEnv(t)
+	files, err := env.publish()
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	var keys []string
+	for _, file := range files {
+		keys = append(keys, file.Key)
+		if file.Action != PublishUploaded {
+			t.Errorf("%s: action %q", file.Key, file.Action)
+		}
+	}
+	if last := keys[len(keys)-1]; last != "lineage/21 nightly/manifest.yaml" {
+		t.Errorf("manifest is not published last: %v", keys)
+	}
+	if keys[len(keys)-2] != "lineage/21 nightly/"+SumsFile {
+		t.Errorf("checksums are not published before the manifest: %v", keys)
+	}
+
+	object := env.fake.objects[imageKey]
+	if !bytes.Equal(object.data, env.image) {
+		t.Fatalf("stored image differs (%d bytes)", len(object.data))
+	}
+	sum := sha256.Sum256(env.image)
+	if got := object.header.Get(s3.MetaPrefix + SHA256Metadata); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("sha256 metadata = %q", got)
+	}
+	if got := env.fake.count("PUT part"); got != 3 {
+		t.Errorf("%d part uploads, want 3", got)
+	}
+	if got := env.fake.objects["lineage/21 nightly/bacon/lineage-21.zip"].header.Get("Content-Type"); got != "application/zip" {
+		t.Errorf("zip Content-Type = %q", got)
+	}
+
+	env.fake.reset()
+	files, err = env.publish()
+	if err != nil {
+		t.Fatalf("second Publish: %v", err)
+	}
+	for key, action := range actions(files) {
+		if action != PublishUnchanged {
+			t.Errorf("second publish: %s %s", key, action)
+		}
+	}
+	if got := env.fake.count("PUT"); got != 0 {
+		t.Errorf("second publish sent %d PUTs", got)
+	}
+}
+
+func TestPublishRetriesServerErrors(t *testing.T) {
+	env := newPublishEnv(t)
+	env.fake.failPart[2] = http.StatusInternalServerError
+	env.fake.failOnce = true
+	if _, err := env.publish(); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if got := env.fake.count("PUT part2"); got != 2 {
+		t.Errorf("part 2 sent %d times, want 2", got)
+	}
+	if !bytes.Equal(env.fake.objects[imageKey].data, env.image) {
+		t.Fatal("stored image differs")
+	}
+}
+
+func TestPublishBacksOffOnSlowDown(t *testing.T) {
+	env := newPublishEnv(t)
+	env.cfg.Release.Publish.Retries = 2
+	env.cfg.Release.Publish.Backoff = 20 * time.Millisecond
+	env.fake.failPart[2] = http.StatusServiceUnavailable
+
+	start := time.Now()
+	_, err := env.publish()
+	var s3err *s3.Error
+	if !errors.As(err, &s3err) || s3err.Status != http.StatusServiceUnavailable {
+		t.Fatalf("Publish error = %v, want the 503", err)
+	}
+	if got := env.fake.count("PUT part2"); got != 3 {
+		t.Errorf("part 2 sent %d times, want 1 plus 2 retries", got)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("gave up after %s, want backoff of 20ms then 40ms", elapsed)
+	}
+}
+
+func TestPublishBackoffStopsOnCancel(t *testing.T) {
+	env := newPublishEnv(t)
+	env.cfg.Release.Publish.Backoff = time.Hour
+	env.fake.failPart[2] = http.StatusServiceUnavailable
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Publish(ctx, env.runner, env.cfg, PublishOptions{Dir: env.dir})
+	if err == nil {
+		t.Fatal("Publish succeeded past a failing part")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Publish returned after %s, want it to stop waiting when ctx ends", elapsed)
+	}
+}
+
+func TestPublishResumesMatchingParts(t *testing.T) {
+	env := newPublishEnv(t)
+	// An interrupted publish stored parts 1 and 2.
+	env.fake.failPart[3] = http.StatusBadRequest
+	if _, err := env.publish(); err == nil {
+		t.Fatal("Publish succeeded despite the failing part")
+	}
+	if len(env.fake.uploads) != 1 {
+		t.Fatalf("%d pending uploads after the failed publish, want 1", len(env.fake.uploads))
+	}
+	var interrupted string
+	for id := range env.fake.uploads {
+		interrupted = id
+	}
+	delete(env.fake.failPart, 3)
+
+	// An older upload of the same key was abandoned before that.
+	stale := env.fake.startUpload(imageKey, http.Header{})
+	env.fake.uploads[stale].initiated = env.fake.uploads[interrupted].initiated.Add(-time.Hour)
+	env.fake.reset()
+
+	files, err := env.publish()
+	if err != nil {
+		t.Fatalf("resumed Publish: %v", err)
+	}
+	if got := actions(files)[imageKey]; got != PublishResumed {
+		t.Fatalf("image action = %q, want %q", got, PublishResumed)
+	}
+	if env.fake.count("PUT part1") != 0 || env.fake.count("PUT part2") != 0 || env.fake.count("PUT part3") != 1 {
+		t.Fatalf("resume re-sent parts: %v", env.fake.requests)
+	}
+	if len(env.fake.aborted) != 1 || env.fake.aborted[0] != stale {
+		t.Fatalf("aborted %v, want only %s", env.fake.aborted, stale)
+	}
+	if len(env.fake.uploads) != 0 || !bytes.Equal(env.fake.objects[imageKey].data, env.image) {
+		t.Fatal("resumed upload did not produce the image")
+	}
+}
+
+func TestPublishRestartsWhenPartsChanged(t *testing.T) {
+	env := newPublishEnv(t)
+	env.fake.failPart[3] = http.StatusBadRequest
+	if _, err := env.publish(); err == nil {
+		t.Fatal("Publish succeeded despite the failing part")
+	}
+	var interrupted string
+	for id := range env.fake.uploads {
+		interrupted = id
+	}
+
+	// Rebuilt image: same size, different first part.
+	delete(env.fake.failPart, 3)
+	env.image[0] ^= 0xff
+	env.write(t, "bacon/system+vendor.img", env.image)
+	env.fake.reset()
+
+	files, err := env.publish()
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if got := actions(files)[imageKey]; got != PublishUploaded {
+		t.Fatalf("image action = %q, want %q", got, PublishUploaded)
+	}
+	if len(env.fake.aborted) != 1 || env.fake.aborted[0] != interrupted {
+		t.Fatalf("aborted %v, want %s", env.fake.aborted, interrupted)
+	}
+	if got := env.fake.count("PUT part"); got != 3 {
+		t.Fatalf("%d part uploads, want all 3", got)
+	}
+	if !bytes.Equal(env.fake.objects[imageKey].data, env.image) {
+		t.Fatal("stored image is not the rebuilt one")
+	}
+}
+
+func TestPublishRejectsWrongSecret(t *testing.T) {
+	env := newPublishEnv(t)
+	env.cfg.Release.Publish.SecretKey = "not-the-secret"
+	// The first request is a HEAD, whose error carries no body.
+	_, err := env.publish()
+	var s3err *s3.Error
+	if !errors.As(err, &s3err) || s3err.Status != http.StatusForbidden {
+		t.Fatalf("Publish error = %v, want a signature mismatch", err)
+	}
+}
+
+func TestPublishDryRunSendsNothing(t *testing.T) {
+	env := newPublishEnv(t)
+	files, err := Publish(context.Background(), env.runner, env.cfg, PublishOptions{Dir: env.dir, DryRun: true})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	for _, file := range files {
+		if file.Action != PublishPlanned {
+			t.Errorf("%s: action %q", file.Key, file.Action)
+		}
+		if file.Key == imageKey && file.Parts != 3 {
+			t.Errorf("image planned in %d parts, want 3", file.Parts)
+		}
+	}
+	if len(env.fake.requests) != 0 {
+		t.Fatalf("dry run sent %v", env.fake.requests)
+	}
+}
@@ -0,0 +1,156 @@
+package artifacts
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/ota"
+)
+
+// otaNameRegexp matches LineageOS style names such as
+// lineage-21.0-20240131-UNOFFICIAL-waffle.zip.
+var otaNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+-(\d+(?:\.\d+)*)-\d{8}-([A-Za-z]+)-`)
+
+// UpdaterEntry is one build in the LineageOS Updater JSON.
+type UpdaterEntry struct {
+	Datetime int64  `json:"datetime"`
+	Filename string `json:"filename"`
+	ID       string `json:"id"`
+	RomType  string `json:"romtype"`
+	Size     int64  `json:"size"`
+	URL      string `json:"url"`
+	Version  string `json:"version"`
+}
+
+// UpdaterResponse is the document the Updater app fetches.
+type UpdaterResponse struct {
+	Response []UpdaterEntry `json:"response"`
+}
+
+// updaterEntry describes a collected OTA. Version and ROM type come from
+// the configuration, else from a LineageOS style file name; the Updater
+// app only offers builds whose version and ROM type match the device's
+// ro.lineage.build.version and ro.lineage.releasetype.
+func updaterEntry(cfg config.UpdaterConfig, release, dir string, artifact Artifact) (UpdaterEntry, error) {
+	pkg, err := ota.Open(filepath.Join(dir, filepath.FromSlash(artifact.Path)))
+	if err != nil {
+		return UpdaterEntry{}, err
+	}
+	datetime := pkg.Metadata.PostTimestamp
+	pkg.Close()
+	if datetime == 0 {
+		return UpdaterEntry{}, errors.New("ota metadata has no post-timestamp")
+	}
+
+	version, romType := cfg.Version, cfg.RomType
+	if match := otaNameRegexp.FindStringSubmatch(artifact.Name); match != nil {
+		version = cmp.Or(version, match[1])
+		romType = cmp.Or(romType, match[2])
+	}
+	if version == "" {
+		return UpdaterEntry{}, fmt.Errorf("cannot tell the version from the file name; set release.updater.version")
+	}
+	return UpdaterEntry{
+		Datetime: datetime,
+		Filename: artifact.Name,
+		ID:       artifact.SHA256,
+		RomType:  cmp.Or(romType, "UNOFFICIAL"),
+		Size:     artifact.Size,
+		URL:      expandUpdater(cfg.URL, artifact.Device, artifact.Name, release),
+		Version:  version,
+	}, nil
+}
+
+// writeUpdater merges the device's OTAs into its Updater JSON, newest
+// first. Entries for the same file are replaced, so re-running a release
+// does not duplicate builds.
+func writeUpdater(cfg *config.Config, manifest Manifest, device string) (string, error) {
+	updater := cfg.Release.Updater
+	path := updater.Path
+	if path == "" {
+		path = filepath.Join(cfg.Release.Dir, "updater", "{device}.json")
+	}
+	path = expandUpdater(path, device, "", manifest.Release)
+
+	var entries []UpdaterEntry
+	for _, artifact := range manifest.Artifacts {
+		if artifact.Device != device || artifact.Type != TypeOTA {
+			continue
+		}
+		entry, err := updaterEntry(updater, manifest.Release, manifest.Dir, artifact)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", artifact.Name, err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return "", nil
+	}
+
+	previous, err := LoadUpdater(path)
+	if err != nil {
+		return "", err
+	}
+	for _, old := range previous.Response {
+		if !containsUpdaterFile(entries, old.Filename) {
+			entries = append(entries, old)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Datetime > entries[j].Datetime })
+	if updater.Keep > 0 && len(entries) > updater.Keep {
+		entries = entries[:updater.Keep]
+	}
+
+	data, err := json.MarshalIndent(UpdaterResponse{Response: entries}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal updater json: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("create updater dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("write updater json: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("write updater json: %w", err)
+	}
+	return path, nil
+}
+
+// LoadUpdater reads an Updater JSON file; a missing file is empty.
+func LoadUpdater(path string) (UpdaterResponse, error) {
+	var response UpdaterResponse
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return response, nil
+		}
+		return response, fmt.Errorf("read updater json: %w", err)
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return response, fmt.Errorf("decode updater json %s: %w", path, err)
+	}
+	return response, nil
+}
+
+func containsUpdaterFile(entries []UpdaterEntry, filename string) bool {
+	for _, entry := range entries {
+		if entry.Filename == filename {
+			return true
+		}
+	}
+	return false
+}
+
+func expandUpdater(template, device, filename, release string) string {
+	return strings.NewReplacer("{device}", device, "{filename}", filename, "{release}", release).Replace(template)
+}
@@ -0,0 +1,196 @@
+package artifacts
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/koobie777/ark-android-forge/internal/signing"
+)
+
+// Finding is the result of checking one file of a release. Problem is
+// empty when the file checked out.
+type Finding struct {
+	File    string
+	Problem string
+}
+
+// OK reports whether the file checked out.
+func (f Finding) OK() bool {
+	return f.Problem == ""
+}
+
+// Verify checks a release directory against its manifest: every artifact's
+// size and SHA-256, the .sha256sum files, SHA256SUMS and, when the release
+// was signed, its signature against publicKey. Files are also checked
+// against SHA256SUMS itself, since only that is signed. Without a public key
+// a signed release is reported as unverified; with one, an unsigned release
+// is a finding, because the unsigned manifest could have dropped the
+// signature. It works entirely offline.
+func Verify(dir string, publicKey *signing.PublicKey) ([]Finding, error) {
+	manifest, err := Load(filepath.Join(dir, "manifest.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	add := func(file, format string, args ...any) {
+		finding := Finding{File: file}
+		if format != "" {
+			finding.Problem = fmt.Sprintf(format, args...)
+		}
+		findings = append(findings, finding)
+	}
+
+	hashes := map[string]string{}
+	for _, artifact := range manifest.Artifacts {
+		path := filepath.Join(dir, filepath.FromSlash(artifact.Path))
+		sum, size, err := hashFile(path)
+		hashes[artifact.Path] = sum
+		switch {
+		case err != nil:
+			add(artifact.Path, "%v", err)
+		case size != artifact.Size:
+			add(artifact.Path, "size %d, manifest says %d", size, artifact.Size)
+		case sum != artifact.SHA256:
+			add(artifact.Path, "sha256 %s, manifest says %s", sum, artifact.SHA256)
+		default:
+			add(artifact.Path, "")
+		}
+		if manifest.Checksums == nil {
+			continue
+		}
+		data, err := os.ReadFile(path + SumSuffix)
+		if err != nil {
+			add(artifact.Path+SumSuffix, "%v", err)
+			continue
+		}
+		sums, err := parseSums(data)
+		switch {
+		case err != nil:
+			add(artifact.Path+SumSuffix, "%v", err)
+		case sums[artifact.Name] != artifact.SHA256:
+			add(artifact.Path+SumSuffix, "does not list %s with the manifest's checksum", artifact.Name)
+		default:
+			add(artifact.Path+SumSuffix, "")
+		}
+	}
+	if manifest.Checksums == nil {
+		if publicKey != nil {
+			add("manifest.yaml", "lists no signed checksums to check the public key against")
+		}
+		return findings, nil
+	}
+
+	sumsFile := manifest.Checksums.File
+	data, err := os.ReadFile(filepath.Join(dir, sumsFile))
+	if err != nil {
+		add(sumsFile, "%v", err)
+		return findings, nil
+	}
+	findings = append(findings, checkSums(sumsFile, data, manifest.Artifacts))
+	findings = append(findings, checkFiles(dir, sumsFile, data, hashes)...)
+
+	signature := manifest.Checksums.Signature
+	if signature == "" {
+		if publicKey != nil {
+			add(sumsFile, "is not signed, so the public key checks nothing")
+		}
+		return findings, nil
+	}
+	switch sig, err := os.ReadFile(filepath.Join(dir, signature)); {
+	case err != nil:
+		add(signature, "%v", err)
+	case publicKey == nil:
+		add(signature, "no public key to check it against")
+	default:
+		if err := verifySignature(manifest.Checksums.Format, *publicKey, data, sig); err != nil {
+			add(signature, "%v", err)
+		} else {
+			add(signature, "")
+		}
+	}
+	return findings, nil
+}
+
+// checkSums compares SHA256SUMS with the manifest in both directions, so
+// neither can gain or lose an artifact unnoticed.
+func checkSums(file string, data []byte, artifacts []Artifact) Finding {
+	sums, err := parseSums(data)
+	if err != nil {
+		return Finding{File: file, Problem: err.Error()}
+	}
+	listed := map[string]bool{}
+	for _, artifact := range artifacts {
+		listed[artifact.Path] = true
+		switch sum, ok := sums[artifact.Path]; {
+		case !ok:
+			return Finding{File: file, Problem: fmt.Sprintf("does not list %s", artifact.Path)}
+		case sum != artifact.SHA256:
+			return Finding{File: file, Problem: fmt.Sprintf("checksum of %s differs from the manifest", artifact.Path)}
+		}
+	}
+	var extra []string
+	for name := range sums {
+		if !listed[name] {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return Finding{File: file, Problem: fmt.Sprintf("lists %v, which the manifest does not", extra)}
+	}
+	return Finding{File: file}
+}
+
+// checkFiles hashes every file SHA256SUMS lists and reports those that do
+// not match it. hashes holds the digests already computed for the manifest's
+// artifacts, empty for those that could not be read.
+func checkFiles(dir, file string, data []byte, hashes map[string]string) []Finding {
+	sums, err := parseSums(data)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var findings []Finding
+	for _, name := range names {
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			findings = append(findings, Finding{File: file, Problem: fmt.Sprintf("lists %s outside the release", name)})
+			continue
+		}
+		sum, ok := hashes[name]
+		if ok && sum == "" {
+			continue // unreadable, already reported
+		}
+		if !ok {
+			if sum, _, err = hashFile(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+				findings = append(findings, Finding{File: name, Problem: err.Error()})
+				continue
+			}
+		}
+		if sum != sums[name] {
+			findings = append(findings, Finding{File: name, Problem: fmt.Sprintf("sha256 %s, %s says %s", sum, file, sums[name])})
+		}
+	}
+	return findings
+}
+
+func verifySignature(format string, publicKey signing.PublicKey, data, signature []byte) error {
+	switch format {
+	case signing.FormatEd25519:
+		return signing.VerifyEd25519(publicKey, data, signature)
+	case signing.FormatMinisign:
+		return signing.VerifyMinisign(publicKey, data, signature)
+	case "":
+		return errors.New("manifest does not name the signature format")
+	default:
+		return fmt.Errorf("unknown signature format %q", format)
+	}
+}
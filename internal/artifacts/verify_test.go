@@ -0,0 +1,190 @@
+package artifacts
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/signing"
+)
+
+// signedRelease writes a release with one image, its checksum files and an
+// Ed25519 signature over SHA256SUMS, and returns the key to verify it with.
+func signedRelease(t *testing.T) (string, *signing.PublicKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "release.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	manifest := Manifest{Release: "21", Dir: dir}
+	manifest.Artifacts = []Artifact{writeArtifact(t, dir, "waffle/boot.img", "boot")}
+	manifest.Checksums, err = writeChecksums(config.ChecksumConfig{Sign: signing.FormatEd25519, Key: keyPath}, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(filepath.Join(dir, "manifest.yaml"), manifest); err != nil {
+		t.Fatal(err)
+	}
+	key := signing.NewPublicKey(public)
+	return dir, &key
+}
+
+func writeArtifact(t *testing.T, dir, path, content string) Artifact {
+	t.Helper()
+	full := filepath.Join(dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return Artifact{Name: filepath.Base(path), Path: path, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+// tamper swaps the image and updates the manifest to match, as someone
+// without the signing key could, then applies edit to the manifest.
+func tamper(t *testing.T, dir string, edit func(*Manifest)) {
+	t.Helper()
+	path := filepath.Join(dir, "manifest.yaml")
+	manifest, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Artifacts[0] = writeArtifact(t, dir, "waffle/boot.img", "evil")
+	if err := os.WriteFile(filepath.Join(dir, "waffle", "boot.img"+SumSuffix), []byte(sumLine(manifest.Artifacts[0].SHA256, "boot.img")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		edit(&manifest)
+	}
+	if err := Write(path, manifest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func problems(findings []Finding) []string {
+	var result []string
+	for _, finding := range findings {
+		if !finding.OK() {
+			result = append(result, finding.File+": "+finding.Problem)
+		}
+	}
+	return result
+}
+
+func TestVerifySignedRelease(t *testing.T) {
+	dir, key := signedRelease(t)
+	findings, err := Verify(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := problems(findings); len(got) != 0 {
+		t.Fatalf("problems in an intact release: %v", got)
+	}
+	if len(findings) != 4 {
+		t.Fatalf("findings = %+v, want image, .sha256sum, SHA256SUMS and signature", findings)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*Manifest)
+		want string
+	}{
+		{
+			name: "checksums block removed",
+			edit: func(m *Manifest) { m.Checksums = nil },
+			want: "manifest.yaml: lists no signed checksums",
+		},
+		{
+			name: "signature removed",
+			edit: func(m *Manifest) { m.Checksums.Signature = "" },
+			want: "SHA256SUMS: is not signed",
+		},
+		{
+			name: "manifest updated",
+			want: "waffle/boot.img: sha256 ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, key := signedRelease(t)
+			tamper(t, dir, tt.edit)
+			findings, err := Verify(dir, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := problems(findings)
+			for _, problem := range got {
+				if strings.HasPrefix(problem, tt.want) {
+					return
+				}
+			}
+			t.Fatalf("problems = %q, want one starting with %q", got, tt.want)
+		})
+	}
+}
+
+func TestVerifyRewrittenSumsFailSignature(t *testing.T) {
+	dir, key := signedRelease(t)
+	tamper(t, dir, nil)
+	manifest, err := Load(filepath.Join(dir, "manifest.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sums := sumLine(manifest.Artifacts[0].SHA256, manifest.Artifacts[0].Path)
+	if err := os.WriteFile(filepath.Join(dir, SumsFile), []byte(sums), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := Verify(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := problems(findings)
+	if len(got) != 1 || !strings.HasPrefix(got[0], "SHA256SUMS.sig: signature does not match") {
+		t.Fatalf("problems = %q, want only the signature to fail", got)
+	}
+}
+
+func TestVerifyUnsignedWithoutKey(t *testing.T) {
+	dir := t.TempDir()
+	manifest := Manifest{Release: "21", Dir: dir}
+	manifest.Artifacts = []Artifact{writeArtifact(t, dir, "waffle/boot.img", "boot")}
+	checksums, err := writeChecksums(config.ChecksumConfig{}, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Checksums = checksums
+	if err := Write(filepath.Join(dir, "manifest.yaml"), manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := Verify(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := problems(findings); len(got) != 0 {
+		t.Fatalf("problems in an unsigned release checked without a key: %v", got)
+	}
+}
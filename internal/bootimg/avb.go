@@ -0,0 +1,86 @@
+package bootimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// AVB footer and vbmeta layout, see libavb's avb_footer.h and
+// avb_vbmeta_image.h. Everything is big-endian.
+const (
+	avbFooterMagic       = "AVBf"
+	avbFooterSize        = 64
+	avbVBMetaMagic       = "AVB0"
+	avbVBMetaHeaderSize  = 256
+	avbPropertyTag       = 0
+	maxVBMetaSize        = 1 << 20
+	avbDescriptorHdrSize = 16
+)
+
+// readAVBProperties returns the property descriptors of the vbmeta struct
+// avbtool appends to images, or nil when there is no footer.
+func readAVBProperties(r io.ReaderAt, size int64) (map[string]string, error) {
+	if size < avbFooterSize {
+		return nil, nil
+	}
+	footer := make([]byte, avbFooterSize)
+	if _, err := r.ReadAt(footer, size-avbFooterSize); err != nil {
+		return nil, fmt.Errorf("read avb footer: %w", err)
+	}
+	if string(footer[:4]) != avbFooterMagic {
+		return nil, nil
+	}
+	offset := binary.BigEndian.Uint64(footer[20:28])
+	length := binary.BigEndian.Uint64(footer[28:36])
+	// Fields come from the file: compare by subtraction so no sum can wrap.
+	if length < avbVBMetaHeaderSize || length > maxVBMetaSize || offset > uint64(size) || length > uint64(size)-offset {
+		return nil, errors.New("invalid avb footer")
+	}
+
+	vbmeta := make([]byte, length)
+	if _, err := r.ReadAt(vbmeta, int64(offset)); err != nil {
+		return nil, fmt.Errorf("read vbmeta: %w", err)
+	}
+	if string(vbmeta[:4]) != avbVBMetaMagic {
+		return nil, errors.New("avb footer does not point at a vbmeta struct")
+	}
+	authSize := binary.BigEndian.Uint64(vbmeta[12:20])
+	descOffset := binary.BigEndian.Uint64(vbmeta[96:104])
+	descSize := binary.BigEndian.Uint64(vbmeta[104:112])
+	room := length - avbVBMetaHeaderSize
+	if authSize > room || descOffset > room-authSize || descSize > room-authSize-descOffset {
+		return nil, errors.New("vbmeta descriptors out of range")
+	}
+	start := avbVBMetaHeaderSize + authSize + descOffset
+	return parseAVBDescriptors(vbmeta[start : start+descSize])
+}
+
+func parseAVBDescriptors(buf []byte) (map[string]string, error) {
+	props := map[string]string{}
+	for len(buf) >= avbDescriptorHdrSize {
+		tag := binary.BigEndian.Uint64(buf[0:8])
+		following := binary.BigEndian.Uint64(buf[8:16])
+		if following > uint64(len(buf)-avbDescriptorHdrSize) {
+			return nil, errors.New("truncated vbmeta descriptor")
+		}
+		body := buf[avbDescriptorHdrSize : avbDescriptorHdrSize+following]
+		buf = buf[avbDescriptorHdrSize+following:]
+		if tag != avbPropertyTag || len(body) < 16 {
+			continue
+		}
+		keySize := binary.BigEndian.Uint64(body[0:8])
+		valueSize := binary.BigEndian.Uint64(body[8:16])
+		// key and value are each followed by a NUL.
+		room := uint64(len(body) - 16)
+		if keySize > room || valueSize > room-keySize || room-keySize-valueSize < 2 {
+			return nil, errors.New("truncated vbmeta property")
+		}
+		key := body[16 : 16+keySize]
+		value := body[16+keySize+1 : 16+keySize+1+valueSize]
+		props[string(key)] = string(bytes.TrimRight(value, "\x00"))
+	}
+	return props, nil
+}
@@ -0,0 +1,104 @@
+package bootimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// avbImage is an image body followed by a vbmeta struct and an AVB footer,
+// as avbtool add_hash_footer lays them out.
+type avbImage struct {
+	authSize   uint64
+	descOffset uint64
+	descSize   uint64 // zero means the size of descriptors
+	descriptor []byte
+	offset     uint64 // vbmeta offset in the footer; zero means the real one
+}
+
+func (a avbImage) bytes() []byte {
+	image := make([]byte, 4096)
+
+	vbmeta := make([]byte, avbVBMetaHeaderSize)
+	copy(vbmeta, avbVBMetaMagic)
+	binary.BigEndian.PutUint64(vbmeta[12:20], a.authSize)
+	binary.BigEndian.PutUint64(vbmeta[96:104], a.descOffset)
+	descSize := a.descSize
+	if descSize == 0 {
+		descSize = uint64(len(a.descriptor))
+	}
+	binary.BigEndian.PutUint64(vbmeta[104:112], descSize)
+	vbmeta = append(vbmeta, a.descriptor...)
+
+	offset := a.offset
+	if offset == 0 {
+		offset = uint64(len(image))
+	}
+	footer := make([]byte, avbFooterSize)
+	copy(footer, avbFooterMagic)
+	binary.BigEndian.PutUint64(footer[20:28], offset)
+	binary.BigEndian.PutUint64(footer[28:36], uint64(len(vbmeta)))
+
+	image = append(image, vbmeta...)
+	return append(image, footer...)
+}
+
+// property encodes a property descriptor with the given size fields.
+func property(key, value string, keySize, valueSize uint64) []byte {
+	body := make([]byte, 16)
+	binary.BigEndian.PutUint64(body[0:8], keySize)
+	binary.BigEndian.PutUint64(body[8:16], valueSize)
+	body = append(body, key+"\x00"+value+"\x00"...)
+	for len(body)%8 != 0 {
+		body = append(body, 0)
+	}
+	desc := make([]byte, avbDescriptorHdrSize)
+	binary.BigEndian.PutUint64(desc[0:8], avbPropertyTag)
+	binary.BigEndian.PutUint64(desc[8:16], uint64(len(body)))
+	return append(desc, body...)
+}
+
+func readProperties(t *testing.T, image []byte) (map[string]string, error) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("panic on crafted image: %v", r)
+		}
+	}()
+	return readAVBProperties(bytes.NewReader(image), int64(len(image)))
+}
+
+func TestReadAVBProperties(t *testing.T) {
+	const key = "com.android.build.boot.fingerprint"
+	const value = "OnePlus/waffle/waffle:14/UKQ1/1:user/release-keys"
+	props, err := readProperties(t, avbImage{descriptor: property(key, value, uint64(len(key)), uint64(len(value)))}.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if props[key] != value {
+		t.Fatalf("props = %v", props)
+	}
+}
+
+func TestReadAVBPropertiesRejectsWrappingSizes(t *testing.T) {
+	valid := property("k", "v", 1, 1)
+	tests := map[string]avbImage{
+		"footer offset": {descriptor: valid, offset: math.MaxUint64 - 100},
+		"descriptor range": {
+			descriptor: valid,
+			authSize:   math.MaxUint64 - avbVBMetaHeaderSize + 1,
+			descOffset: 100,
+			descSize:   math.MaxUint64 - 50,
+		},
+		"property key size":   {descriptor: property("k", "v", math.MaxUint64, 1)},
+		"property value size": {descriptor: property("k", "v", 1, math.MaxUint64-1)},
+	}
+	for name, image := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := readProperties(t, image.bytes()); err == nil {
+				t.Fatal("crafted image accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+// Package bootimg parses Android boot, recovery, init_boot and vendor_boot
+// image headers (versions 0 to 4) and their AVB footer properties.
+package bootimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Header magics.
+const (
+	BootMagic       = "ANDROID!"
+	VendorBootMagic = "VNDRBOOT"
+)
+
+// Image kinds.
+const (
+	KindBoot       = "boot"
+	KindVendorBoot = "vendor_boot"
+)
+
+// Boot header v3 and later use a fixed page size.
+const bootV3PageSize = 4096
+
+// Vendor ramdisk types.
+const (
+	RamdiskNone     = 0
+	RamdiskPlatform = 1
+	RamdiskRecovery = 2
+	RamdiskDLKM     = 3
+)
+
+// vendorRamdiskEntrySize is the on-disk size of a v4 vendor ramdisk table
+// entry: size, offset, type, name[32] and board_id[16].
+const vendorRamdiskEntrySize = 108
+
+// Image is a parsed image header. Fields a header version lacks are zero.
+type Image struct {
+	Kind          string
+	HeaderVersion uint32
+	HeaderSize    uint32
+	PageSize      uint32
+
+	KernelSize       uint32
+	RamdiskSize      uint32
+	SecondSize       uint32
+	RecoveryDTBOSize uint32
+	DTBSize          uint32
+	SignatureSize    uint32
+	BootconfigSize   uint32
+
+	KernelAddr  uint32
+	RamdiskAddr uint32
+	SecondAddr  uint32
+	TagsAddr    uint32
+	DTBAddr     uint64
+
+	// OSVersion (e.g. "14.0.0") and PatchLevel (e.g. "2024-01") come from
+	// the boot header's os_version; v3+ images may leave it zero and
+	// carry them in AVB properties instead.
+	OSVersion  string
+	PatchLevel string
+	Name       string
+	Cmdline    string
+
+	VendorRamdisks []VendorRamdisk
+	// Properties are the AVB hash footer's property descriptors.
+	Properties map[string]string
+}
+
+// VendorRamdisk is one entry of a v4 vendor_boot ramdisk table.
+type VendorRamdisk struct {
+	Name    string
+	Type    uint32
+	Size    uint32
+	Offset  uint32
+	BoardID [16]uint32
+}
+
+// TypeName names a vendor ramdisk type.
+func (r VendorRamdisk) TypeName() string {
+	switch r.Type {
+	case RamdiskNone:
+		return "none"
+	case RamdiskPlatform:
+		return "platform"
+	case RamdiskRecovery:
+		return "recovery"
+	case RamdiskDLKM:
+		return "dlkm"
+	}
+	return fmt.Sprintf("type %d", r.Type)
+}
+
+// Open parses the image at path.
+func Open(path string) (Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Image{}, fmt.Errorf("open image: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return Image{}, fmt.Errorf("stat image: %w", err)
+	}
+	return Parse(file, info.Size())
+}
+
+// Parse reads the header at the start of r and the AVB footer at its end.
+func Parse(r io.ReaderAt, size int64) (Image, error) {
+	header := make([]byte, 4096)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Image{}, fmt.Errorf("read image header: %w", err)
+	}
+	header = header[:n]
+	if len(header) < 8 {
+		return Image{}, errors.New("not an Android image (too short)")
+	}
+
+	var image Image
+	switch string(header[:8]) {
+	case BootMagic:
+		image, err = parseBoot(header)
+	case VendorBootMagic:
+		image, err = parseVendorBoot(r, header)
+	default:
+		return Image{}, errors.New("not an Android boot image (bad magic)")
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	image.Properties, err = readAVBProperties(r, size)
+	if err != nil {
+		return Image{}, err
+	}
+	return image, nil
+}
+
+// headerReader decodes little-endian header fields in order.
+type headerReader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (h *headerReader) take(n int) []byte {
+	if h.err != nil {
+		return make([]byte, n)
+	}
+	if h.off+n > len(h.buf) {
+		h.err = errors.New("truncated image header")
+		return make([]byte, n)
+	}
+	b := h.buf[h.off : h.off+n]
+	h.off += n
+	return b
+}
+
+func (h *headerReader) u32() uint32 {
+	return binary.LittleEndian.Uint32(h.take(4))
+}
+
+func (h *headerReader) u64() uint64 {
+	return binary.LittleEndian.Uint64(h.take(8))
+}
+
+func (h *headerReader) str(n int) string {
+	b := h.take(n)
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func parseBoot(buf []byte) (Image, error) {
+	h := &headerReader{buf: buf, off: 8}
+	image := Image{Kind: KindBoot}
+	if len(buf) >= 44 {
+		image.HeaderVersion = binary.LittleEndian.Uint32(buf[40:44])
+	}
+
+	if image.HeaderVersion >= 3 {
+		if image.HeaderVersion > 4 {
+			return Image{}, fmt.Errorf("unsupported boot header version %d", image.HeaderVersion)
+		}
+		image.PageSize = bootV3PageSize
+		image.KernelSize = h.u32()
+		image.RamdiskSize = h.u32()
+		image.OSVersion, image.PatchLevel = decodeOSVersion(h.u32())
+		image.HeaderSize = h.u32()
+		h.take(4 * 4) // reserved
+		h.u32()       // header_version
+		image.Cmdline = h.str(1536)
+		if image.HeaderVersion == 4 {
+			image.SignatureSize = h.u32()
+		}
+		return image, h.err
+	}
+
+	image.KernelSize = h.u32()
+	image.KernelAddr = h.u32()
+	image.RamdiskSize = h.u32()
+	image.RamdiskAddr = h.u32()
+	image.SecondSize = h.u32()
+	image.SecondAddr = h.u32()
+	image.TagsAddr = h.u32()
+	image.PageSize = h.u32()
+	h.u32() // header_version
+	image.OSVersion, image.PatchLevel = decodeOSVersion(h.u32())
+	image.Name = h.str(16)
+	cmdline := h.str(512)
+	h.take(8 * 4) // id
+	image.Cmdline = cmdline + h.str(1024)
+	if image.HeaderVersion >= 1 {
+		image.RecoveryDTBOSize = h.u32()
+		h.u64() // recovery_dtbo_offset
+		image.HeaderSize = h.u32()
+	}
+	if image.HeaderVersion == 2 {
+		image.DTBSize = h.u32()
+		image.DTBAddr = h.u64()
+	}
+	return image, h.err
+}
+
+func parseVendorBoot(r io.ReaderAt, buf []byte) (Image, error) {
+	h := &headerReader{buf: buf, off: 8}
+	image := Image{Kind: KindVendorBoot}
+	image.HeaderVersion = h.u32()
+	if image.HeaderVersion < 3 || image.HeaderVersion > 4 {
+		return Image{}, fmt.Errorf("unsupported vendor_boot header version %d", image.HeaderVersion)
+	}
+	image.PageSize = h.u32()
+	image.KernelAddr = h.u32()
+	image.RamdiskAddr = h.u32()
+	image.RamdiskSize = h.u32()
+	image.Cmdline = h.str(2048)
+	image.TagsAddr = h.u32()
+	image.Name = h.str(16)
+	image.HeaderSize = h.u32()
+	image.DTBSize = h.u32()
+	image.DTBAddr = h.u64()
+	if image.HeaderVersion < 4 {
+		return image, h.err
+	}
+
+	tableSize := h.u32()
+	entries := h.u32()
+	entrySize := h.u32()
+	image.BootconfigSize = h.u32()
+	if h.err != nil {
+		return Image{}, h.err
+	}
+	if image.PageSize == 0 {
+		return Image{}, errors.New("vendor_boot page size is zero")
+	}
+	if entries == 0 {
+		return image, nil
+	}
+	if entrySize < vendorRamdiskEntrySize || uint64(entries)*uint64(entrySize) > uint64(tableSize) || tableSize > 1<<20 {
+		return Image{}, fmt.Errorf("invalid vendor ramdisk table (%d entries of %d bytes in %d)", entries, entrySize, tableSize)
+	}
+
+	page := image.PageSize
+	offset := align(image.HeaderSize, page) + align(image.RamdiskSize, page) + align(image.DTBSize, page)
+	table := make([]byte, tableSize)
+	if _, err := r.ReadAt(table, int64(offset)); err != nil {
+		return Image{}, fmt.Errorf("read vendor ramdisk table: %w", err)
+	}
+	for i := uint32(0); i < entries; i++ {
+		e := &headerReader{buf: table[i*entrySize : (i+1)*entrySize]}
+		ramdisk := VendorRamdisk{Size: e.u32(), Offset: e.u32(), Type: e.u32(), Name: e.str(32)}
+		for j := range ramdisk.BoardID {
+			ramdisk.BoardID[j] = e.u32()
+		}
+		image.VendorRamdisks = append(image.VendorRamdisks, ramdisk)
+	}
+	return image, nil
+}
+
+// decodeOSVersion splits os_version: A.B.C in the top 21 bits and the patch
+// level as years since 2000 and month in the low 11.
+func decodeOSVersion(v uint32) (string, string) {
+	if v == 0 {
+		return "", ""
+	}
+	var version, patch string
+	if release := v >> 11; release != 0 {
+		version = fmt.Sprintf("%d.%d.%d", release>>14, (release>>7)&0x7f, release&0x7f)
+	}
+	if level := v & 0x7ff; level != 0 {
+		patch = fmt.Sprintf("%04d-%02d", 2000+(level>>4), level&0xf)
+	}
+	return version, patch
+}
+
+func align(n, page uint32) uint32 {
+	return (n + page - 1) / page * page
+}
+
+// Fingerprint returns the build fingerprint avbtool recorded in the
+// footer (com.android.build.<partition>.fingerprint), if any.
+func (i Image) Fingerprint() string {
+	return i.buildProperty("fingerprint")
+}
+
+// SecurityPatch returns the header's patch level (YYYY-MM), else the
+// footer's security_patch property (YYYY-MM-DD).
+func (i Image) SecurityPatch() string {
+	if i.PatchLevel != "" {
+		return i.PatchLevel
+	}
+	return i.buildProperty("security_patch")
+}
+
+func (i Image) buildProperty(name string) string {
+	for key, value := range i.Properties {
+		if strings.HasPrefix(key, "com.android.build.") && strings.HasSuffix(key, "."+name) {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,301 @@
+package ccache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+)
+
+// historyFile records per-build stats deltas inside the cache directory.
+const historyFile = "arkforge-builds.jsonl"
+
+var legacyStatRegexp = regexp.MustCompile(`^(cache hit \(direct\)|cache hit \(preprocessed\)|cache miss|files in cache)\s+(\d+)`)
+
+// Stats is a snapshot of ccache counters.
+type Stats struct {
+	DirectHits       int64 `json:"directHits"`
+	PreprocessedHits int64 `json:"preprocessedHits"`
+	Misses           int64 `json:"misses"`
+	Files            int64 `json:"files"`
+	SizeBytes        int64 `json:"sizeBytes"`
+}
+
+// Hits returns direct plus preprocessed hits.
+func (s Stats) Hits() int64 {
+	return s.DirectHits + s.PreprocessedHits
+}
+
+// HitRate returns hits/(hits+misses) as a percentage.
+func (s Stats) HitRate() float64 {
+	total := s.Hits() + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits()) * 100 / float64(total)
+}
+
+// Sub returns the counter delta between s and an earlier snapshot. Size and
+// file counts are absolute gauges and keep the later value.
+func (s Stats) Sub(before Stats) Stats {
+	return Stats{
+		DirectHits:       s.DirectHits - before.DirectHits,
+		PreprocessedHits: s.PreprocessedHits - before.PreprocessedHits,
+		Misses:           s.Misses - before.Misses,
+		Files:            s.Files,
+		SizeBytes:        s.SizeBytes,
+	}
+}
+
+// BuildStats is the ccache delta of one build, with the absolute snapshots
+// it was taken from. Shared marks a delta that also counts another build
+// using the cache at the same time, so it is only approximate.
+type BuildStats struct {
+	Time       time.Time `json:"time"`
+	Device     string    `json:"device"`
+	Repository string    `json:"repository"`
+	Mode       string    `json:"mode"`
+	Delta      Stats     `json:"delta"`
+	Before     Stats     `json:"before"`
+	After      Stats     `json:"after"`
+	Shared     bool      `json:"shared,omitempty"`
+}
+
+// users tracks the builds of this process using each cache directory.
+// Builds of other processes are kept out by the host build lock.
+var users struct {
+	sync.Mutex
+	dirs map[string]map[*cacheUse]bool
+}
+
+type cacheUse struct {
+	shared bool
+}
+
+// Track marks a build as using the cache in dir until the returned func is
+// called. That func reports whether another build of this process used the
+// cache in between, which makes the build's stats delta approximate.
+func Track(dir string) func() bool {
+	users.Lock()
+	defer users.Unlock()
+	if users.dirs == nil {
+		users.dirs = map[string]map[*cacheUse]bool{}
+	}
+	if users.dirs[dir] == nil {
+		users.dirs[dir] = map[*cacheUse]bool{}
+	}
+	use := &cacheUse{shared: len(users.dirs[dir]) > 0}
+	for other := range users.dirs[dir] {
+		other.shared = true
+	}
+	users.dirs[dir][use] = true
+
+	return func() bool {
+		users.Lock()
+		defer users.Unlock()
+		delete(users.dirs[dir], use)
+		return use.shared
+	}
+}
+
+// Dir returns the cache directory for a repository.
+func Dir(cfg *config.Config, repository string) string {
+	dir := cfg.CCache.Dir
+	if dir == "" {
+		dir = filepath.Join(cfg.Build.Workspace, ".ccache")
+	}
+	if strings.HasPrefix(dir, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, dir[2:])
+		}
+	}
+	if !cfg.CCache.Shared && repository != "" {
+		dir = filepath.Join(dir, repository)
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return dir
+}
+
+// Env returns the variables that make soong use ccache, or nil when ccache
+// is disabled.
+func Env(cfg *config.Config, repository string) (map[string]string, error) {
+	if !cfg.CCache.Enabled {
+		return nil, nil
+	}
+	binary, err := exec.LookPath("ccache")
+	if err != nil {
+		return nil, fmt.Errorf("ccache enabled but not found in PATH: %w", err)
+	}
+
+	env := map[string]string{
+		"USE_CCACHE":  "1",
+		"CCACHE_EXEC": binary,
+		"CCACHE_DIR":  Dir(cfg, repository),
+	}
+	if cfg.CCache.Compression {
+		env["CCACHE_COMPRESS"] = "1"
+	} else {
+		env["CCACHE_NOCOMPRESS"] = "1"
+	}
+	return env, nil
+}
+
+// Configure creates the cache directory and applies the size limit and
+// compression setting, like the bash engine's `ccache -M`.
+func Configure(ctx context.Context, runner *execx.Runner, cfg *config.Config, repository string) error {
+	if !cfg.CCache.Enabled {
+		return nil
+	}
+	dir := Dir(cfg, repository)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create ccache dir: %w", err)
+	}
+
+	env := map[string]string{"CCACHE_DIR": dir}
+	if cfg.CCache.MaxSize != "" {
+		if _, err := runner.Output(ctx, execx.Command{Name: "ccache", Args: []string{"-M", cfg.CCache.MaxSize}, Env: env}); err != nil {
+			return fmt.Errorf("set ccache size: %w", err)
+		}
+	}
+	compression := strconv.FormatBool(cfg.CCache.Compression)
+	if _, err := runner.Output(ctx, execx.Command{Name: "ccache", Args: []string{"-o", "compression=" + compression}, Env: env}); err != nil {
+		return fmt.Errorf("set ccache compression: %w", err)
+	}
+	return nil
+}
+
+// Snapshot reads the counters of the cache in dir. It prefers the
+// machine-readable --print-stats of ccache 4 and falls back to `ccache -s`.
+func Snapshot(ctx context.Context, runner *execx.Runner, dir string) (Stats, error) {
+	env := map[string]string{"CCACHE_DIR": dir}
+	if out, err := runner.Output(ctx, execx.Command{Name: "ccache", Args: []string{"--print-stats"}, Env: env}); err == nil {
+		return parsePrintStats(out), nil
+	}
+	out, err := runner.Output(ctx, execx.Command{Name: "ccache", Args: []string{"-s"}, Env: env})
+	if err != nil {
+		return Stats{}, fmt.Errorf("read ccache stats: %w", err)
+	}
+	return parseLegacyStats(out), nil
+}
+
+func parsePrintStats(out string) Stats {
+	var stats Stats
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "direct_cache_hit":
+			stats.DirectHits = value
+		case "preprocessed_cache_hit":
+			stats.PreprocessedHits = value
+		case "cache_miss":
+			stats.Misses = value
+		case "files_in_cache":
+			stats.Files = value
+		case "cache_size_kibibyte":
+			stats.SizeBytes = value * 1024
+		}
+	}
+	return stats
+}
+
+func parseLegacyStats(out string) Stats {
+	var stats Stats
+	for _, line := range strings.Split(out, "\n") {
+		m := legacyStatRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		value, _ := strconv.ParseInt(m[2], 10, 64)
+		switch m[1] {
+		case "cache hit (direct)":
+			stats.DirectHits = value
+		case "cache hit (preprocessed)":
+			stats.PreprocessedHits = value
+		case "cache miss":
+			stats.Misses = value
+		case "files in cache":
+			stats.Files = value
+		}
+	}
+	return stats
+}
+
+// Record appends a build's stats delta to the cache directory history.
+func Record(dir string, build BuildStats) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create ccache dir: %w", err)
+	}
+	data, err := json.Marshal(build)
+	if err != nil {
+		return fmt.Errorf("marshal ccache stats: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, historyFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open ccache history: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write ccache history: %w", err)
+	}
+	return nil
+}
+
+// History returns recorded build deltas, oldest first.
+func History(dir string) ([]BuildStats, error) {
+	file, err := os.Open(filepath.Join(dir, historyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open ccache history: %w", err)
+	}
+	defer file.Close()
+
+	var builds []BuildStats
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var build BuildStats
+		if err := json.Unmarshal(scanner.Bytes(), &build); err != nil {
+			continue
+		}
+		builds = append(builds, build)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read ccache history: %w", err)
+	}
+	return builds, nil
+}
+
+// Clear empties the cache in dir while keeping its configuration.
+func Clear(ctx context.Context, runner *execx.Runner, dir string, dryRun bool) error {
+	cmd := execx.Command{
+		Name:   "ccache",
+		Args:   []string{"--clear"},
+		Env:    map[string]string{"CCACHE_DIR": dir},
+		DryRun: dryRun,
+	}
+	if err := runner.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("clear ccache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package ccache
+
+import "testing"
+
+func TestTrackMarksOverlappingBuilds(t *testing.T) {
+	first := Track("/cache")
+	other := Track("/elsewhere")
+	second := Track("/cache")
+	if !second() {
+		t.Fatal("second build not marked shared")
+	}
+	if !first() {
+		t.Fatal("first build not marked shared after the second started")
+	}
+	if other() {
+		t.Fatal("build on another cache marked shared")
+	}
+
+	alone := Track("/cache")
+	if alone() {
+		t.Fatal("build after the others finished marked shared")
+	}
+}
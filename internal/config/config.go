@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v3"
@@ -15,20 +16,226 @@ import (
 
 // Config captures the runtime configuration for ARKFORGE.
 type Config struct {
-	File      string        `mapstructure:"-" yaml:"-"`
-	Version   string        `mapstructure:"version" yaml:"version"`
-	Commander string        `mapstructure:"commander" yaml:"commander"`
-	Mode      string        `mapstructure:"mode" yaml:"mode"`
-	Jobs      int           `mapstructure:"jobs" yaml:"jobs"`
-	Build     BuildConfig   `mapstructure:"build" yaml:"build"`
-	Theme     ThemeConfig   `mapstructure:"theme" yaml:"theme"`
-	Fleet     []FleetDevice `mapstructure:"fleet" yaml:"fleet"`
+	File      string         `mapstructure:"-" yaml:"-"`
+	Version   string         `mapstructure:"version" yaml:"version"`
+	Commander string         `mapstructure:"commander" yaml:"commander"`
+	Mode      string         `mapstructure:"mode" yaml:"mode"`
+	Jobs      int            `mapstructure:"jobs" yaml:"jobs"`
+	Build     BuildConfig    `mapstructure:"build" yaml:"build"`
+	Theme     ThemeConfig    `mapstructure:"theme" yaml:"theme"`
+	Fleet     []FleetDevice  `mapstructure:"fleet" yaml:"fleet"`
+	Repos     []Repository   `mapstructure:"repositories" yaml:"repositories"`
+	Patches   []PatchSet     `mapstructure:"patches" yaml:"patches,omitempty"`
+	Gerrit    GerritConfig   `mapstructure:"gerrit" yaml:"gerrit"`
+	Recovery  RecoveryConfig `mapstructure:"recovery" yaml:"recovery"`
+	CCache    CCacheConfig   `mapstructure:"ccache" yaml:"ccache"`
+	History   HistoryConfig  `mapstructure:"history" yaml:"history"`
+	Pipelines []Pipeline     `mapstructure:"pipelines" yaml:"pipelines,omitempty"`
+	Schedules []Schedule     `mapstructure:"schedules" yaml:"schedules,omitempty"`
+	API       APIConfig      `mapstructure:"api" yaml:"api"`
+	Notify    NotifyConfig   `mapstructure:"notifications" yaml:"notifications,omitempty"`
+	Metrics   MetricsConfig  `mapstructure:"metrics" yaml:"metrics"`
+	Release   ReleaseConfig  `mapstructure:"release" yaml:"release"`
+	Signing   SigningConfig  `mapstructure:"signing" yaml:"signing,omitempty"`
 }
 
-// BuildConfig describes build defaults.
+// BuildConfig describes build defaults. Timeout bounds one build, sync or
+// signing run (e.g. 12h); zero lets them run as long as they need.
 type BuildConfig struct {
-	Workspace   string `mapstructure:"workspace" yaml:"workspace"`
-	DefaultType string `mapstructure:"defaultType" yaml:"defaultType"`
+	Workspace   string        `mapstructure:"workspace" yaml:"workspace"`
+	DefaultType string        `mapstructure:"defaultType" yaml:"defaultType"`
+	Timeout     time.Duration `mapstructure:"timeout" yaml:"timeout,omitempty"`
+}
+
+// CCacheConfig controls compiler caching for builds. An empty Dir means
+// <workspace>/.ccache; with Shared disabled every ROM gets its own subdir.
+type CCacheConfig struct {
+	Enabled     bool   `mapstructure:"enabled" yaml:"enabled"`
+	Dir         string `mapstructure:"dir" yaml:"dir,omitempty"`
+	MaxSize     string `mapstructure:"maxSize" yaml:"maxSize"`
+	Compression bool   `mapstructure:"compression" yaml:"compression"`
+	Shared      bool   `mapstructure:"shared" yaml:"shared"`
+}
+
+// Pipeline is a named sequence of stages run by 'run <pipeline>'. Devices
+// default to the fleet primary; stages may override them.
+type Pipeline struct {
+	Name     string          `mapstructure:"name" yaml:"name"`
+	Devices  []string        `mapstructure:"devices" yaml:"devices,omitempty"`
+	Parallel int             `mapstructure:"parallel" yaml:"parallel,omitempty"`
+	Stages   []PipelineStage `mapstructure:"stages" yaml:"stages"`
+}
+
+// PipelineStage is one step of a pipeline. Type is sync, build, clean, shell,
+// sign or release; When is success (default), failure or always; OnFailure is
+// stop (default) or continue.
+type PipelineStage struct {
+	Name      string   `mapstructure:"name" yaml:"name"`
+	Type      string   `mapstructure:"type" yaml:"type"`
+	Devices   []string `mapstructure:"devices" yaml:"devices,omitempty"`
+	Target    string   `mapstructure:"target" yaml:"target,omitempty"`
+	Mode      string   `mapstructure:"mode" yaml:"mode,omitempty"`
+	Run       string   `mapstructure:"run" yaml:"run,omitempty"`
+	Output    string   `mapstructure:"output" yaml:"output,omitempty"`
+	Force     bool     `mapstructure:"force" yaml:"force,omitempty"`
+	When      string   `mapstructure:"when" yaml:"when,omitempty"`
+	OnFailure string   `mapstructure:"onFailure" yaml:"onFailure,omitempty"`
+	Retries   int      `mapstructure:"retries" yaml:"retries,omitempty"`
+}
+
+// Schedule triggers a pipeline, or a build of Target for Devices, on a cron
+// expression. SkipUnchanged skips runs when upstream has not moved since the
+// last successful run.
+type Schedule struct {
+	Name          string   `mapstructure:"name" yaml:"name"`
+	Cron          string   `mapstructure:"cron" yaml:"cron"`
+	Pipeline      string   `mapstructure:"pipeline" yaml:"pipeline,omitempty"`
+	Devices       []string `mapstructure:"devices" yaml:"devices,omitempty"`
+	Target        string   `mapstructure:"target" yaml:"target,omitempty"`
+	SkipUnchanged bool     `mapstructure:"skipUnchanged" yaml:"skipUnchanged,omitempty"`
+}
+
+// APIConfig configures 'serve'. The token may also come from ARK_API_TOKEN,
+// which keeps it out of forge.yaml.
+type APIConfig struct {
+	Listen string `mapstructure:"listen" yaml:"listen"`
+	Token  string `mapstructure:"token" yaml:"token,omitempty"`
+}
+
+// ReleaseConfig controls artifact collection by 'release'. Artifacts are
+// glob patterns matched in out/target/product/<device>; when a pattern
+// matches several files only the newest is taken. Link hard-links files
+// into the release directory instead of copying them.
+type ReleaseConfig struct {
+	Dir       string         `mapstructure:"dir" yaml:"dir"`
+	Artifacts []string       `mapstructure:"artifacts" yaml:"artifacts"`
+	Link      bool           `mapstructure:"link" yaml:"link,omitempty"`
+	Updater   UpdaterConfig  `mapstructure:"updater" yaml:"updater,omitempty"`
+	Checksums ChecksumConfig `mapstructure:"checksums" yaml:"checksums,omitempty"`
+	Publish   PublishConfig  `mapstructure:"publish" yaml:"publish,omitempty"`
+}
+
+// PublishConfig uploads releases to an S3-compatible bucket with 'release
+// --publish', addressing it path-style (<endpoint>/<bucket>/<key>) as MinIO
+// expects. A release lands below <prefix>/<release>/ and Updater JSON in
+// <prefix>/updater/. AccessKey and SecretKey are references (env:NAME or
+// file:PATH) resolved only while publishing. Files larger than PartSize
+// MiB are uploaded in parts, which an interrupted publish resumes. Network
+// errors and 5xx responses (such as SlowDown) are retried Retries times,
+// waiting Backoff before the first retry and twice as long before each next.
+type PublishConfig struct {
+	Endpoint  string        `mapstructure:"endpoint" yaml:"endpoint,omitempty"`
+	Bucket    string        `mapstructure:"bucket" yaml:"bucket,omitempty"`
+	Region    string        `mapstructure:"region" yaml:"region,omitempty"`
+	Prefix    string        `mapstructure:"prefix" yaml:"prefix,omitempty"`
+	AccessKey string        `mapstructure:"accessKey" yaml:"accessKey,omitempty"`
+	SecretKey string        `mapstructure:"secretKey" yaml:"secretKey,omitempty"`
+	PartSize  int           `mapstructure:"partSize" yaml:"partSize,omitempty"`
+	Retries   int           `mapstructure:"retries" yaml:"retries,omitempty"`
+	Backoff   time.Duration `mapstructure:"backoff" yaml:"backoff,omitempty"`
+}
+
+// ChecksumConfig signs the SHA256SUMS file 'release' writes. Sign is
+// ed25519 (a raw signature in SHA256SUMS.sig) or minisign
+// (SHA256SUMS.minisig); empty leaves it unsigned. Key is the PKCS#8 PEM
+// Ed25519 private key, as 'openssl genpkey -algorithm ed25519' writes it.
+// PublicKey, a PEM or minisign .pub file, is what 'verify' checks against
+// when no --public-key is given; by default it is derived from Key.
+type ChecksumConfig struct {
+	Sign      string `mapstructure:"sign" yaml:"sign,omitempty"`
+	Key       string `mapstructure:"key" yaml:"key,omitempty"`
+	PublicKey string `mapstructure:"publicKey" yaml:"publicKey,omitempty"`
+}
+
+// UpdaterConfig enables LineageOS Updater JSON for collected OTAs. URL is
+// the download URL template ({device}, {filename}, {release}); Path is the
+// per-device JSON file ({device}), by default
+// <release.dir>/updater/{device}.json. Keep bounds the entries per device
+// (0 keeps all). Version and RomType override what the OTA file name says.
+type UpdaterConfig struct {
+	URL     string `mapstructure:"url" yaml:"url,omitempty"`
+	Path    string `mapstructure:"path" yaml:"path,omitempty"`
+	Version string `mapstructure:"version" yaml:"version,omitempty"`
+	RomType string `mapstructure:"romType" yaml:"romType,omitempty"`
+	Keep    int    `mapstructure:"keep" yaml:"keep,omitempty"`
+}
+
+// SigningConfig configures 'sign' and the sign pipeline stage. Keys is the
+// key directory (releasekey.x509.pem/.pk8, platform, shared, ... as
+// make_key writes them); while it is empty builds stay signed with test
+// keys. Password references the keys' password as env:NAME or file:PATH and
+// is resolved only while signing. Args go to sign_target_files_apks and
+// OTAArgs to ota_from_target_files. Subject is used by 'sign keygen'.
+type SigningConfig struct {
+	Keys     string   `mapstructure:"keys" yaml:"keys,omitempty"`
+	Password string   `mapstructure:"password" yaml:"password,omitempty"`
+	Args     []string `mapstructure:"args" yaml:"args,omitempty"`
+	OTAArgs  []string `mapstructure:"otaArgs" yaml:"otaArgs,omitempty"`
+	Subject  string   `mapstructure:"subject" yaml:"subject,omitempty"`
+}
+
+// MetricsConfig configures 'metrics serve'.
+type MetricsConfig struct {
+	Listen string `mapstructure:"listen" yaml:"listen"`
+}
+
+// NotifyConfig lists the webhooks told about builds and syncs. BaseURL,
+// when set, is the address of 'serve' or 'dashboard' and turns artifacts
+// and logs into links.
+type NotifyConfig struct {
+	BaseURL string         `mapstructure:"baseURL" yaml:"baseURL,omitempty"`
+	Retries int            `mapstructure:"retries" yaml:"retries,omitempty"`
+	Targets []NotifyTarget `mapstructure:"targets" yaml:"targets,omitempty"`
+}
+
+// NotifyTarget is a webhook. Type selects the payload format: webhook
+// (generic JSON), discord, slack, telegram or matrix. Events filters by
+// event name or prefix ("build", "sync.failure"); empty means all.
+type NotifyTarget struct {
+	Name    string            `mapstructure:"name" yaml:"name"`
+	Type    string            `mapstructure:"type" yaml:"type"`
+	URL     string            `mapstructure:"url" yaml:"url,omitempty"`
+	Events  []string          `mapstructure:"events" yaml:"events,omitempty"`
+	Headers map[string]string `mapstructure:"headers" yaml:"headers,omitempty"`
+	Token   string            `mapstructure:"token" yaml:"token,omitempty"`
+	ChatID  string            `mapstructure:"chatId" yaml:"chatId,omitempty"`
+	Room    string            `mapstructure:"room" yaml:"room,omitempty"`
+}
+
+// HistoryConfig locates the build history store. An empty Path means
+// <workspace>/.arkforge/history.jsonl.
+type HistoryConfig struct {
+	Path string `mapstructure:"path" yaml:"path,omitempty"`
+}
+
+// GerritConfig points at the code review instance used by 'pick'.
+type GerritConfig struct {
+	URL string `mapstructure:"url" yaml:"url"`
+}
+
+// RecoveryConfig describes recovery flavors and where packaged images go.
+type RecoveryConfig struct {
+	Output  string           `mapstructure:"output" yaml:"output"`
+	Flavors []RecoveryFlavor `mapstructure:"flavors" yaml:"flavors"`
+}
+
+// RecoveryFlavor is a recovery project (TWRP, OrangeFox) with the minimal
+// manifest used to initialise its tree.
+type RecoveryFlavor struct {
+	Name     string            `mapstructure:"name" yaml:"name"`
+	Label    string            `mapstructure:"label" yaml:"label"`
+	Manifest string            `mapstructure:"manifest" yaml:"manifest"`
+	Branch   string            `mapstructure:"branch" yaml:"branch"`
+	Env      map[string]string `mapstructure:"env" yaml:"env,omitempty"`
+}
+
+// DeviceRecovery holds per-device recovery settings. Env keys are exported
+// upper-cased, so FOX_* variables survive Viper's key folding.
+type DeviceRecovery struct {
+	Flavor    string            `mapstructure:"flavor" yaml:"flavor,omitempty"`
+	Partition string            `mapstructure:"partition" yaml:"partition,omitempty"`
+	Version   string            `mapstructure:"version" yaml:"version,omitempty"`
+	Env       map[string]string `mapstructure:"env" yaml:"env,omitempty"`
 }
 
 // ThemeConfig controls TUI appearance.
@@ -39,10 +246,52 @@ type ThemeConfig struct {
 
 // FleetDevice describes a device that can be built.
 type FleetDevice struct {
-	Name       string `mapstructure:"name" yaml:"name"`
-	Codename   string `mapstructure:"codename" yaml:"codename"`
-	Role       string `mapstructure:"role" yaml:"role"`
-	Repository string `mapstructure:"repository" yaml:"repository"`
+	Name       string         `mapstructure:"name" yaml:"name"`
+	Codename   string         `mapstructure:"codename" yaml:"codename"`
+	Role       string         `mapstructure:"role" yaml:"role"`
+	Repository string         `mapstructure:"repository" yaml:"repository"`
+	Recovery   DeviceRecovery `mapstructure:"recovery" yaml:"recovery,omitempty"`
+}
+
+// Repository is a catalog entry describing a ROM source and how to build it.
+type Repository struct {
+	Name     string    `mapstructure:"name" yaml:"name"`
+	Manifest string    `mapstructure:"manifest" yaml:"manifest,omitempty"`
+	Branch   string    `mapstructure:"branch" yaml:"branch,omitempty"`
+	ROM      ROMTarget `mapstructure:"rom" yaml:"rom"`
+}
+
+// ROMTarget is a ROM's full-build entry point and the outputs it produces
+// in out/target/product/<device>.
+type ROMTarget struct {
+	Command string   `mapstructure:"command" yaml:"command"`
+	Target  string   `mapstructure:"target" yaml:"target"`
+	Package string   `mapstructure:"package" yaml:"package"`
+	Images  []string `mapstructure:"images" yaml:"images,omitempty"`
+	Payload bool     `mapstructure:"payload" yaml:"payload"`
+}
+
+// PatchSet groups local patches carried on top of a ROM tree. Empty
+// Repository or Device fields match every tree.
+type PatchSet struct {
+	Name       string         `mapstructure:"name" yaml:"name"`
+	Repository string         `mapstructure:"repository" yaml:"repository,omitempty"`
+	Device     string         `mapstructure:"device" yaml:"device,omitempty"`
+	Projects   []PatchProject `mapstructure:"projects" yaml:"projects"`
+}
+
+// PatchProject lists the patches for a single project path inside the tree.
+// A relative Dir is resolved against the directory of the config file.
+type PatchProject struct {
+	Path  string      `mapstructure:"path" yaml:"path"`
+	Dir   string      `mapstructure:"dir" yaml:"dir,omitempty"`
+	Picks []PatchPick `mapstructure:"picks" yaml:"picks,omitempty"`
+}
+
+// PatchPick references a commit fetched from a remote and cherry-picked.
+type PatchPick struct {
+	Remote string `mapstructure:"remote" yaml:"remote"`
+	Ref    string `mapstructure:"ref" yaml:"ref"`
 }
 
 // Load returns the parsed configuration or a default if no file exists.
@@ -173,6 +422,12 @@ func loadLegacy(path string) (*Config, error) {
 	if defaultType, ok := kv["ARK_DEFAULT_BUILD_TYPE"]; ok {
 		cfg.Build.DefaultType = defaultType
 	}
+	if enabled, ok := kv["ARK_CCACHE_ENABLED"]; ok {
+		cfg.CCache.Enabled = enabled == "true"
+	}
+	if size, ok := kv["ARK_CCACHE_SIZE"]; ok && size != "" {
+		cfg.CCache.MaxSize = size
+	}
 	if jobsStr, ok := kv["ARK_DEFAULT_JOBS"]; ok {
 		if jobs, err := strconv.Atoi(jobsStr); err == nil && jobs > 0 {
 			cfg.Jobs = jobs
@@ -196,6 +451,75 @@ func Default() *Config {
 			Enabled: true,
 			Accent:  "cyan",
 		},
+		Gerrit: GerritConfig{
+			URL: "https://review.lineageos.org",
+		},
+		CCache: CCacheConfig{
+			Enabled:     false,
+			MaxSize:     "50G",
+			Compression: true,
+			Shared:      true,
+		},
+		API: APIConfig{
+			Listen: "127.0.0.1:8088",
+		},
+		Metrics: MetricsConfig{
+			Listen: "127.0.0.1:9477",
+		},
+		Release: ReleaseConfig{
+			Dir: "artifacts/releases",
+			Artifacts: []string{
+				"lineage-*.zip",
+				"*-ota*.zip",
+				"boot.img",
+				"init_boot.img",
+				"vendor_boot.img",
+				"recovery.img",
+				"dtbo.img",
+			},
+		},
+		Recovery: RecoveryConfig{
+			Output: "artifacts/recovery",
+			Flavors: []RecoveryFlavor{
+				{
+					Name:     "twrp",
+					Label:    "TWRP",
+					Manifest: "https://github.com/minimal-manifest-twrp/platform_manifest_twrp_aosp.git",
+					Branch:   "twrp-12.1",
+					Env:      map[string]string{"ALLOW_MISSING_DEPENDENCIES": "true"},
+				},
+				{
+					Name:     "orangefox",
+					Label:    "OrangeFox",
+					Manifest: "https://gitlab.com/OrangeFox/Manifest.git",
+					Branch:   "fox_12.1",
+					Env: map[string]string{
+						"ALLOW_MISSING_DEPENDENCIES": "true",
+						"LC_ALL":                     "C",
+						"FOX_BUILD_TYPE":             "Unofficial",
+					},
+				},
+			},
+		},
+		Repos: []Repository{
+			{
+				Name:     "lineageos",
+				Manifest: "https://github.com/LineageOS/android",
+				Branch:   "lineage-21.0",
+				ROM:      ROMTarget{Command: "m", Target: "bacon", Package: "lineage-*.zip", Images: defaultROMImages(), Payload: true},
+			},
+			{
+				Name:     "yaap",
+				Manifest: "https://github.com/yaap/manifest",
+				Branch:   "sixteen",
+				ROM:      ROMTarget{Command: "mka", Target: "yaap", Package: "YAAP-*.zip", Images: defaultROMImages(), Payload: true},
+			},
+			{
+				Name:     "evolution",
+				Manifest: "https://github.com/Evolution-X/manifest",
+				ROM:      ROMTarget{Command: "m", Target: "evolution", Package: "EvolutionX-*.zip", Images: defaultROMImages(), Payload: true},
+			},
+		},
 		Fleet: []FleetDevice{
 			{
 				Name:       "OnePlus 12",
@@ -213,6 +537,10 @@ func Default() *Config {
 	}
 }
 
+func defaultROMImages() []string {
+	return []string{"boot.img", "vendor_boot.img", "dtbo.img"}
+}
+
 func setDefaults(v *viper.Viper) {
 	def := Default()
 	v.SetDefault("version", def.Version)
@@ -223,7 +551,19 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("build.defaultType", def.Build.DefaultType)
 	v.SetDefault("theme.enabled", def.Theme.Enabled)
 	v.SetDefault("theme.accent", def.Theme.Accent)
+	v.SetDefault("gerrit.url", def.Gerrit.URL)
+	v.SetDefault("ccache.enabled", def.CCache.Enabled)
+	v.SetDefault("ccache.maxSize", def.CCache.MaxSize)
+	v.SetDefault("ccache.compression", def.CCache.Compression)
+	v.SetDefault("ccache.shared", def.CCache.Shared)
+	v.SetDefault("api.listen", def.API.Listen)
+	v.SetDefault("metrics.listen", def.Metrics.Listen)
+	v.SetDefault("release.dir", def.Release.Dir)
+	v.SetDefault("release.artifacts", def.Release.Artifacts)
+	v.SetDefault("recovery.output", def.Recovery.Output)
+	v.SetDefault("recovery.flavors", def.Recovery.Flavors)
 	v.SetDefault("fleet", def.Fleet)
+	v.SetDefault("repositories", def.Repos)
 }
 
 // DeviceByCodename returns the fleet device matching the codename.
@@ -235,3 +575,68 @@ func (c *Config) DeviceByCodename(code string) *FleetDevice {
 	}
 	return nil
 }
+
+// PatchSetsFor returns the patch sets that apply to the repository/device pair.
+func (c *Config) PatchSetsFor(repository, device string) []PatchSet {
+	var sets []PatchSet
+	for _, set := range c.Patches {
+		if set.Repository != "" && !strings.EqualFold(set.Repository, repository) {
+			continue
+		}
+		if set.Device != "" && !strings.EqualFold(set.Device, device) {
+			continue
+		}
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+// RepositoryByName returns the catalog entry for the repository.
+func (c *Config) RepositoryByName(name string) *Repository {
+	for i := range c.Repos {
+		if strings.EqualFold(c.Repos[i].Name, name) {
+			return &c.Repos[i]
+		}
+	}
+	return nil
+}
+
+// PipelineByName returns the configured pipeline.
+func (c *Config) PipelineByName(name string) *Pipeline {
+	for i := range c.Pipelines {
+		if strings.EqualFold(c.Pipelines[i].Name, name) {
+			return &c.Pipelines[i]
+		}
+	}
+	return nil
+}
+
+// ScheduleByName returns the configured schedule.
+func (c *Config) ScheduleByName(name string) *Schedule {
+	for i := range c.Schedules {
+		if strings.EqualFold(c.Schedules[i].Name, name) {
+			return &c.Schedules[i]
+		}
+	}
+	return nil
+}
+
+// NotifyTargetByName returns the configured notification target.
+func (c *Config) NotifyTargetByName(name string) *NotifyTarget {
+	for i := range c.Notify.Targets {
+		if strings.EqualFold(c.Notify.Targets[i].Name, name) {
+			return &c.Notify.Targets[i]
+		}
+	}
+	return nil
+}
+
+// RecoveryFlavorByName returns the configured recovery flavor.
+func (c *Config) RecoveryFlavorByName(name string) *RecoveryFlavor {
+	for i := range c.Recovery.Flavors {
+		if strings.EqualFold(c.Recovery.Flavors[i].Name, name) {
+			return &c.Recovery.Flavors[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+// Package dashboard serves the embedded web UI on top of the JSON API.
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the UI at / and passes /api/ to api. The static files
+// carry no data, so only the API requires the token; the page asks for it
+// and keeps it in the browser's local storage.
+func Handler(api http.Handler) http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/", api)
+	mux.Handle("/", http.FileServer(http.FS(files)))
+	return mux
+}
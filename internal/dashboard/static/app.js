@@ -0,0 +1,222 @@
+"use strict";
+
+// Polls the API and renders the dashboard. The token is kept in
+// localStorage; a #token=... fragment (printed by the dashboard command)
+// seeds it without sending it to the server in the URL.
+
+const REFRESH_MS = 3000;
+const TOKEN_KEY = "arkforge.token";
+
+let token = localStorage.getItem(TOKEN_KEY) || "";
+let timer = null;
+
+const hash = new URLSearchParams(location.hash.slice(1));
+if (hash.get("token")) {
+  token = hash.get("token");
+  localStorage.setItem(TOKEN_KEY, token);
+  history.replaceState(null, "", location.pathname);
+}
+
+const $ = (id) => document.getElementById(id);
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs || {})) {
+    if (key === "class") node.className = value;
+    else node.setAttribute(key, value);
+  }
+  for (const child of children) {
+    if (child === null || child === undefined) continue;
+    node.append(child instanceof Node ? child : String(child));
+  }
+  return node;
+}
+
+function badge(text) {
+  return el("span", { class: "badge " + text }, text);
+}
+
+function row(...cells) {
+  return el("tr", null, ...cells.map((cell) => (cell instanceof Node && cell.tagName === "TD" ? cell : el("td", null, cell))));
+}
+
+function emptyRow(tbody, columns, text) {
+  tbody.replaceChildren(el("tr", null, el("td", { class: "empty", colspan: columns }, text)));
+}
+
+function bytes(n) {
+  if (!n) return "-";
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return n.toFixed(i ? 1 : 0) + " " + units[i];
+}
+
+// Durations arrive as Go nanoseconds.
+function duration(ns) {
+  if (!ns) return "-";
+  const s = Math.round(ns / 1e9);
+  const h = Math.floor(s / 3600);
+  const m = Math.floor((s % 3600) / 60);
+  return h ? `${h}h${String(m).padStart(2, "0")}m` : `${m}m${String(s % 60).padStart(2, "0")}s`;
+}
+
+function when(value) {
+  if (!value || value.startsWith("0001-")) return "-";
+  return new Date(value).toLocaleString();
+}
+
+function progressBar(progress) {
+  const match = /(\d+)%/.exec(progress || "");
+  if (!match) return progress || "-";
+  return el("div", { title: progress }, el("div", { class: "bar" }, el("div", { style: `width:${match[1]}%` })), progress);
+}
+
+function withToken(path) {
+  return path + "?token=" + encodeURIComponent(token);
+}
+
+async function api(path) {
+  const res = await fetch(path, { headers: { Authorization: "Bearer " + token } });
+  if (res.status === 401) throw new Error("unauthorized");
+  if (!res.ok) throw new Error(`${path}: ${res.status}`);
+  return res.json();
+}
+
+function renderFleet(devices) {
+  const fleet = $("fleet");
+  if (!devices.length) {
+    fleet.replaceChildren(el("p", { class: "empty" }, "No fleet devices configured."));
+    return;
+  }
+  fleet.replaceChildren(
+    ...devices.map((d) =>
+      el(
+        "div",
+        { class: "card" + (d.building ? " building" : d.synced ? " synced" : "") },
+        el("h3", null, d.name, " ", d.role ? badge(d.role) : null),
+        el("p", null, `${d.codename} · ${d.repository || "default repo"}`),
+        el("p", null, d.tree || "no tree"),
+        el("p", null, d.building ? badge("running") : d.synced ? "synced" : "not synced")
+      )
+    )
+  );
+}
+
+function renderActive(activity) {
+  const tbody = $("active");
+  const rows = [];
+  for (const b of activity.builds) {
+    rows.push(row(b.device, (b.goals || []).join(" ") || b.mode, badge(b.running ? "running" : "interrupted"), progressBar(b.progress), when(b.startedAt)));
+  }
+  for (const j of activity.jobs) {
+    const p = j.params || {};
+    const devices = (p.devices || (p.device ? [p.device] : [])).join(", ");
+    rows.push(row(devices || "-", `${j.kind} ${(p.targets || []).join(",")}`.trim(), badge(j.status), j.id, when(j.status === "queued" ? j.createdAt : j.startedAt)));
+  }
+  for (const q of activity.queue) {
+    rows.push(row("-", "schedule " + q.schedule, badge("queued"), "due " + when(q.due), when(q.enqueuedAt)));
+  }
+  if (!rows.length) emptyRow(tbody, 5, "Nothing building or queued.");
+  else tbody.replaceChildren(...rows);
+}
+
+function renderHistory(records) {
+  const tbody = $("history");
+  if (!records.length) {
+    emptyRow(tbody, 7, "No builds recorded yet.");
+    return;
+  }
+  tbody.replaceChildren(
+    ...records.reverse().map((r) => {
+      const base = "/api/v1/history/" + encodeURIComponent(r.id);
+      const log = r.logPath ? el("a", { href: withToken(base + "/log"), target: "_blank" }, "log") : "-";
+      const artifacts = el("td");
+      (r.artifacts || []).forEach((a, i) => {
+        if (i) artifacts.append(" ");
+        artifacts.append(el("a", { href: withToken(`${base}/artifacts/${i}`), title: bytes(a.size) }, a.path.split("/").pop()));
+      });
+      if (!artifacts.childNodes.length) artifacts.append("-");
+      return row(when(r.finishedAt), r.device, (r.goals || []).join(" ") || r.mode, badge(r.result), duration(r.duration), log, artifacts);
+    })
+  );
+}
+
+function renderDisk(disk) {
+  $("disk-summary").textContent = disk.size
+    ? `${disk.workspace}: ${bytes(disk.free)} free of ${bytes(disk.size)}`
+    : disk.workspace;
+  const tbody = $("disk");
+  if (!disk.trees.length) {
+    emptyRow(tbody, 5, "No trees in the workspace.");
+    return;
+  }
+  const largest = Math.max(...disk.trees.map((t) => t.total), 1);
+  tbody.replaceChildren(
+    ...disk.trees.map((t) =>
+      row(
+        t.tree,
+        t.device,
+        el("td", { class: "num" }, t.scannedAt && !t.scannedAt.startsWith("0001-") ? bytes(t.total) : "scanning…"),
+        el("td", { class: "num" }, bytes(t.out)),
+        el("div", { class: "bar" }, el("div", { style: `width:${(100 * t.total) / largest}%` }))
+      )
+    )
+  );
+}
+
+async function refresh() {
+  try {
+    const [fleet, active, records, disk] = await Promise.all([
+      api("/api/v1/fleet"),
+      api("/api/v1/builds/active"),
+      api("/api/v1/history?limit=25"),
+      api("/api/v1/disk"),
+    ]);
+    renderFleet(fleet);
+    renderActive(active);
+    renderHistory(records);
+    renderDisk(disk);
+    $("updated").textContent = "updated " + new Date().toLocaleTimeString();
+  } catch (err) {
+    if (err.message === "unauthorized") {
+      showLogin("Token rejected.");
+      return;
+    }
+    $("updated").textContent = "refresh failed: " + err.message;
+  }
+  timer = setTimeout(refresh, REFRESH_MS);
+}
+
+function showLogin(message) {
+  clearTimeout(timer);
+  $("main").hidden = true;
+  $("login").hidden = false;
+  $("login-error").textContent = message || "";
+  $("token").focus();
+}
+
+function start() {
+  $("login").hidden = true;
+  $("main").hidden = false;
+  refresh();
+}
+
+$("login").addEventListener("submit", (event) => {
+  event.preventDefault();
+  token = $("token").value.trim();
+  localStorage.setItem(TOKEN_KEY, token);
+  start();
+});
+
+$("logout").addEventListener("click", () => {
+  localStorage.removeItem(TOKEN_KEY);
+  token = "";
+  showLogin();
+});
+
+if (token) start();
+else showLogin();
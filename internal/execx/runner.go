@@ -18,14 +18,22 @@ var ansiRegexp = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
 
 // Command represents an external command invocation.
 type Command struct {
-	Name    string
-	Args    []string
-	Dir     string
-	Env     map[string]string
+	Name string
+	Args []string
+	Dir  string
+	Env  map[string]string
+	// Timeout bounds the command; zero means the runner's default and
+	// NoTimeout lets it run until ctx is done.
 	Timeout time.Duration
 	DryRun  bool
+	// LogPath, when set, receives a copy of stdout and stderr (appended).
+	LogPath string
 }
 
+// NoTimeout disables the runner's default timeout for commands that take
+// as long as they take, such as full builds.
+const NoTimeout time.Duration = -1
+
 // Runner executes commands with logging, timeouts, and retries.
 type Runner struct {
 	logger         zerolog.Logger
@@ -40,6 +48,25 @@ func NewRunner(logger zerolog.Logger) *Runner {
 	}
 }
 
+// Logger returns the logger commands are logged to.
+func (r *Runner) Logger() zerolog.Logger {
+	return r.logger
+}
+
+// deadline applies the command's timeout to ctx.
+func (r *Runner) deadline(ctx context.Context, cmd Command) (context.Context, context.CancelFunc, time.Duration) {
+	timeout := cmd.Timeout
+	if timeout < 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, 0
+	}
+	if timeout == 0 {
+		timeout = r.defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, timeout
+}
+
 // Run executes the provided command until completion.
 func (r *Runner) Run(ctx context.Context, cmd Command) error {
 	if cmd.DryRun {
@@ -50,21 +77,10 @@ func (r *Runner) Run(ctx context.Context, cmd Command) error {
 		return nil
 	}
 
-	timeout := cmd.Timeout
-	if timeout <= 0 {
-		timeout = r.defaultTimeout
-	}
-
-	var cancel context.CancelFunc
-	ctx, cancel = context.WithTimeout(ctx, timeout)
+	ctx, cancel, timeout := r.deadline(ctx, cmd)
 	defer cancel()
 
-	execCmd := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
-	execCmd.Dir = cmd.Dir
-	execCmd.Env = os.Environ()
-	for k, v := range cmd.Env {
-		execCmd.Env = append(execCmd.Env, fmt.Sprintf("%s=%s", k, v))
-	}
+	execCmd := newExecCmd(ctx, cmd)
 
 	var stdoutBuf bytes.Buffer
 	var stderrBuf bytes.Buffer
@@ -72,6 +88,15 @@ func (r *Runner) Run(ctx context.Context, cmd Command) error {
 	stderrLog := newLogWriter(r.logger, zerolog.ErrorLevel)
 	stdout := io.MultiWriter(&stdoutBuf, stdoutLog)
 	stderr := io.MultiWriter(&stderrBuf, stderrLog)
+	if cmd.LogPath != "" {
+		logFile, err := os.OpenFile(cmd.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open command log: %w", err)
+		}
+		defer logFile.Close()
+		stdout = io.MultiWriter(stdout, logFile)
+		stderr = io.MultiWriter(stderr, logFile)
+	}
 
 	execCmd.Stdout = stdout
 	execCmd.Stderr = stderr
@@ -106,6 +131,67 @@ func (r *Runner) Run(ctx context.Context, cmd Command) error {
 	return err
 }
 
+// Output executes the command and returns its trimmed stdout. Unlike Run it
+// ignores DryRun because it is meant for read-only queries (git state, tool
+// versions) whose answers drive later decisions.
+func (r *Runner) Output(ctx context.Context, cmd Command) (string, error) {
+	ctx, cancel, timeout := r.deadline(ctx, cmd)
+	defer cancel()
+
+	execCmd := newExecCmd(ctx, cmd)
+	var stdoutBuf bytes.Buffer
+	var stderrBuf bytes.Buffer
+	execCmd.Stdout = &stdoutBuf
+	execCmd.Stderr = &stderrBuf
+
+	if err := execCmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("command timeout after %s", timeout)
+		}
+		detail := strings.TrimSpace(stripANSI(stderrBuf.String()))
+		if detail == "" {
+			return strings.TrimSpace(stdoutBuf.String()), fmt.Errorf("%s: %w", cmd.Name, err)
+		}
+		return strings.TrimSpace(stdoutBuf.String()), fmt.Errorf("%s: %w: %s", cmd.Name, err, detail)
+	}
+	return strings.TrimSpace(stdoutBuf.String()), nil
+}
+
+// Pipe runs a filter such as xz with stdin and stdout attached. It logs at
+// debug level only, since callers may run it once per chunk of data.
+func (r *Runner) Pipe(ctx context.Context, cmd Command, stdin io.Reader, stdout io.Writer) error {
+	ctx, cancel, timeout := r.deadline(ctx, cmd)
+	defer cancel()
+
+	execCmd := newExecCmd(ctx, cmd)
+	var stderrBuf bytes.Buffer
+	execCmd.Stdin = stdin
+	execCmd.Stdout = stdout
+	execCmd.Stderr = &stderrBuf
+
+	r.logger.Debug().Str("cmd", execCmd.String()).Msg("execx: piping through command")
+	if err := execCmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("command timeout after %s", timeout)
+		}
+		if detail := strings.TrimSpace(stripANSI(stderrBuf.String())); detail != "" {
+			return fmt.Errorf("%s: %w: %s", cmd.Name, err, detail)
+		}
+		return fmt.Errorf("%s: %w", cmd.Name, err)
+	}
+	return nil
+}
+
+func newExecCmd(ctx context.Context, cmd Command) *exec.Cmd {
+	execCmd := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	execCmd.Dir = cmd.Dir
+	execCmd.Env = os.Environ()
+	for k, v := range cmd.Env {
+		execCmd.Env = append(execCmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+	return execCmd
+}
+
 type logWriter struct {
 	logger zerolog.Logger
 	level  zerolog.Level
@@ -0,0 +1,175 @@
+package gerrit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultURL is the LineageOS review instance.
+const DefaultURL = "https://review.lineageos.org"
+
+// Gerrit prefixes JSON bodies with this line to defeat XSSI.
+var xssiPrefix = []byte(")]}'")
+
+// Client talks to the Gerrit REST API.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewClient returns a client for the Gerrit instance at baseURL.
+func NewClient(baseURL string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultURL
+	}
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Change is the subset of Gerrit's ChangeInfo needed to pick a change.
+type Change struct {
+	Number          int                 `json:"_number"`
+	Project         string              `json:"project"`
+	Branch          string              `json:"branch"`
+	Topic           string              `json:"topic"`
+	Subject         string              `json:"subject"`
+	Status          string              `json:"status"`
+	CurrentRevision string              `json:"current_revision"`
+	Revisions       map[string]Revision `json:"revisions"`
+}
+
+// Revision describes one patch set of a change.
+type Revision struct {
+	Number int                  `json:"_number"`
+	Ref    string               `json:"ref"`
+	Fetch  map[string]FetchInfo `json:"fetch"`
+	Commit struct {
+		Parents []struct {
+			Commit string `json:"commit"`
+		} `json:"parents"`
+	} `json:"commit"`
+}
+
+// FetchInfo is a download scheme advertised by Gerrit.
+type FetchInfo struct {
+	URL string `json:"url"`
+	Ref string `json:"ref"`
+}
+
+// Change fetches a change by number including its current revision.
+func (c *Client) Change(ctx context.Context, number int) (Change, error) {
+	var change Change
+	path := fmt.Sprintf("/changes/%d?o=CURRENT_REVISION&o=CURRENT_COMMIT", number)
+	if err := c.get(ctx, path, &change); err != nil {
+		return Change{}, fmt.Errorf("change %d: %w", number, err)
+	}
+	return change, nil
+}
+
+// Topic returns every open change in the topic.
+func (c *Client) Topic(ctx context.Context, topic string) ([]Change, error) {
+	query := url.QueryEscape(fmt.Sprintf("topic:\"%s\" status:open", topic))
+	var changes []Change
+	path := fmt.Sprintf("/changes/?q=%s&o=CURRENT_REVISION&o=CURRENT_COMMIT", query)
+	if err := c.get(ctx, path, &changes); err != nil {
+		return nil, fmt.Errorf("topic %s: %w", topic, err)
+	}
+	return changes, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gerrit returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	body = bytes.TrimPrefix(body, xssiPrefix)
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// Current returns the current revision of the change.
+func (ch Change) Current() (Revision, bool) {
+	rev, ok := ch.Revisions[ch.CurrentRevision]
+	return rev, ok
+}
+
+// FetchSource returns the URL and ref used to download the current revision,
+// preferring anonymous HTTP and falling back to <base>/<project>.
+func (c *Client) FetchSource(ch Change) (string, string, error) {
+	rev, ok := ch.Current()
+	if !ok {
+		return "", "", fmt.Errorf("change %d has no current revision", ch.Number)
+	}
+	for _, scheme := range []string{"anonymous http", "http"} {
+		if fetch, ok := rev.Fetch[scheme]; ok && fetch.URL != "" {
+			return fetch.URL, fetch.Ref, nil
+		}
+	}
+	if rev.Ref == "" {
+		return "", "", fmt.Errorf("change %d has no fetch ref", ch.Number)
+	}
+	return c.BaseURL + "/" + ch.Project, rev.Ref, nil
+}
+
+// Order sorts changes so that a change whose parent is another selected
+// change comes after it. Unrelated changes keep ascending number order.
+func Order(changes []Change) []Change {
+	sorted := append([]Change(nil), changes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Number < sorted[j].Number })
+
+	byRevision := map[string]int{}
+	for i, ch := range sorted {
+		byRevision[ch.CurrentRevision] = i
+	}
+
+	ordered := make([]Change, 0, len(sorted))
+	state := make([]int, len(sorted))
+	var visit func(int)
+	visit = func(i int) {
+		if state[i] != 0 {
+			return
+		}
+		state[i] = 1
+		if rev, ok := sorted[i].Current(); ok {
+			for _, parent := range rev.Commit.Parents {
+				if p, ok := byRevision[parent.Commit]; ok {
+					visit(p)
+				}
+			}
+		}
+		state[i] = 2
+		ordered = append(ordered, sorted[i])
+	}
+	for i := range sorted {
+		visit(i)
+	}
+	return ordered
+}
@@ -0,0 +1,341 @@
+package gerrit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/patches"
+)
+
+// fakeGerrit serves changes by number and by topic, with Gerrit's XSSI
+// prefix, and records the queries it saw.
+type fakeGerrit struct {
+	changes map[int]Change
+	topics  map[string][]int
+	queries []string
+}
+
+func (f *fakeGerrit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body any
+	switch {
+	case r.URL.Path == "/changes/":
+		query := r.URL.Query().Get("q")
+		f.queries = append(f.queries, query)
+		var changes []Change
+		for topic, numbers := range f.topics {
+			if query == fmt.Sprintf("topic:%q status:open", topic) {
+				for _, number := range numbers {
+					changes = append(changes, f.changes[number])
+				}
+			}
+		}
+		body = changes
+	case strings.HasPrefix(r.URL.Path, "/changes/"):
+		var number int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/changes/"), "%d", &number)
+		change, ok := f.changes[number]
+		if !ok {
+			http.Error(w, "Not found: "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		body = change
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, ")]}'")
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestTopicQuery(t *testing.T) {
+	fake := &fakeGerrit{
+		changes: map[int]Change{
+			11: {Number: 11, Project: "LineageOS/android_a", Topic: "t"},
+			12: {Number: 12, Project: "LineageOS/android_b", Topic: "t"},
+		},
+		topics: map[string][]int{"t": {12, 11}},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	changes, err := NewClient(server.URL).Topic(context.Background(), "t")
+	if err != nil {
+		t.Fatalf("Topic: %v", err)
+	}
+	if len(changes) != 2 || changes[0].Number != 12 || changes[1].Number != 11 {
+		t.Fatalf("Topic returned %+v", changes)
+	}
+	if want := `topic:"t" status:open`; len(fake.queries) != 1 || fake.queries[0] != want {
+		t.Fatalf("queries = %q, want %q", fake.queries, want)
+	}
+}
+
+func TestChangeNotFound(t *testing.T) {
+	server := httptest.NewServer(&fakeGerrit{})
+	defer server.Close()
+
+	_, err := NewClient(server.URL).Change(context.Background(), 99)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("Change(99) error = %v, want a 404", err)
+	}
+}
+
+func TestOrderFollowsParents(t *testing.T) {
+	changes := []Change{
+		change(3, "c3", "c2"),
+		change(1, "c1", "base"),
+		change(2, "c2", "c1"),
+		change(4, "c4", "base"),
+	}
+	var got []int
+	for _, ch := range Order(changes) {
+		got = append(got, ch.Number)
+	}
+	if fmt.Sprint(got) != "[1 2 3 4]" {
+		t.Fatalf("Order = %v", got)
+	}
+}
+
+func TestPick(t *testing.T) {
+	env := newPickEnv(t)
+	first := env.upstreamChange(t, "refs/changes/01/101/1", "a.txt", "one\n", "")
+	second := env.upstreamChange(t, "refs/changes/02/102/3", "b.txt", "two\n", first)
+	env.gerrit.changes[101] = env.change(101, 1, "refs/changes/01/101/1", first, "base")
+	env.gerrit.changes[102] = env.change(102, 3, "refs/changes/02/102/3", second, first)
+	env.gerrit.topics["feature"] = []int{102, 101}
+
+	picked, err := Pick(context.Background(), env.runner, env.cfg, NewClient(env.server.URL), PickOptions{
+		SourceDir: env.tree,
+		Topics:    []string{"feature"},
+	})
+	if err != nil {
+		t.Fatalf("Pick: %v", err)
+	}
+	if len(picked) != 2 || picked[0].Number != 101 || picked[1].Number != 102 || picked[1].Patchset != 3 {
+		t.Fatalf("picked = %+v", picked)
+	}
+	for _, file := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(env.project, file)); err != nil {
+			t.Fatalf("%s not picked: %v", file, err)
+		}
+	}
+	if log := gitOut(t, env.project, "log", "--format=%B", "-n1"); !strings.Contains(log, "(cherry picked from commit "+second+")") {
+		t.Fatalf("missing cherry-pick trailer in %q", log)
+	}
+	recorded, err := LoadPicks(env.tree)
+	if err != nil || len(recorded) != 2 {
+		t.Fatalf("LoadPicks = %+v, %v", recorded, err)
+	}
+
+	// Picking again is a no-op: the trailer marks the changes as applied.
+	head := gitOut(t, env.project, "rev-parse", "HEAD")
+	if _, err := Pick(context.Background(), env.runner, env.cfg, NewClient(env.server.URL), PickOptions{SourceDir: env.tree, Changes: []int{101, 102}}); err != nil {
+		t.Fatalf("second Pick: %v", err)
+	}
+	if again := gitOut(t, env.project, "rev-parse", "HEAD"); again != head {
+		t.Fatalf("second Pick moved HEAD from %s to %s", head, again)
+	}
+}
+
+func TestPickConflict(t *testing.T) {
+	env := newPickEnv(t)
+	clean := env.upstreamChange(t, "refs/changes/01/201/1", "a.txt", "one\n", "")
+	conflicting := env.upstreamChange(t, "refs/changes/02/202/1", "base.txt", "upstream\n", "")
+	env.gerrit.changes[201] = env.change(201, 1, "refs/changes/01/201/1", clean, "base")
+	env.gerrit.changes[202] = env.change(202, 1, "refs/changes/02/202/1", conflicting, "base")
+
+	// The local tree carries its own edit of the same line.
+	writeFile(t, filepath.Join(env.project, "base.txt"), "local\n")
+	gitRun(t, env.project, "commit", "-qam", "local edit")
+
+	picked, err := Pick(context.Background(), env.runner, env.cfg, NewClient(env.server.URL), PickOptions{
+		SourceDir: env.tree,
+		Changes:   []int{201, 202},
+	})
+	if !errors.Is(err, patches.ErrConflict) {
+		t.Fatalf("Pick error = %v, want ErrConflict", err)
+	}
+	if len(picked) != 1 || picked[0].Number != 201 {
+		t.Fatalf("picked = %+v, want only 201", picked)
+	}
+	if _, err := os.Stat(filepath.Join(env.project, ".git", "CHERRY_PICK_HEAD")); !os.IsNotExist(err) {
+		t.Fatalf("cherry-pick was not aborted")
+	}
+	if status := gitOut(t, env.project, "status", "--porcelain"); status != "" {
+		t.Fatalf("tree left dirty: %q", status)
+	}
+	recorded, err := LoadPicks(env.tree)
+	if err != nil || len(recorded) != 1 || recorded[0].Number != 201 {
+		t.Fatalf("recorded = %+v, %v", recorded, err)
+	}
+}
+
+func TestPicksDroppedAfterSync(t *testing.T) {
+	env := newPickEnv(t)
+	first := env.upstreamChange(t, "refs/changes/01/401/1", "a.txt", "one\n", "")
+	second := env.upstreamChange(t, "refs/changes/02/402/1", "b.txt", "two\n", "")
+	env.gerrit.changes[401] = env.change(401, 1, "refs/changes/01/401/1", first, "base")
+	env.gerrit.changes[402] = env.change(402, 1, "refs/changes/02/402/1", second, "base")
+	client := NewClient(env.server.URL)
+	ctx := context.Background()
+
+	if _, err := Pick(ctx, env.runner, env.cfg, client, PickOptions{SourceDir: env.tree, Changes: []int{401}}); err != nil {
+		t.Fatalf("Pick: %v", err)
+	}
+	// A repo sync resets the project to upstream, dropping the pick.
+	gitRun(t, env.project, "reset", "-q", "--hard", "origin/main")
+
+	current, err := CurrentPicks(ctx, env.runner, env.tree)
+	if err != nil || len(current) != 0 {
+		t.Fatalf("CurrentPicks after sync = %+v, %v", current, err)
+	}
+	if _, err := Pick(ctx, env.runner, env.cfg, client, PickOptions{SourceDir: env.tree, Changes: []int{402}}); err != nil {
+		t.Fatalf("Pick: %v", err)
+	}
+	recorded, err := LoadPicks(env.tree)
+	if err != nil || len(recorded) != 1 || recorded[0].Number != 402 {
+		t.Fatalf("recorded = %+v, %v, want only 402", recorded, err)
+	}
+}
+
+func TestPickUnknownProject(t *testing.T) {
+	env := newPickEnv(t)
+	commit := env.upstreamChange(t, "refs/changes/01/301/1", "a.txt", "one\n", "")
+	ch := env.change(301, 1, "refs/changes/01/301/1", commit, "base")
+	ch.Project = "LineageOS/android_missing"
+	env.gerrit.changes[301] = ch
+
+	_, err := Pick(context.Background(), env.runner, env.cfg, NewClient(env.server.URL), PickOptions{SourceDir: env.tree, Changes: []int{301}})
+	if err == nil || !strings.Contains(err.Error(), "not in manifest") {
+		t.Fatalf("Pick error = %v, want project not in manifest", err)
+	}
+}
+
+// pickEnv is a source tree with one project checked out from a local
+// upstream repository, and a Gerrit stand-in serving changes of it.
+type pickEnv struct {
+	upstream string
+	tree     string
+	project  string
+	gerrit   *fakeGerrit
+	server   *httptest.Server
+	runner   *execx.Runner
+	cfg      *config.Config
+}
+
+const projectName = "LineageOS/android_foo"
+
+func newPickEnv(t *testing.T) *pickEnv {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	for _, name := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(name, "Test")
+	}
+	for _, name := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(name, "test@example.org")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	root := t.TempDir()
+	env := &pickEnv{
+		upstream: filepath.Join(root, "upstream"),
+		tree:     filepath.Join(root, "tree"),
+		gerrit:   &fakeGerrit{changes: map[int]Change{}, topics: map[string][]int{}},
+		runner:   execx.NewRunner(zerolog.Nop()),
+		cfg:      config.Default(),
+	}
+	env.project = filepath.Join(env.tree, "foo")
+
+	gitRun(t, root, "init", "-q", "-b", "main", env.upstream)
+	writeFile(t, filepath.Join(env.upstream, "base.txt"), "base\n")
+	gitRun(t, env.upstream, "add", ".")
+	gitRun(t, env.upstream, "commit", "-qm", "base")
+	gitRun(t, root, "clone", "-q", env.upstream, env.project)
+
+	writeFile(t, filepath.Join(env.tree, ".repo", "manifest.xml"),
+		`<manifest><project name="`+projectName+`" path="foo" /></manifest>`)
+
+	env.server = httptest.NewServer(env.gerrit)
+	t.Cleanup(env.server.Close)
+	return env
+}
+
+// upstreamChange commits a file on top of parent (main when empty) in the
+// upstream repository and publishes it under ref, like a Gerrit patch set.
+func (e *pickEnv) upstreamChange(t *testing.T, ref, file, content, parent string) string {
+	t.Helper()
+	if parent == "" {
+		parent = "main"
+	}
+	gitRun(t, e.upstream, "checkout", "-q", "--detach", parent)
+	writeFile(t, filepath.Join(e.upstream, file), content)
+	gitRun(t, e.upstream, "add", ".")
+	gitRun(t, e.upstream, "commit", "-qm", "change "+ref)
+	commit := gitOut(t, e.upstream, "rev-parse", "HEAD")
+	gitRun(t, e.upstream, "update-ref", ref, commit)
+	gitRun(t, e.upstream, "checkout", "-q", "main")
+	return commit
+}
+
+func (e *pickEnv) change(number, patchset int, ref, commit, parent string) Change {
+	ch := change(number, commit, parent)
+	ch.Project = projectName
+	ch.Subject = fmt.Sprintf("change %d", number)
+	rev := ch.Revisions[commit]
+	rev.Number = patchset
+	rev.Ref = ref
+	rev.Fetch = map[string]FetchInfo{"anonymous http": {URL: e.upstream, Ref: ref}}
+	ch.Revisions[commit] = rev
+	return ch
+}
+
+func change(number int, revision, parent string) Change {
+	rev := Revision{Number: 1}
+	rev.Commit.Parents = []struct {
+		Commit string `json:"commit"`
+	}{{Commit: parent}}
+	return Change{Number: number, CurrentRevision: revision, Revisions: map[string]Revision{revision: rev}}
+}
+
+func gitRun(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	gitOut(t, dir, args...)
+}
+
+func gitOut(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
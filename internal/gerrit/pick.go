@@ -0,0 +1,205 @@
+package gerrit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/manifest"
+	"github.com/koobie777/ark-android-forge/internal/patches"
+)
+
+// PickedChange records a Gerrit change cherry-picked into a source tree.
+type PickedChange struct {
+	Number   int       `yaml:"number"`
+	Patchset int       `yaml:"patchset"`
+	Project  string    `yaml:"project"`
+	Path     string    `yaml:"path"`
+	Subject  string    `yaml:"subject"`
+	Topic    string    `yaml:"topic,omitempty"`
+	Commit   string    `yaml:"commit"`
+	PickedAt time.Time `yaml:"pickedAt"`
+}
+
+// PickOptions selects the changes to pick and the tree to pick them into.
+type PickOptions struct {
+	SourceDir string
+	Changes   []int
+	Topics    []string
+	DryRun    bool
+}
+
+// Pick resolves the requested changes and topics, maps them to local
+// projects through the tree's repo manifest and cherry-picks them in
+// dependency order. Successful picks are recorded in the tree.
+func Pick(ctx context.Context, runner *execx.Runner, cfg *config.Config, client *Client, opts PickOptions) ([]PickedChange, error) {
+	if client == nil {
+		return nil, fmt.Errorf("gerrit client is nil")
+	}
+
+	changes, err := resolve(ctx, client, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("no changes selected")
+	}
+
+	m, err := manifest.Load(opts.SourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var picked []PickedChange
+	for _, ch := range Order(changes) {
+		project := m.ProjectByName(ch.Project)
+		if project == nil {
+			return picked, record(ctx, runner, opts, picked, fmt.Errorf("change %d: project %s not in manifest", ch.Number, ch.Project))
+		}
+		remote, ref, err := client.FetchSource(ch)
+		if err != nil {
+			return picked, record(ctx, runner, opts, picked, err)
+		}
+
+		pick := config.PatchPick{Remote: remote, Ref: ref}
+		if _, err := patches.Pick(ctx, runner, cfg, filepath.Join(opts.SourceDir, project.Path), pick, opts.DryRun); err != nil {
+			return picked, record(ctx, runner, opts, picked, fmt.Errorf("change %d: %w", ch.Number, err))
+		}
+
+		rev, _ := ch.Current()
+		picked = append(picked, PickedChange{
+			Number:   ch.Number,
+			Patchset: rev.Number,
+			Project:  ch.Project,
+			Path:     project.Path,
+			Subject:  ch.Subject,
+			Topic:    ch.Topic,
+			Commit:   ch.CurrentRevision,
+			PickedAt: time.Now().UTC(),
+		})
+	}
+	return picked, record(ctx, runner, opts, picked, nil)
+}
+
+func resolve(ctx context.Context, client *Client, opts PickOptions) ([]Change, error) {
+	seen := map[int]bool{}
+	var changes []Change
+	add := func(ch Change) {
+		if !seen[ch.Number] {
+			seen[ch.Number] = true
+			changes = append(changes, ch)
+		}
+	}
+
+	for _, number := range opts.Changes {
+		ch, err := client.Change(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+		add(ch)
+	}
+	for _, topic := range opts.Topics {
+		topicChanges, err := client.Topic(ctx, topic)
+		if err != nil {
+			return nil, err
+		}
+		for _, ch := range topicChanges {
+			add(ch)
+		}
+	}
+	return changes, nil
+}
+
+// record persists what was picked so far and returns cause unchanged, so a
+// partial run still leaves an accurate record behind.
+func record(ctx context.Context, runner *execx.Runner, opts PickOptions, picked []PickedChange, cause error) error {
+	if opts.DryRun || len(picked) == 0 {
+		return cause
+	}
+	if err := RecordPicks(ctx, runner, opts.SourceDir, picked); err != nil {
+		if cause != nil {
+			return fmt.Errorf("%w (recording picks also failed: %v)", cause, err)
+		}
+		return err
+	}
+	return cause
+}
+
+// RecordPath is where picked changes are tracked inside a source tree.
+func RecordPath(sourceDir string) string {
+	return filepath.Join(sourceDir, ".arkforge", "picks.yaml")
+}
+
+// LoadPicks returns the changes recorded for the tree, if any.
+func LoadPicks(sourceDir string) ([]PickedChange, error) {
+	data, err := os.ReadFile(RecordPath(sourceDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read picks: %w", err)
+	}
+	var picks []PickedChange
+	if err := yaml.Unmarshal(data, &picks); err != nil {
+		return nil, fmt.Errorf("decode picks: %w", err)
+	}
+	return picks, nil
+}
+
+// CurrentPicks returns the recorded changes that are still in the tree.
+// A repo sync resets projects and drops their picks, but not the record.
+func CurrentPicks(ctx context.Context, runner *execx.Runner, sourceDir string) ([]PickedChange, error) {
+	picks, err := LoadPicks(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	current := picks[:0]
+	for _, pick := range picks {
+		if patches.Present(ctx, runner, filepath.Join(sourceDir, pick.Path), pick.Commit) {
+			current = append(current, pick)
+		}
+	}
+	return current, nil
+}
+
+// RecordPicks merges picks into the tree's record, replacing older entries
+// for the same change number and dropping those no longer in the tree.
+func RecordPicks(ctx context.Context, runner *execx.Runner, sourceDir string, picks []PickedChange) error {
+	existing, err := CurrentPicks(ctx, runner, sourceDir)
+	if err != nil {
+		return err
+	}
+
+	byNumber := map[int]PickedChange{}
+	for _, pick := range existing {
+		byNumber[pick.Number] = pick
+	}
+	for _, pick := range picks {
+		byNumber[pick.Number] = pick
+	}
+	merged := make([]PickedChange, 0, len(byNumber))
+	for _, pick := range byNumber {
+		merged = append(merged, pick)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Number < merged[j].Number })
+
+	path := RecordPath(sourceDir)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create record dir: %w", err)
+	}
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("marshal picks: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write picks: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/lock"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Record is one finished build.
+type Record struct {
+	ID         string        `json:"id"`
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt time.Time     `json:"finishedAt"`
+	Duration   time.Duration `json:"duration"`
+	Device     string        `json:"device"`
+	Repository string        `json:"repository"`
+	Mode       string        `json:"mode"`
+	Combo      string        `json:"combo"`
+	Goals      []string      `json:"goals"`
+	Variant    string        `json:"variant"`
+	Result     string        `json:"result"`
+	Error      string        `json:"error,omitempty"`
+	Excerpt    []string      `json:"excerpt,omitempty"`
+	Git        GitSnapshot   `json:"git"`
+	Artifacts  []Artifact    `json:"artifacts,omitempty"`
+	LogPath    string        `json:"logPath,omitempty"`
+	Host       string        `json:"host,omitempty"`
+}
+
+// Target is the goal list used to group builds, e.g. "bacon".
+func (r Record) Target() string {
+	return strings.Join(r.Goals, " ")
+}
+
+// GitSnapshot pins the sources a build used.
+type GitSnapshot struct {
+	ManifestRev string `json:"manifestRev,omitempty"`
+	Pinned      string `json:"pinned,omitempty"`
+}
+
+// Artifact is a file produced by a build.
+type Artifact struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Path returns the history file, defaulting to <workspace>/.arkforge.
+func Path(cfg *config.Config) string {
+	if cfg.History.Path != "" {
+		return cfg.History.Path
+	}
+	return filepath.Join(cfg.Build.Workspace, ".arkforge", "history.jsonl")
+}
+
+// Append adds a record. Writers serialise on a lock next to the file so
+// parallel builds cannot interleave lines.
+func Append(path string, record Record) error {
+	return appendLine(path, record)
+}
+
+// Load returns every record, oldest first. Corrupt lines are skipped so a
+// torn write never hides the rest of the history.
+func Load(path string) ([]Record, error) {
+	records, err := loadLines[Record](path)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].StartedAt.Before(records[j].StartedAt) })
+	return records, nil
+}
+
+func appendLine(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create history dir: %w", err)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal history record: %w", err)
+	}
+
+	var fileLock *lock.Lock
+	for attempt := 0; attempt < 50; attempt++ {
+		if fileLock, err = lock.TryAcquire(path + ".lock"); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		return fmt.Errorf("lock history: %w", err)
+	}
+	defer fileLock.Release()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open history: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	return nil
+}
+
+func loadLines[T any](path string) ([]T, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	defer file.Close()
+
+	var records []T
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var record T
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	return records, nil
+}
+
+// Filter narrows a query. Zero fields match everything.
+type Filter struct {
+	Device string
+	Mode   string
+	Since  time.Time
+}
+
+// Match reports whether the record passes the filter.
+func (f Filter) Match(record Record) bool {
+	if f.Device != "" && !strings.EqualFold(record.Device, f.Device) {
+		return false
+	}
+	if f.Mode != "" && !strings.EqualFold(record.Mode, f.Mode) {
+		return false
+	}
+	if !f.Since.IsZero() && record.StartedAt.Before(f.Since) {
+		return false
+	}
+	return true
+}
+
+// Query returns the records matching the filter, oldest first.
+func Query(records []Record, filter Filter) []Record {
+	var matched []Record
+	for _, record := range records {
+		if filter.Match(record) {
+			matched = append(matched, record)
+		}
+	}
+	return matched
+}
+
+// Summary aggregates builds of one device and target.
+type Summary struct {
+	Device      string        `json:"device"`
+	Target      string        `json:"target"`
+	Builds      int           `json:"builds"`
+	Successes   int           `json:"successes"`
+	AvgDuration time.Duration `json:"avgDuration"`
+	LastBuild   time.Time     `json:"lastBuild"`
+}
+
+// SuccessRate returns the share of successful builds as a percentage.
+func (s Summary) SuccessRate() float64 {
+	if s.Builds == 0 {
+		return 0
+	}
+	return float64(s.Successes) * 100 / float64(s.Builds)
+}
+
+// Summarize groups records per device and target. Average duration only
+// counts successful builds, since failures stop early.
+func Summarize(records []Record) []Summary {
+	type key struct{ device, target string }
+	index := map[key]*Summary{}
+	totals := map[key]time.Duration{}
+	var order []key
+
+	for _, record := range records {
+		k := key{record.Device, record.Target()}
+		summary, ok := index[k]
+		if !ok {
+			summary = &Summary{Device: record.Device, Target: record.Target()}
+			index[k] = summary
+			order = append(order, k)
+		}
+		summary.Builds++
+		if record.Result == ResultSuccess {
+			summary.Successes++
+			totals[k] += record.Duration
+		}
+		if record.StartedAt.After(summary.LastBuild) {
+			summary.LastBuild = record.StartedAt
+		}
+	}
+
+	summaries := make([]Summary, 0, len(order))
+	for _, k := range order {
+		summary := index[k]
+		if summary.Successes > 0 {
+			summary.AvgDuration = totals[k] / time.Duration(summary.Successes)
+		}
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Device != summaries[j].Device {
+			return summaries[i].Device < summaries[j].Device
+		}
+		return summaries[i].Target < summaries[j].Target
+	})
+	return summaries
+}
+
+// TrendPoint aggregates the builds of one period.
+type TrendPoint struct {
+	Period      time.Time     `json:"period"`
+	Builds      int           `json:"builds"`
+	Successes   int           `json:"successes"`
+	AvgDuration time.Duration `json:"avgDuration"`
+}
+
+// Trend buckets records by day or ISO week (Monday start), oldest first.
+func Trend(records []Record, bucket string) ([]TrendPoint, error) {
+	var truncate func(time.Time) time.Time
+	switch bucket {
+	case "", "day":
+		truncate = func(t time.Time) time.Time {
+			y, m, d := t.Date()
+			return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		}
+	case "week":
+		truncate = func(t time.Time) time.Time {
+			y, m, d := t.Date()
+			day := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+			offset := (int(day.Weekday()) + 6) % 7
+			return day.AddDate(0, 0, -offset)
+		}
+	default:
+		return nil, fmt.Errorf("unknown trend bucket %q (day, week)", bucket)
+	}
+
+	index := map[time.Time]*TrendPoint{}
+	totals := map[time.Time]time.Duration{}
+	for _, record := range records {
+		period := truncate(record.StartedAt.Local())
+		point, ok := index[period]
+		if !ok {
+			point = &TrendPoint{Period: period}
+			index[period] = point
+		}
+		point.Builds++
+		if record.Result == ResultSuccess {
+			point.Successes++
+			totals[period] += record.Duration
+		}
+	}
+
+	points := make([]TrendPoint, 0, len(index))
+	for period, point := range index {
+		if point.Successes > 0 {
+			point.AvgDuration = totals[period] / time.Duration(point.Successes)
+		}
+		points = append(points, *point)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Period.Before(points[j].Period) })
+	return points, nil
+}
@@ -0,0 +1,39 @@
+package history
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+)
+
+// SyncRecord is a finished repo sync.
+type SyncRecord struct {
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt time.Time     `json:"finishedAt"`
+	Duration   time.Duration `json:"duration"`
+	Dir        string        `json:"dir"`
+	Result     string        `json:"result"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// SyncPath returns the sync history file, kept next to the build history.
+func SyncPath(cfg *config.Config) string {
+	return filepath.Join(filepath.Dir(Path(cfg)), "syncs.jsonl")
+}
+
+// AppendSync adds a sync record.
+func AppendSync(path string, record SyncRecord) error {
+	return appendLine(path, record)
+}
+
+// LoadSyncs returns every sync record, oldest first.
+func LoadSyncs(path string) ([]SyncRecord, error) {
+	records, err := loadLines[SyncRecord](path)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].StartedAt.Before(records[j].StartedAt) })
+	return records, nil
+}
@@ -0,0 +1,73 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned when another process holds the lock.
+var ErrLocked = errors.New("lock held by another process")
+
+// Lock is an exclusive advisory lock backed by a file holding the owner PID.
+type Lock struct {
+	path string
+	file *os.File
+}
+
+// TryAcquire takes the lock at path without blocking.
+func TryAcquire(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create lock dir: %w", err)
+	}
+	file, err := acquire(path)
+	if err != nil {
+		if errors.Is(err, ErrLocked) {
+			if pid := Holder(path); pid > 0 {
+				return nil, fmt.Errorf("%w (pid %d): %s", ErrLocked, pid, path)
+			}
+			return nil, fmt.Errorf("%w: %s", ErrLocked, path)
+		}
+		return nil, err
+	}
+
+	_ = file.Truncate(0)
+	_, _ = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return &Lock{path: path, file: file}, nil
+}
+
+// Release drops the lock.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := release(l.path, l.file)
+	l.file = nil
+	return err
+}
+
+// Held reports whether another process currently holds the lock at path.
+func Held(path string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	file, err := acquire(path)
+	if err != nil {
+		return errors.Is(err, ErrLocked)
+	}
+	_ = release(path, file)
+	return false
+}
+
+// Holder returns the PID recorded in the lock file, or 0.
+func Holder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
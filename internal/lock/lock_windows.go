@@ -0,0 +1,30 @@
+//go:build windows
+
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// acquire falls back to exclusive creation; a crashed holder leaves the
+// file behind and it must be removed by hand.
+func acquire(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("open lock: %w", err)
+	}
+	return file, nil
+}
+
+func release(path string, file *os.File) error {
+	err := file.Close()
+	if rerr := os.Remove(path); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package manifest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Project is a single <project> entry of a repo manifest.
+type Project struct {
+	Name     string `xml:"name,attr"`
+	Path     string `xml:"path,attr"`
+	Remote   string `xml:"remote,attr"`
+	Revision string `xml:"revision,attr"`
+}
+
+// Manifest is the flattened view of a tree's repo manifests, including
+// <include> files and .repo/local_manifests overrides.
+type Manifest struct {
+	Projects []Project
+}
+
+type document struct {
+	Projects []Project `xml:"project"`
+	Removes  []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"remove-project"`
+	Includes []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"include"`
+}
+
+// Load parses .repo/manifest.xml and local manifests for the source tree.
+func Load(sourceDir string) (*Manifest, error) {
+	repoDir := filepath.Join(sourceDir, ".repo")
+	m := &Manifest{}
+	seen := map[string]bool{}
+
+	if err := m.parse(filepath.Join(repoDir, "manifest.xml"), filepath.Join(repoDir, "manifests"), seen); err != nil {
+		return nil, err
+	}
+
+	locals, err := filepath.Glob(filepath.Join(repoDir, "local_manifests", "*.xml"))
+	if err != nil {
+		return nil, fmt.Errorf("list local manifests: %w", err)
+	}
+	sort.Strings(locals)
+	for _, local := range locals {
+		if err := m.parse(local, filepath.Dir(local), seen); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Manifest) parse(path, includeDir string, seen map[string]bool) error {
+	if seen[path] {
+		return nil
+	}
+	seen[path] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	var doc document
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parse manifest %s: %w", path, err)
+	}
+
+	for _, include := range doc.Includes {
+		if err := m.parse(filepath.Join(includeDir, include.Name), includeDir, seen); err != nil {
+			return err
+		}
+	}
+	for _, remove := range doc.Removes {
+		m.remove(remove.Name)
+	}
+	for _, project := range doc.Projects {
+		if project.Path == "" {
+			project.Path = project.Name
+		}
+		m.remove(project.Name)
+		m.Projects = append(m.Projects, project)
+	}
+	return nil
+}
+
+func (m *Manifest) remove(name string) {
+	kept := m.Projects[:0]
+	for _, project := range m.Projects {
+		if project.Name != name {
+			kept = append(kept, project)
+		}
+	}
+	m.Projects = kept
+}
+
+// ProjectByName returns the project whose manifest name matches, ignoring a
+// trailing ".git" on either side.
+func (m *Manifest) ProjectByName(name string) *Project {
+	name = strings.TrimSuffix(name, ".git")
+	for i := range m.Projects {
+		if strings.TrimSuffix(m.Projects[i].Name, ".git") == name {
+			return &m.Projects[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// textWriter writes the Prometheus text exposition format (version 0.0.4).
+type textWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// labels are name/value pairs in output order.
+type labels []string
+
+func (l labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(l)/2)
+	for i := 0; i+1 < len(l); i += 2 {
+		parts = append(parts, l[i]+`="`+escapeLabel(l[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (l labels) with(name, value string) labels {
+	return append(append(labels{}, l...), name, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func (t *textWriter) printf(format string, args ...any) {
+	if t.err == nil {
+		_, t.err = fmt.Fprintf(t.w, format, args...)
+	}
+}
+
+// family starts a metric family.
+func (t *textWriter) family(name, kind, help string) {
+	t.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (t *textWriter) sample(name string, l labels, value float64) {
+	t.printf("%s%s %s\n", name, l, formatValue(value))
+}
+
+// histogram writes one series of a histogram family from raw observations.
+func (t *textWriter) histogram(name string, l labels, buckets []float64, observations []float64) {
+	sorted := append([]float64(nil), observations...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	for _, bound := range buckets {
+		count := sort.Search(len(sorted), func(i int) bool { return sorted[i] > bound })
+		t.sample(name+"_bucket", l.with("le", formatValue(bound)), float64(count))
+	}
+	t.sample(name+"_bucket", l.with("le", "+Inf"), float64(len(sorted)))
+	t.sample(name+"_sum", l, sum)
+	t.sample(name+"_count", l, float64(len(sorted)))
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
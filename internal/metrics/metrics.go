@@ -0,0 +1,259 @@
+// Package metrics exports build, sync, ccache, disk and queue state in the
+// Prometheus text format. Values are derived from the history stores on
+// every scrape, so builds run by any process are counted.
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/ccache"
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/history"
+	"github.com/koobie777/ark-android-forge/internal/scheduler"
+)
+
+// ContentType is the media type of the exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Histogram buckets in seconds. Builds run from minutes (incremental) to
+// half a day (clean ROM on a small box).
+var (
+	buildBuckets = []float64{300, 900, 1800, 3600, 7200, 10800, 14400, 21600, 28800, 43200}
+	syncBuckets  = []float64{60, 300, 900, 1800, 3600, 7200, 14400}
+)
+
+// Collector gathers the metrics. Disk usage comes from Disk, which scans
+// trees in the background; trees not scanned yet are left out.
+type Collector struct {
+	Config *config.Config
+	Disk   *android.UsageScanner
+}
+
+// ServeHTTP answers a scrape. Errors fail the scrape so Prometheus marks
+// the target down instead of recording partial data.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// Write renders every metric family to out.
+func (c *Collector) Write(out io.Writer) error {
+	if c.Config == nil {
+		return fmt.Errorf("config is nil")
+	}
+	t := &textWriter{w: bufio.NewWriter(out)}
+	for _, collect := range []func(*textWriter) error{c.builds, c.syncs, c.ccache, c.disk, c.queue} {
+		if err := collect(t); err != nil {
+			return err
+		}
+	}
+	if t.err != nil {
+		return t.err
+	}
+	return t.w.Flush()
+}
+
+// series groups observations by label set, keeping label sets sorted for
+// stable output.
+type series[T any] struct {
+	keys   []string
+	labels map[string]labels
+	values map[string][]T
+}
+
+func newSeries[T any]() *series[T] {
+	return &series[T]{labels: map[string]labels{}, values: map[string][]T{}}
+}
+
+func (s *series[T]) add(l labels, value T) {
+	key := strings.Join(l, "\x00")
+	if _, ok := s.labels[key]; !ok {
+		s.keys = append(s.keys, key)
+		s.labels[key] = l
+	}
+	s.values[key] = append(s.values[key], value)
+}
+
+func (s *series[T]) each(fn func(labels, []T)) {
+	sort.Strings(s.keys)
+	for _, key := range s.keys {
+		fn(s.labels[key], s.values[key])
+	}
+}
+
+func (c *Collector) builds(t *textWriter) error {
+	records, err := history.Load(history.Path(c.Config))
+	if err != nil {
+		return err
+	}
+	outcomes := newSeries[struct{}]()
+	durations := newSeries[float64]()
+	lastSuccess := newSeries[float64]()
+	for _, record := range records {
+		l := labels{"device", record.Device, "target", record.Target()}
+		outcomes.add(l.with("result", record.Result), struct{}{})
+		durations.add(l, record.Duration.Seconds())
+		if record.Result == history.ResultSuccess {
+			lastSuccess.add(l, float64(record.FinishedAt.Unix()))
+		}
+	}
+
+	t.family("arkforge_builds_total", "counter", "Finished builds by device, target and result.")
+	outcomes.each(func(l labels, v []struct{}) { t.sample("arkforge_builds_total", l, float64(len(v))) })
+	t.family("arkforge_build_duration_seconds", "histogram", "Build duration by device and target.")
+	durations.each(func(l labels, v []float64) { t.histogram("arkforge_build_duration_seconds", l, buildBuckets, v) })
+	t.family("arkforge_build_last_success_timestamp_seconds", "gauge", "Unix time of the last successful build.")
+	lastSuccess.each(func(l labels, v []float64) { t.sample("arkforge_build_last_success_timestamp_seconds", l, v[len(v)-1]) })
+	return nil
+}
+
+func (c *Collector) syncs(t *textWriter) error {
+	records, err := history.LoadSyncs(history.SyncPath(c.Config))
+	if err != nil {
+		return err
+	}
+	outcomes := newSeries[struct{}]()
+	durations := newSeries[float64]()
+	for _, record := range records {
+		l := labels{"dir", record.Dir}
+		outcomes.add(l.with("result", record.Result), struct{}{})
+		durations.add(l, record.Duration.Seconds())
+	}
+
+	t.family("arkforge_syncs_total", "counter", "Finished repo syncs by directory and result.")
+	outcomes.each(func(l labels, v []struct{}) { t.sample("arkforge_syncs_total", l, float64(len(v))) })
+	t.family("arkforge_sync_duration_seconds", "histogram", "Repo sync duration by directory.")
+	durations.each(func(l labels, v []float64) { t.histogram("arkforge_sync_duration_seconds", l, syncBuckets, v) })
+	return nil
+}
+
+// ccache sums the per-build deltas recorded in each cache directory. Deltas
+// of builds that shared the cache with a parallel build count another
+// build's activity too, so they are left out of hits, misses and ratio.
+func (c *Collector) ccache(t *textWriter) error {
+	type totals struct{ hits, misses, size int64 }
+	dirs := map[string]*totals{}
+	var order []string
+	for _, repo := range c.cacheRepositories() {
+		dir := ccache.Dir(c.Config, repo)
+		if _, ok := dirs[dir]; ok {
+			continue
+		}
+		builds, err := ccache.History(dir)
+		if err != nil {
+			return err
+		}
+		if len(builds) == 0 {
+			continue
+		}
+		sum := &totals{}
+		for _, build := range builds {
+			if build.Shared {
+				continue
+			}
+			sum.hits += build.Delta.Hits()
+			sum.misses += build.Delta.Misses
+		}
+		sum.size = builds[len(builds)-1].Delta.SizeBytes
+		dirs[dir] = sum
+		order = append(order, dir)
+	}
+	sort.Strings(order)
+
+	t.family("arkforge_ccache_hits_total", "counter", "ccache hits during recorded builds that had the cache to themselves.")
+	for _, dir := range order {
+		t.sample("arkforge_ccache_hits_total", labels{"cache", dir}, float64(dirs[dir].hits))
+	}
+	t.family("arkforge_ccache_misses_total", "counter", "ccache misses during recorded builds that had the cache to themselves.")
+	for _, dir := range order {
+		t.sample("arkforge_ccache_misses_total", labels{"cache", dir}, float64(dirs[dir].misses))
+	}
+	t.family("arkforge_ccache_hit_ratio", "gauge", "ccache hits over hits plus misses across recorded builds that had the cache to themselves.")
+	for _, dir := range order {
+		if total := dirs[dir].hits + dirs[dir].misses; total > 0 {
+			t.sample("arkforge_ccache_hit_ratio", labels{"cache", dir}, float64(dirs[dir].hits)/float64(total))
+		}
+	}
+	t.family("arkforge_ccache_size_bytes", "gauge", "ccache size after the last recorded build.")
+	for _, dir := range order {
+		t.sample("arkforge_ccache_size_bytes", labels{"cache", dir}, float64(dirs[dir].size))
+	}
+	return nil
+}
+
+// cacheRepositories lists every repository a cache may be keyed by.
+func (c *Collector) cacheRepositories() []string {
+	repos := []string{""}
+	for _, repo := range c.Config.Repos {
+		repos = append(repos, repo.Name)
+	}
+	return append(repos, android.CacheRepositories(c.Config)...)
+}
+
+func (c *Collector) disk(t *textWriter) error {
+	free, size := android.DiskSpace(c.Config.Build.Workspace)
+	workspace := labels{"workspace", c.Config.Build.Workspace}
+	t.family("arkforge_workspace_free_bytes", "gauge", "Free space on the workspace filesystem.")
+	t.sample("arkforge_workspace_free_bytes", workspace, float64(free))
+	t.family("arkforge_workspace_size_bytes", "gauge", "Size of the workspace filesystem.")
+	t.sample("arkforge_workspace_size_bytes", workspace, float64(size))
+
+	if c.Disk == nil {
+		return nil
+	}
+	trees, err := android.WorkspaceTrees(c.Config, "")
+	if err != nil {
+		return err
+	}
+	var usages []android.TreeUsage
+	for _, tree := range trees {
+		if usage := c.Disk.Usage(tree); !usage.ScannedAt.IsZero() {
+			usages = append(usages, usage)
+		}
+	}
+	t.family("arkforge_tree_size_bytes", "gauge", "Apparent size of a workspace tree.")
+	for _, usage := range usages {
+		t.sample("arkforge_tree_size_bytes", labels{"tree", usage.Tree, "device", usage.Device}, float64(usage.Total))
+	}
+	t.family("arkforge_tree_out_bytes", "gauge", "Apparent size of a tree's out/ directory.")
+	for _, usage := range usages {
+		t.sample("arkforge_tree_out_bytes", labels{"tree", usage.Tree, "device", usage.Device}, float64(usage.Out))
+	}
+	return nil
+}
+
+func (c *Collector) queue(t *textWriter) error {
+	state, err := scheduler.LoadState(c.Config)
+	if err != nil {
+		return err
+	}
+	interrupted, err := android.Interrupted(c.Config)
+	if err != nil {
+		return err
+	}
+	running := 0
+	for _, build := range interrupted {
+		if build.Running {
+			running++
+		}
+	}
+	t.family("arkforge_queue_depth", "gauge", "Scheduled jobs waiting in the daemon queue.")
+	t.sample("arkforge_queue_depth", nil, float64(len(state.Queue)))
+	t.family("arkforge_builds_running", "gauge", "Builds currently running in the workspace.")
+	t.sample("arkforge_builds_running", nil, float64(running))
+	t.family("arkforge_builds_interrupted", "gauge", "Builds that stopped without finishing.")
+	t.sample("arkforge_builds_interrupted", nil, float64(len(interrupted)-running))
+	return nil
+}
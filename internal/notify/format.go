@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+)
+
+// Message length limits of the chat services; longer excerpts are cut from
+// the top.
+const (
+	discordLimit  = 2000
+	telegramLimit = 4096
+	defaultLimit  = 8000
+)
+
+// request is a prepared webhook call, reused across retries.
+type request struct {
+	method  string
+	url     string
+	headers map[string]string
+	body    []byte
+}
+
+// buildRequest renders ev in the payload format of target.
+func buildRequest(target config.NotifyTarget, ev Event) (request, error) {
+	req := request{method: "POST", url: target.URL, headers: map[string]string{}}
+	for k, v := range target.Headers {
+		req.headers[k] = v
+	}
+
+	var payload any
+	switch target.Type {
+	case TypeWebhook:
+		payload = struct {
+			Event
+			Text string `json:"text"`
+		}{ev, Message(ev, false, defaultLimit)}
+	case TypeDiscord:
+		payload = map[string]any{"content": Message(ev, true, discordLimit)}
+	case TypeSlack:
+		payload = map[string]any{"text": Message(ev, true, defaultLimit)}
+	case TypeTelegram:
+		if req.url == "" {
+			req.url = "https://api.telegram.org/bot" + target.Token + "/sendMessage"
+		}
+		payload = map[string]any{
+			"chat_id":                  target.ChatID,
+			"text":                     Message(ev, false, telegramLimit),
+			"disable_web_page_preview": true,
+		}
+	case TypeMatrix:
+		// The transaction ID makes retries idempotent.
+		txn := "arkforge-" + strconv.FormatInt(ev.Time.UnixNano(), 36) + "-" + ev.Name
+		req.method = "PUT"
+		req.url = strings.TrimSuffix(target.URL, "/") + "/_matrix/client/v3/rooms/" +
+			url.PathEscape(target.Room) + "/send/m.room.message/" + url.PathEscape(txn)
+		req.headers["Authorization"] = "Bearer " + target.Token
+		payload = map[string]any{"msgtype": "m.notice", "body": Message(ev, false, defaultLimit)}
+	default:
+		return request{}, fmt.Errorf("unknown notification type %q", target.Type)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return request{}, fmt.Errorf("encode payload: %w", err)
+	}
+	req.body = body
+	return req, nil
+}
+
+// Message renders ev as chat text of at most limit bytes. fence wraps the
+// failure excerpt in a Markdown code block.
+func Message(ev Event, fence bool, limit int) string {
+	var b strings.Builder
+	b.WriteString(Headline(ev))
+	if ev.Error != "" {
+		b.WriteString("\n" + ev.Error)
+	}
+	for _, artifact := range ev.Artifacts {
+		fmt.Fprintf(&b, "\n- %s (%s)", artifact.Name, formatSize(artifact.Size))
+		if artifact.URL != "" {
+			b.WriteString(" " + artifact.URL)
+		}
+	}
+	if ev.LogURL != "" {
+		b.WriteString("\nLog: " + ev.LogURL)
+	}
+	text := b.String()
+	if len(text) > limit {
+		return text[:limit]
+	}
+
+	// Keep as much of the excerpt's tail as fits; the last lines usually
+	// hold the error.
+	excerpt := ev.Excerpt
+	for len(excerpt) > 0 {
+		block := strings.Join(excerpt, "\n")
+		if fence {
+			block = "```\n" + block + "\n```"
+		}
+		if len(text)+1+len(block) <= limit {
+			return text + "\n" + block
+		}
+		excerpt = excerpt[1:]
+	}
+	return text
+}
+
+// Headline is the one-line summary of ev.
+func Headline(ev Event) string {
+	subject := strings.TrimSpace(ev.Device + " " + ev.Target)
+	if subject == "" {
+		subject = ev.Tree
+	}
+	switch ev.Name {
+	case EventBuildStart:
+		return fmt.Sprintf("Build started: %s on %s", subject, ev.Host)
+	case EventBuildSuccess:
+		return fmt.Sprintf("Build succeeded: %s in %s", subject, formatDuration(ev.Duration))
+	case EventBuildFailure:
+		return fmt.Sprintf("Build FAILED: %s after %s", subject, formatDuration(ev.Duration))
+	case EventSyncStart:
+		return fmt.Sprintf("Sync started: %s on %s", subject, ev.Host)
+	case EventSyncSuccess:
+		return fmt.Sprintf("Sync finished: %s in %s", subject, formatDuration(ev.Duration))
+	case EventSyncFailure:
+		return fmt.Sprintf("Sync FAILED: %s after %s", subject, formatDuration(ev.Duration))
+	case EventTest:
+		return fmt.Sprintf("Test notification from ark-android-forge on %s", ev.Host)
+	default:
+		return fmt.Sprintf("%s: %s", ev.Name, subject)
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	if d >= time.Minute {
+		d = d.Round(time.Minute)
+	}
+	return d.Round(time.Second).String()
+}
+
+// formatSize renders a byte count with binary units (e.g. "96.0 MiB").
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
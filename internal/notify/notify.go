@@ -0,0 +1,273 @@
+// Package notify posts build and sync events to chat and webhook targets.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+)
+
+// Event names.
+const (
+	EventBuildStart   = "build.start"
+	EventBuildSuccess = "build.success"
+	EventBuildFailure = "build.failure"
+	EventSyncStart    = "sync.start"
+	EventSyncSuccess  = "sync.success"
+	EventSyncFailure  = "sync.failure"
+	EventTest         = "test"
+)
+
+// Target types.
+const (
+	TypeWebhook  = "webhook"
+	TypeDiscord  = "discord"
+	TypeSlack    = "slack"
+	TypeTelegram = "telegram"
+	TypeMatrix   = "matrix"
+)
+
+const (
+	defaultRetries = 3
+	requestTimeout = 15 * time.Second
+)
+
+// Event is something worth telling people about.
+type Event struct {
+	Name      string        `json:"event"`
+	Time      time.Time     `json:"time"`
+	Host      string        `json:"host,omitempty"`
+	Device    string        `json:"device,omitempty"`
+	Target    string        `json:"target,omitempty"`
+	Tree      string        `json:"tree,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Excerpt   []string      `json:"excerpt,omitempty"`
+	Artifacts []Artifact    `json:"artifacts,omitempty"`
+	// Build is the history ID of a finished build; with a base URL it
+	// links the log and artifacts.
+	Build  string `json:"build,omitempty"`
+	LogURL string `json:"logUrl,omitempty"`
+}
+
+// Artifact is a build output, linked when a base URL is configured.
+type Artifact struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	URL  string `json:"url,omitempty"`
+}
+
+// Notifier delivers events to the configured targets.
+type Notifier struct {
+	Targets []config.NotifyTarget
+	BaseURL string
+	Retries int
+	// Backoff is the delay before the first retry; it doubles per attempt.
+	Backoff time.Duration
+	Client  *http.Client
+	Logger  zerolog.Logger
+}
+
+// New returns a notifier for cfg.Notify.
+func New(cfg *config.Config, logger zerolog.Logger) *Notifier {
+	retries := cfg.Notify.Retries
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+	return &Notifier{
+		Targets: cfg.Notify.Targets,
+		BaseURL: strings.TrimSuffix(cfg.Notify.BaseURL, "/"),
+		Retries: retries,
+		Backoff: 2 * time.Second,
+		Client:  &http.Client{Timeout: requestTimeout},
+		Logger:  logger,
+	}
+}
+
+// Validate checks the configured targets.
+func Validate(cfg *config.Config) error {
+	var errs []error
+	for i, target := range cfg.Notify.Targets {
+		name := target.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		if err := validateTarget(target); err != nil {
+			errs = append(errs, fmt.Errorf("notification target %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateTarget(target config.NotifyTarget) error {
+	switch target.Type {
+	case TypeWebhook, TypeDiscord, TypeSlack:
+		if target.URL == "" {
+			return fmt.Errorf("url is required")
+		}
+	case TypeTelegram:
+		if target.URL == "" && target.Token == "" {
+			return fmt.Errorf("token or url is required")
+		}
+		if target.ChatID == "" {
+			return fmt.Errorf("chatId is required")
+		}
+	case TypeMatrix:
+		if target.URL == "" || target.Room == "" || target.Token == "" {
+			return fmt.Errorf("url (homeserver), room and token are required")
+		}
+	default:
+		return fmt.Errorf("unknown type %q (want webhook, discord, slack, telegram or matrix)", target.Type)
+	}
+	return nil
+}
+
+// Notify sends ev to every target subscribed to it. Failed deliveries are
+// logged and returned together; callers treat them as warnings.
+func (n *Notifier) Notify(ctx context.Context, ev Event) error {
+	if len(n.Targets) == 0 {
+		return nil
+	}
+	ev = n.prepare(ev)
+	var errs []error
+	for _, target := range n.Targets {
+		if !Subscribed(target, ev.Name) {
+			continue
+		}
+		if err := n.Send(ctx, target, ev); err != nil {
+			n.Logger.Warn().Err(err).Str("target", target.Name).Str("event", ev.Name).Msg("notify: delivery failed")
+			errs = append(errs, fmt.Errorf("%s: %w", target.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Subscribed reports whether target wants the named event. Test events go
+// to every target.
+func Subscribed(target config.NotifyTarget, name string) bool {
+	if len(target.Events) == 0 || name == EventTest {
+		return true
+	}
+	for _, want := range target.Events {
+		if want == name || strings.HasPrefix(name, want+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// prepare fills in the time, host and links.
+func (n *Notifier) prepare(ev Event) Event {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	if ev.Host == "" {
+		ev.Host, _ = os.Hostname()
+	}
+	if n.BaseURL == "" || ev.Build == "" {
+		return ev
+	}
+	base := n.BaseURL + "/api/v1/history/" + url.PathEscape(ev.Build)
+	if ev.LogURL == "" {
+		ev.LogURL = base + "/log"
+	}
+	artifacts := make([]Artifact, len(ev.Artifacts))
+	for i, artifact := range ev.Artifacts {
+		if artifact.URL == "" {
+			artifact.URL = base + "/artifacts/" + strconv.Itoa(i)
+		}
+		artifacts[i] = artifact
+	}
+	ev.Artifacts = artifacts
+	return ev
+}
+
+// Send delivers ev to one target, retrying network errors, 429 and 5xx
+// responses with exponential backoff.
+func (n *Notifier) Send(ctx context.Context, target config.NotifyTarget, ev Event) error {
+	req, err := buildRequest(target, n.prepare(ev))
+	if err != nil {
+		return err
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	delay := n.Backoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := deliver(ctx, client, req)
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) || attempt >= n.Retries {
+			return err
+		}
+		wait := delay
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		n.Logger.Debug().Err(err).Str("target", target.Name).Dur("retry_in", wait).Msg("notify: retrying")
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (gave up: %v)", err, ctx.Err())
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// permanentError is a failure that retrying will not fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// deliver performs one attempt, returning the server's Retry-After hint.
+func deliver(ctx context.Context, client *http.Client, r request) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url, bytes.NewReader(r.body))
+	if err != nil {
+		return 0, &permanentError{fmt.Errorf("build request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ark-android-forge")
+	for k, v := range r.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// Webhook URLs carry secrets; keep them out of the error.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, fmt.Errorf("post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 300 {
+		return 0, nil
+	}
+	retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	err = fmt.Errorf("webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return time.Duration(retryAfter) * time.Second, err
+	}
+	return 0, &permanentError{err}
+}
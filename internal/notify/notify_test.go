@@ -0,0 +1,227 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+)
+
+// hook is a webhook endpoint that answers with the queued status codes
+// (then 200) and records every request.
+type hook struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []recorded
+}
+
+type recorded struct {
+	method, path, contentType, auth string
+	body                            map[string]any
+}
+
+func (h *hook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	var body map[string]any
+	_ = json.Unmarshal(data, &body)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests = append(h.requests, recorded{r.Method, r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Authorization"), body})
+	status := http.StatusOK
+	if len(h.statuses) > 0 {
+		status, h.statuses = h.statuses[0], h.statuses[1:]
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, http.StatusText(status))
+}
+
+func newHook(t *testing.T, statuses ...int) (*hook, string) {
+	t.Helper()
+	h := &hook{statuses: statuses}
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return h, server.URL
+}
+
+func testNotifier(targets ...config.NotifyTarget) *Notifier {
+	return &Notifier{
+		Targets: targets,
+		Retries: 2,
+		Backoff: time.Millisecond,
+		Client:  &http.Client{Timeout: 5 * time.Second},
+		Logger:  zerolog.Nop(),
+	}
+}
+
+var failure = Event{
+	Name:     EventBuildFailure,
+	Time:     time.Date(2024, 6, 7, 12, 0, 0, 0, time.UTC),
+	Host:     "builder",
+	Device:   "bacon",
+	Target:   "bacon",
+	Duration: 90 * time.Minute,
+	Error:    "exit status 1",
+	Excerpt:  []string{"FAILED: out/target", "ninja: build stopped"},
+	Build:    "b42",
+}
+
+func TestPayloadFormats(t *testing.T) {
+	tests := []struct {
+		target config.NotifyTarget
+		method string
+		path   string
+		check  func(t *testing.T, r recorded)
+	}{
+		{
+			target: config.NotifyTarget{Type: TypeWebhook, Headers: map[string]string{"Authorization": "Token abc"}},
+			method: "POST", path: "/",
+			check: func(t *testing.T, r recorded) {
+				if r.body["event"] != EventBuildFailure || r.body["device"] != "bacon" || r.body["build"] != "b42" {
+					t.Errorf("webhook body = %v", r.body)
+				}
+				if r.body["logUrl"] != "http://forge.local/api/v1/history/b42/log" {
+					t.Errorf("logUrl = %v", r.body["logUrl"])
+				}
+				if text, _ := r.body["text"].(string); strings.Contains(text, "```") {
+					t.Errorf("webhook text is fenced: %q", text)
+				}
+				if r.auth != "Token abc" {
+					t.Errorf("custom header = %q", r.auth)
+				}
+			},
+		},
+		{
+			target: config.NotifyTarget{Type: TypeDiscord},
+			method: "POST", path: "/",
+			check: func(t *testing.T, r recorded) {
+				content, _ := r.body["content"].(string)
+				if !strings.HasPrefix(content, "Build FAILED: bacon bacon after 1h30m0s") || !strings.Contains(content, "```\nFAILED: out/target") {
+					t.Errorf("discord content = %q", content)
+				}
+			},
+		},
+		{
+			target: config.NotifyTarget{Type: TypeSlack},
+			method: "POST", path: "/",
+			check: func(t *testing.T, r recorded) {
+				if text, _ := r.body["text"].(string); !strings.HasPrefix(text, "Build FAILED") {
+					t.Errorf("slack text = %q", text)
+				}
+			},
+		},
+		{
+			target: config.NotifyTarget{Type: TypeTelegram, ChatID: "-100"},
+			method: "POST", path: "/",
+			check: func(t *testing.T, r recorded) {
+				if r.body["chat_id"] != "-100" || r.body["disable_web_page_preview"] != true {
+					t.Errorf("telegram body = %v", r.body)
+				}
+			},
+		},
+		{
+			target: config.NotifyTarget{Type: TypeMatrix, Room: "!room:example.org", Token: "mx"},
+			method: "PUT", path: "/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/",
+			check: func(t *testing.T, r recorded) {
+				if r.auth != "Bearer mx" || r.body["msgtype"] != "m.notice" {
+					t.Errorf("matrix auth = %q, body = %v", r.auth, r.body)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target.Type, func(t *testing.T) {
+			h, url := newHook(t)
+			target := tt.target
+			target.Name = tt.target.Type
+			target.URL = url
+			n := testNotifier(target)
+			n.BaseURL = "http://forge.local"
+			if err := n.Notify(context.Background(), failure); err != nil {
+				t.Fatalf("Notify: %v", err)
+			}
+			if len(h.requests) != 1 {
+				t.Fatalf("%d requests, want 1", len(h.requests))
+			}
+			r := h.requests[0]
+			if r.method != tt.method || !strings.HasPrefix(r.path, tt.path) || r.contentType != "application/json" {
+				t.Fatalf("request = %s %s (%s)", r.method, r.path, r.contentType)
+			}
+			tt.check(t, r)
+		})
+	}
+}
+
+func TestSendRetriesServerErrors(t *testing.T) {
+	h, url := newHook(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	n := testNotifier()
+	if err := n.Send(context.Background(), config.NotifyTarget{Type: TypeSlack, URL: url}, failure); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(h.requests) != 3 {
+		t.Fatalf("%d attempts, want 3", len(h.requests))
+	}
+}
+
+func TestSendGivesUpAfterRetries(t *testing.T) {
+	h, url := newHook(t, 500, 500, 500, 500)
+	n := testNotifier()
+	err := n.Send(context.Background(), config.NotifyTarget{Type: TypeSlack, URL: url}, failure)
+	if err == nil || !strings.Contains(err.Error(), "webhook returned 500") {
+		t.Fatalf("Send error = %v", err)
+	}
+	if len(h.requests) != n.Retries+1 {
+		t.Fatalf("%d attempts, want %d", len(h.requests), n.Retries+1)
+	}
+}
+
+func TestSendDoesNotRetryClientErrors(t *testing.T) {
+	h, url := newHook(t, http.StatusBadRequest)
+	err := testNotifier().Send(context.Background(), config.NotifyTarget{Type: TypeDiscord, URL: url}, failure)
+	if err == nil || !strings.Contains(err.Error(), "webhook returned 400") {
+		t.Fatalf("Send error = %v", err)
+	}
+	if len(h.requests) != 1 {
+		t.Fatalf("%d attempts, want 1", len(h.requests))
+	}
+}
+
+func TestNotifyFiltersAndKeepsSecretsOutOfErrors(t *testing.T) {
+	h, url := newHook(t)
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL + "/api/webhooks/123/s3cr3t"
+	dead.Close()
+
+	n := testNotifier(
+		config.NotifyTarget{Name: "builds", Type: TypeSlack, URL: url, Events: []string{"build"}},
+		config.NotifyTarget{Name: "syncs", Type: TypeSlack, URL: url, Events: []string{"sync.failure"}},
+		config.NotifyTarget{Name: "down", Type: TypeDiscord, URL: deadURL},
+	)
+	err := n.Notify(context.Background(), failure)
+	if err == nil || !strings.Contains(err.Error(), "down:") {
+		t.Fatalf("Notify error = %v, want the dead target reported", err)
+	}
+	if strings.Contains(err.Error(), "s3cr3t") {
+		t.Fatalf("error leaks the webhook URL: %v", err)
+	}
+	if len(h.requests) != 1 {
+		t.Fatalf("%d deliveries, want only the build subscriber", len(h.requests))
+	}
+}
+
+func TestMessageKeepsExcerptTail(t *testing.T) {
+	ev := Event{Name: EventBuildFailure, Device: "bacon", Excerpt: []string{strings.Repeat("x", 50), "middle", "last line"}}
+	msg := Message(ev, true, 80)
+	if len(msg) > 80 || !strings.HasSuffix(msg, "middle\nlast line\n```") || strings.Contains(msg, "xxx") {
+		t.Fatalf("Message = %q", msg)
+	}
+}
@@ -0,0 +1,251 @@
+package ota
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/koobie777/ark-android-forge/internal/execx"
+)
+
+// ExtractOptions selects what Extract writes. No partitions means all.
+type ExtractOptions struct {
+	Partitions []string
+	Dir        string
+}
+
+// Extracted is a partition image written by Extract.
+type Extracted struct {
+	Name   string
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// extractable are the operations a full payload is built from. Delta
+// operations need the installed image and are not supported.
+var extractable = []OpType{OpReplace, OpReplaceBZ, OpReplaceXZ, OpZero, OpDiscard}
+
+// partitionNameRegexp matches the partition names images are written
+// under; anything else in an untrusted payload could escape the output dir.
+var partitionNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Extract writes partition images from a full OTA's payload to
+// <dir>/<partition>.img, checking every operation's data hash and the
+// final image hash. REPLACE_XZ data is decoded by the xz binary.
+func Extract(ctx context.Context, runner *execx.Runner, pkg *Package, opts ExtractOptions) ([]Extracted, error) {
+	if pkg.Payload == nil {
+		return nil, fmt.Errorf("no %s in package", PayloadPath)
+	}
+	if pkg.Incremental() {
+		return nil, errors.New("package is an incremental OTA; extract needs a full one")
+	}
+	if opts.Dir == "" {
+		return nil, errors.New("output directory is required")
+	}
+
+	partitions, err := selectPartitions(pkg.Payload.Manifest, opts.Partitions)
+	if err != nil {
+		return nil, err
+	}
+	if usesOp(partitions, OpReplaceXZ) {
+		if _, err := exec.LookPath("xz"); err != nil {
+			return nil, errors.New("payload has REPLACE_XZ operations; install xz to extract it")
+		}
+	}
+	payload, err := pkg.PayloadReader()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create output dir: %w", err)
+	}
+
+	x := extractor{
+		runner:    runner,
+		payload:   payload,
+		dataStart: int64(pkg.Payload.Header.DataOffset()),
+		blockSize: uint64(pkg.Payload.Manifest.BlockSize),
+	}
+	var extracted []Extracted
+	for _, partition := range partitions {
+		path := filepath.Join(opts.Dir, partition.Name+".img")
+		sum, err := x.partition(ctx, partition, path)
+		if err != nil {
+			return extracted, fmt.Errorf("%s: %w", partition.Name, err)
+		}
+		extracted = append(extracted, Extracted{Name: partition.Name, Path: path, Size: int64(partition.New.Size), SHA256: sum})
+	}
+	return extracted, nil
+}
+
+// selectPartitions returns the named partitions in payload order and
+// rejects any that use operations Extract cannot apply or whose names are
+// not safe file names.
+func selectPartitions(manifest Manifest, names []string) ([]Partition, error) {
+	var selected []Partition
+	for _, name := range names {
+		if !slices.ContainsFunc(manifest.Partitions, func(p Partition) bool { return p.Name == name }) {
+			return nil, fmt.Errorf("payload has no partition %q", name)
+		}
+	}
+	for _, partition := range manifest.Partitions {
+		if len(names) > 0 && !slices.Contains(names, partition.Name) {
+			continue
+		}
+		if filepath.Base(partition.Name) != partition.Name || !partitionNameRegexp.MatchString(partition.Name) {
+			return nil, fmt.Errorf("payload has invalid partition name %q", partition.Name)
+		}
+		for _, op := range partition.Operations {
+			if !slices.Contains(extractable, op.Type) {
+				return nil, fmt.Errorf("%s: unsupported operation %s", partition.Name, op.Type)
+			}
+		}
+		selected = append(selected, partition)
+	}
+	return selected, nil
+}
+
+func usesOp(partitions []Partition, opType OpType) bool {
+	for _, partition := range partitions {
+		for _, op := range partition.Operations {
+			if op.Type == opType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type extractor struct {
+	runner    *execx.Runner
+	payload   *io.SectionReader
+	dataStart int64
+	blockSize uint64
+}
+
+// partition applies the operations to a temporary file and renames it into
+// place once the image hash matches.
+func (x extractor) partition(ctx context.Context, partition Partition, path string) (string, error) {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return "", fmt.Errorf("create image: %w", err)
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+	// Blocks no operation writes, and ZERO/DISCARD extents, stay sparse.
+	if err := file.Truncate(int64(partition.New.Size)); err != nil {
+		return "", fmt.Errorf("size image: %w", err)
+	}
+
+	for i, op := range partition.Operations {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if err := x.apply(ctx, file, op); err != nil {
+			return "", fmt.Errorf("operation %d (%s): %w", i, op.Type, err)
+		}
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, int64(partition.New.Size))); err != nil {
+		return "", fmt.Errorf("hash image: %w", err)
+	}
+	sum := hash.Sum(nil)
+	if len(partition.New.Hash) > 0 && !bytes.Equal(sum, partition.New.Hash) {
+		return "", fmt.Errorf("image hash %x does not match the payload's %x", sum, partition.New.Hash)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("write image: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("write image: %w", err)
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+func (x extractor) apply(ctx context.Context, file *os.File, op Operation) error {
+	if op.Type == OpZero || op.Type == OpDiscard {
+		return nil
+	}
+
+	data := io.NewSectionReader(x.payload, x.dataStart+int64(op.DataOffset), int64(op.DataLength))
+	if len(op.DataSHA256) > 0 {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, data); err != nil {
+			return fmt.Errorf("read data: %w", err)
+		}
+		if !bytes.Equal(hash.Sum(nil), op.DataSHA256) {
+			return errors.New("data hash mismatch")
+		}
+		if _, err := data.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	out := &extentWriter{file: file, extents: op.DstExtents, blockSize: x.blockSize}
+	switch op.Type {
+	case OpReplace:
+		if _, err := io.Copy(out, data); err != nil {
+			return err
+		}
+	case OpReplaceBZ:
+		if _, err := io.Copy(out, bzip2.NewReader(data)); err != nil {
+			return fmt.Errorf("bzip2: %w", err)
+		}
+	case OpReplaceXZ:
+		cmd := execx.Command{Name: "xz", Args: []string{"--decompress", "--stdout"}}
+		if err := x.runner.Pipe(ctx, cmd, data, out); err != nil {
+			return err
+		}
+	}
+	return out.err
+}
+
+// extentWriter spreads a stream over the operation's destination extents.
+type extentWriter struct {
+	file      *os.File
+	extents   []Extent
+	blockSize uint64
+	written   uint64 // into extents[0]
+	err       error
+}
+
+func (w *extentWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if len(w.extents) == 0 {
+			w.err = errors.New("data overruns the destination extents")
+			return total, w.err
+		}
+		extent := w.extents[0]
+		room := extent.NumBlocks*w.blockSize - w.written
+		n := uint64(len(p))
+		if n > room {
+			n = room
+		}
+		offset := int64(extent.StartBlock*w.blockSize + w.written)
+		if _, err := w.file.WriteAt(p[:n], offset); err != nil {
+			w.err = err
+			return total, err
+		}
+		total += int(n)
+		p = p[n:]
+		w.written += n
+		if w.written == extent.NumBlocks*w.blockSize {
+			w.extents, w.written = w.extents[1:], 0
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,285 @@
+// Package ota reads Android OTA packages: the metadata, the payload.bin
+// header and manifest of A/B updates, and payload_properties.txt.
+package ota
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Entries of an OTA zip.
+const (
+	MetadataPath   = "META-INF/com/android/metadata"
+	OTACertPath    = "META-INF/com/android/otacert"
+	PayloadPath    = "payload.bin"
+	PropertiesPath = "payload_properties.txt"
+)
+
+// Metadata is META-INF/com/android/metadata. Raw keeps every key.
+type Metadata struct {
+	Raw                map[string]string
+	Type               string
+	PreDevices         []string
+	PreBuild           string
+	PostBuild          string
+	PreIncremental     string
+	PostIncremental    string
+	PostTimestamp      int64
+	PostSDKLevel       string
+	PostSecurityPatch  string
+	RequiredCacheBytes string
+}
+
+// ParseMetadata interprets the metadata keys written by ota_from_target_files.
+func ParseMetadata(raw map[string]string) Metadata {
+	metadata := Metadata{
+		Raw:                raw,
+		Type:               raw["ota-type"],
+		PreBuild:           raw["pre-build"],
+		PostBuild:          raw["post-build"],
+		PreIncremental:     raw["pre-build-incremental"],
+		PostIncremental:    raw["post-build-incremental"],
+		PostSDKLevel:       raw["post-sdk-level"],
+		PostSecurityPatch:  raw["post-security-patch-level"],
+		RequiredCacheBytes: raw["ota-required-cache"],
+	}
+	for _, device := range strings.Split(raw["pre-device"], ",") {
+		if device = strings.TrimSpace(device); device != "" {
+			metadata.PreDevices = append(metadata.PreDevices, device)
+		}
+	}
+	timestamp := raw["post-timestamp"]
+	if timestamp == "" {
+		timestamp = raw["ro.build.date.utc"]
+	}
+	metadata.PostTimestamp, _ = strconv.ParseInt(timestamp, 10, 64)
+	return metadata
+}
+
+// FingerprintDevice returns the device field of a build fingerprint
+// (brand/product/device:release/id/incremental:type/tags).
+func FingerprintDevice(fingerprint string) string {
+	parts := strings.SplitN(fingerprint, "/", 4)
+	if len(parts) < 3 {
+		return ""
+	}
+	device, _, _ := strings.Cut(parts[2], ":")
+	return device
+}
+
+// ReadProps parses key=value lines, skipping blanks and comments.
+func ReadProps(r io.Reader) map[string]string {
+	props := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			props[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return props
+}
+
+// Package is an open OTA zip. Payload and Properties are nil for non-A/B
+// packages.
+type Package struct {
+	Path       string
+	Metadata   Metadata
+	Payload    *Payload
+	Properties map[string]string
+
+	file    *os.File
+	archive *zip.Reader
+}
+
+// Open reads the metadata, payload header and properties of an OTA zip.
+func Open(path string) (*Package, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open ota: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("stat ota: %w", err)
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("read ota zip: %w", err)
+	}
+	pkg := &Package{Path: path, file: file, archive: archive}
+	if err := pkg.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return pkg, nil
+}
+
+func (p *Package) load() error {
+	metadata, err := p.archive.Open(MetadataPath)
+	if err != nil {
+		return fmt.Errorf("not an OTA package: %w", err)
+	}
+	p.Metadata = ParseMetadata(ReadProps(metadata))
+	metadata.Close()
+
+	if properties, err := p.archive.Open(PropertiesPath); err == nil {
+		p.Properties = ReadProps(properties)
+		properties.Close()
+	}
+
+	payload, err := p.archive.Open(PayloadPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open %s: %w", PayloadPath, err)
+	}
+	defer payload.Close()
+	parsed, err := ReadPayload(payload)
+	if err != nil {
+		return err
+	}
+	p.Payload = &parsed
+	return nil
+}
+
+// Close releases the zip file.
+func (p *Package) Close() error {
+	return p.file.Close()
+}
+
+// Incremental reports whether the package updates from a specific build.
+func (p *Package) Incremental() bool {
+	if p.Metadata.PreBuild != "" {
+		return true
+	}
+	return p.Payload != nil && !p.Payload.Manifest.Full()
+}
+
+// PayloadReader returns random access to payload.bin. A/B OTAs store it
+// uncompressed so update_engine can stream it; deflated payloads are
+// rejected.
+func (p *Package) PayloadReader() (*io.SectionReader, error) {
+	for _, entry := range p.archive.File {
+		if entry.Name != PayloadPath {
+			continue
+		}
+		if entry.Method != zip.Store {
+			return nil, fmt.Errorf("%s is compressed; cannot read it in place", PayloadPath)
+		}
+		offset, err := entry.DataOffset()
+		if err != nil {
+			return nil, fmt.Errorf("locate %s: %w", PayloadPath, err)
+		}
+		return io.NewSectionReader(p.file, offset, int64(entry.UncompressedSize64)), nil
+	}
+	return nil, fmt.Errorf("no %s in package", PayloadPath)
+}
+
+// Certificate returns the certificate the package was signed with, which
+// ota_from_target_files stores as otacert.
+func (p *Package) Certificate() (*x509.Certificate, error) {
+	file, err := p.archive.Open(OTACertPath)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", OTACertPath, err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", OTACertPath, err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", OTACertPath, err)
+	}
+	return cert, nil
+}
+
+// Check is one verified property.
+type Check struct {
+	Name     string
+	Expected string
+	Actual   string
+}
+
+// OK reports whether the property matched.
+func (c Check) OK() bool {
+	return c.Expected == c.Actual
+}
+
+// VerifyProperties recomputes the sizes and hashes payload_properties.txt
+// records for payload.bin.
+func (p *Package) VerifyProperties() ([]Check, error) {
+	if p.Payload == nil {
+		return nil, fmt.Errorf("no %s in package", PayloadPath)
+	}
+	if p.Properties == nil {
+		return nil, fmt.Errorf("no %s in package", PropertiesPath)
+	}
+	payload, err := p.archive.Open(PayloadPath)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", PayloadPath, err)
+	}
+	defer payload.Close()
+
+	fileHash, metadataHash := sha256.New(), sha256.New()
+	metadataSize := int64(p.Payload.Header.MetadataSize())
+	if _, err := io.CopyN(io.MultiWriter(fileHash, metadataHash), payload, metadataSize); err != nil {
+		return nil, fmt.Errorf("hash %s: %w", PayloadPath, err)
+	}
+	rest, err := io.Copy(fileHash, payload)
+	if err != nil {
+		return nil, fmt.Errorf("hash %s: %w", PayloadPath, err)
+	}
+
+	encode := base64.StdEncoding.EncodeToString
+	actual := map[string]string{
+		"FILE_HASH":     encode(fileHash.Sum(nil)),
+		"FILE_SIZE":     strconv.FormatInt(metadataSize+rest, 10),
+		"METADATA_HASH": encode(metadataHash.Sum(nil)),
+		"METADATA_SIZE": strconv.FormatInt(metadataSize, 10),
+	}
+	var checks []Check
+	for _, name := range []string{"FILE_HASH", "FILE_SIZE", "METADATA_HASH", "METADATA_SIZE"} {
+		if expected, ok := p.Properties[name]; ok {
+			checks = append(checks, Check{Name: name, Expected: expected, Actual: actual[name]})
+		}
+	}
+	return checks, nil
+}
+
+// CheckDevice fails when the package targets a device other than codename.
+// pre-device, which ota_from_target_files takes from ro.product.device,
+// decides; the post-build fingerprint is only a fallback, since ROMs often
+// replace it with the stock firmware's.
+func (p *Package) CheckDevice(codename string) error {
+	if len(p.Metadata.PreDevices) > 0 {
+		if !slices.Contains(p.Metadata.PreDevices, codename) {
+			return fmt.Errorf("package is for %s, not %s", strings.Join(p.Metadata.PreDevices, ", "), codename)
+		}
+		return nil
+	}
+	if device := FingerprintDevice(p.Metadata.PostBuild); device != "" && device != codename {
+		return fmt.Errorf("post-build fingerprint is for %s, not %s", device, codename)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package ota
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// payloadMagic starts every payload.bin.
+const payloadMagic = "CrAU"
+
+// maxManifestSize guards against reading a corrupt size into memory.
+const maxManifestSize = 64 << 20
+
+// fullPayloadMinorVersion marks payloads that carry whole partitions.
+const fullPayloadMinorVersion = 0
+
+// OpType is an InstallOperation type.
+type OpType int
+
+// Install operation types from update_metadata.proto.
+const (
+	OpReplace         OpType = 0
+	OpReplaceBZ       OpType = 1
+	OpMove            OpType = 2
+	OpBSDiff          OpType = 3
+	OpSourceCopy      OpType = 4
+	OpSourceBSDiff    OpType = 5
+	OpZero            OpType = 6
+	OpDiscard         OpType = 7
+	OpReplaceXZ       OpType = 8
+	OpPuffDiff        OpType = 9
+	OpBrotliBSDiff    OpType = 10
+	OpZucchini        OpType = 11
+	OpLZ4DiffBSDiff   OpType = 12
+	OpLZ4DiffPuffDiff OpType = 13
+	OpReplaceZstd     OpType = 14
+)
+
+var opNames = map[OpType]string{
+	OpReplace:         "REPLACE",
+	OpReplaceBZ:       "REPLACE_BZ",
+	OpMove:            "MOVE",
+	OpBSDiff:          "BSDIFF",
+	OpSourceCopy:      "SOURCE_COPY",
+	OpSourceBSDiff:    "SOURCE_BSDIFF",
+	OpZero:            "ZERO",
+	OpDiscard:         "DISCARD",
+	OpReplaceXZ:       "REPLACE_XZ",
+	OpPuffDiff:        "PUFFDIFF",
+	OpBrotliBSDiff:    "BROTLI_BSDIFF",
+	OpZucchini:        "ZUCCHINI",
+	OpLZ4DiffBSDiff:   "LZ4DIFF_BSDIFF",
+	OpLZ4DiffPuffDiff: "LZ4DIFF_PUFFDIFF",
+	OpReplaceZstd:     "REPLACE_ZSTD",
+}
+
+func (t OpType) String() string {
+	if name, ok := opNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP_%d", int(t))
+}
+
+// Extent is a run of blocks.
+type Extent struct {
+	StartBlock uint64
+	NumBlocks  uint64
+}
+
+// Operation is an InstallOperation. DataOffset is relative to the payload
+// data blob.
+type Operation struct {
+	Type       OpType
+	DataOffset uint64
+	DataLength uint64
+	SrcExtents []Extent
+	DstExtents []Extent
+	DataSHA256 []byte
+}
+
+// PartitionInfo is the size and hash of a partition image.
+type PartitionInfo struct {
+	Size uint64
+	Hash []byte
+}
+
+// Partition is a PartitionUpdate.
+type Partition struct {
+	Name       string
+	Old        *PartitionInfo
+	New        PartitionInfo
+	Operations []Operation
+	Version    string
+}
+
+// Manifest is the subset of DeltaArchiveManifest ARKFORGE reads.
+type Manifest struct {
+	BlockSize          uint32
+	MinorVersion       uint32
+	MaxTimestamp       int64
+	PartialUpdate      bool
+	SecurityPatchLevel string
+	Partitions         []Partition
+}
+
+// Full reports whether the payload carries whole partitions rather than
+// deltas against the installed build.
+func (m Manifest) Full() bool {
+	if m.MinorVersion != fullPayloadMinorVersion {
+		return false
+	}
+	for _, partition := range m.Partitions {
+		if partition.Old != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Header is the fixed part of payload.bin.
+type Header struct {
+	Version               uint64
+	ManifestSize          uint64
+	MetadataSignatureSize uint32
+}
+
+// Size is the length of the header on disk.
+func (h Header) Size() uint64 {
+	if h.Version >= 2 {
+		return 24
+	}
+	return 20
+}
+
+// MetadataSize is the header plus manifest, the range METADATA_HASH in
+// payload_properties.txt covers.
+func (h Header) MetadataSize() uint64 {
+	return h.Size() + h.ManifestSize
+}
+
+// DataOffset is where the operation data blob starts.
+func (h Header) DataOffset() uint64 {
+	return h.MetadataSize() + uint64(h.MetadataSignatureSize)
+}
+
+// Payload is a parsed payload.bin header and manifest.
+type Payload struct {
+	Header   Header
+	Manifest Manifest
+}
+
+// ReadPayload parses the header and manifest at the start of r.
+func ReadPayload(r io.Reader) (Payload, error) {
+	var fixed [24]byte
+	if _, err := io.ReadFull(r, fixed[:20]); err != nil {
+		return Payload{}, fmt.Errorf("read payload header: %w", err)
+	}
+	if string(fixed[:4]) != payloadMagic {
+		return Payload{}, errors.New("not a payload.bin (bad magic)")
+	}
+	header := Header{
+		Version:      binary.BigEndian.Uint64(fixed[4:12]),
+		ManifestSize: binary.BigEndian.Uint64(fixed[12:20]),
+	}
+	if header.Version != 1 && header.Version != 2 {
+		return Payload{}, fmt.Errorf("unsupported payload version %d", header.Version)
+	}
+	if header.Version >= 2 {
+		if _, err := io.ReadFull(r, fixed[20:24]); err != nil {
+			return Payload{}, fmt.Errorf("read payload header: %w", err)
+		}
+		header.MetadataSignatureSize = binary.BigEndian.Uint32(fixed[20:24])
+	}
+	if header.ManifestSize > maxManifestSize {
+		return Payload{}, fmt.Errorf("payload manifest too large (%d bytes)", header.ManifestSize)
+	}
+
+	buf := make([]byte, header.ManifestSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return Payload{}, fmt.Errorf("read payload manifest: %w", err)
+	}
+	manifest, err := parseManifest(buf)
+	if err != nil {
+		return Payload{}, fmt.Errorf("decode payload manifest: %w", err)
+	}
+	return Payload{Header: header, Manifest: manifest}, nil
+}
+
+func parseManifest(buf []byte) (Manifest, error) {
+	manifest := Manifest{BlockSize: 4096}
+	err := walkProto(buf, func(f protoField) error {
+		switch f.number {
+		case 3:
+			manifest.BlockSize = uint32(f.num)
+		case 12:
+			manifest.MinorVersion = uint32(f.num)
+		case 13:
+			partition, err := parsePartition(f.data)
+			if err != nil {
+				return err
+			}
+			manifest.Partitions = append(manifest.Partitions, partition)
+		case 14:
+			manifest.MaxTimestamp = int64(f.num)
+		case 16:
+			manifest.PartialUpdate = f.num != 0
+		case 18:
+			manifest.SecurityPatchLevel = string(f.data)
+		}
+		return nil
+	})
+	return manifest, err
+}
+
+func parsePartition(buf []byte) (Partition, error) {
+	var partition Partition
+	err := walkProto(buf, func(f protoField) error {
+		switch f.number {
+		case 1:
+			partition.Name = string(f.data)
+		case 6:
+			info, err := parsePartitionInfo(f.data)
+			if err != nil {
+				return err
+			}
+			partition.Old = &info
+		case 7:
+			info, err := parsePartitionInfo(f.data)
+			if err != nil {
+				return err
+			}
+			partition.New = info
+		case 8:
+			op, err := parseOperation(f.data)
+			if err != nil {
+				return err
+			}
+			partition.Operations = append(partition.Operations, op)
+		case 17:
+			partition.Version = string(f.data)
+		}
+		return nil
+	})
+	return partition, err
+}
+
+func parsePartitionInfo(buf []byte) (PartitionInfo, error) {
+	var info PartitionInfo
+	err := walkProto(buf, func(f protoField) error {
+		switch f.number {
+		case 1:
+			info.Size = f.num
+		case 2:
+			info.Hash = f.data
+		}
+		return nil
+	})
+	return info, err
+}
+
+func parseOperation(buf []byte) (Operation, error) {
+	var op Operation
+	err := walkProto(buf, func(f protoField) error {
+		switch f.number {
+		case 1:
+			op.Type = OpType(f.num)
+		case 2:
+			op.DataOffset = f.num
+		case 3:
+			op.DataLength = f.num
+		case 4, 6:
+			extent, err := parseExtent(f.data)
+			if err != nil {
+				return err
+			}
+			if f.number == 4 {
+				op.SrcExtents = append(op.SrcExtents, extent)
+			} else {
+				op.DstExtents = append(op.DstExtents, extent)
+			}
+		case 8:
+			op.DataSHA256 = f.data
+		}
+		return nil
+	})
+	return op, err
+}
+
+func parseExtent(buf []byte) (Extent, error) {
+	var extent Extent
+	err := walkProto(buf, func(f protoField) error {
+		switch f.number {
+		case 1:
+			extent.StartBlock = f.num
+		case 2:
+			extent.NumBlocks = f.num
+		}
+		return nil
+	})
+	return extent, err
+}
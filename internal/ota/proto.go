@@ -0,0 +1,72 @@
+package ota
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Protobuf wire types used by update_metadata.proto.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// protoField is one decoded field. Varint and fixed values are in num,
+// length-delimited ones in data.
+type protoField struct {
+	number int
+	wire   int
+	num    uint64
+	data   []byte
+}
+
+// walkProto calls fn for every field of a serialized message. It only
+// understands what update_metadata.proto needs; groups are rejected.
+func walkProto(buf []byte, fn func(protoField) error) error {
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errTruncated
+		}
+		buf = buf[n:]
+		field := protoField{number: int(key >> 3), wire: int(key & 7)}
+		switch field.wire {
+		case wireVarint:
+			field.num, n = binary.Uvarint(buf)
+			if n <= 0 {
+				return errTruncated
+			}
+			buf = buf[n:]
+		case wireFixed64:
+			if len(buf) < 8 {
+				return errTruncated
+			}
+			field.num = binary.LittleEndian.Uint64(buf)
+			buf = buf[8:]
+		case wireFixed32:
+			if len(buf) < 4 {
+				return errTruncated
+			}
+			field.num = uint64(binary.LittleEndian.Uint32(buf))
+			buf = buf[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < size {
+				return errTruncated
+			}
+			field.data = buf[n : n+int(size)]
+			buf = buf[n+int(size):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", field.wire)
+		}
+		if err := fn(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package patches
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+)
+
+// ErrConflict is returned when a patch or pick does not apply cleanly.
+var ErrConflict = errors.New("patch conflict")
+
+// State describes whether a patch is present in its project.
+type State string
+
+const (
+	StateApplied  State = "applied"
+	StatePending  State = "pending"
+	StateConflict State = "conflict"
+	StateMissing  State = "missing"
+)
+
+// Entry reports the state of a single patch file or cherry-pick.
+type Entry struct {
+	Set     string
+	Project string
+	Kind    string
+	Source  string
+	State   State
+	Detail  string
+}
+
+// Options selects the tree the configured patch sets are checked against.
+type Options struct {
+	SourceDir  string
+	Repository string
+	Device     string
+	DryRun     bool
+}
+
+// Status reports the state of every patch configured for the tree without
+// modifying it. Cherry-pick refs are fetched to resolve their commits.
+func Status(ctx context.Context, runner *execx.Runner, cfg *config.Config, opts Options) ([]Entry, error) {
+	return walk(ctx, runner, cfg, opts, false)
+}
+
+// Apply applies pending patches via git am and pending picks via
+// git cherry-pick. Already-applied entries are skipped, so Apply can run
+// before every build. It stops at the first conflict and returns ErrConflict.
+func Apply(ctx context.Context, runner *execx.Runner, cfg *config.Config, opts Options) ([]Entry, error) {
+	return walk(ctx, runner, cfg, opts, true)
+}
+
+func walk(ctx context.Context, runner *execx.Runner, cfg *config.Config, opts Options, apply bool) ([]Entry, error) {
+	if runner == nil {
+		return nil, fmt.Errorf("runner is nil")
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("config is nil")
+	}
+
+	var entries []Entry
+	for _, set := range cfg.PatchSetsFor(opts.Repository, opts.Device) {
+		for _, project := range set.Projects {
+			projectDir := filepath.Join(opts.SourceDir, project.Path)
+			if info, err := os.Stat(projectDir); err != nil || !info.IsDir() {
+				entry := Entry{Set: set.Name, Project: project.Path, Kind: "project", Source: projectDir, State: StateMissing}
+				entries = append(entries, entry)
+				if apply {
+					return entries, fmt.Errorf("project %s not found in %s", project.Path, opts.SourceDir)
+				}
+				continue
+			}
+
+			// Refresh stat info so apply --check does not trip over a stale index.
+			_, _ = git(ctx, runner, projectDir, "update-index", "-q", "--refresh")
+
+			files, err := patchFiles(patchDir(cfg, project.Dir))
+			if err != nil {
+				return entries, err
+			}
+			// Patches in a dir form a series: once one is not in the tree, the
+			// ones after it may depend on it and cannot be checked on their own.
+			unapplied := false
+			for _, file := range files {
+				entry := Entry{Set: set.Name, Project: project.Path, Kind: "patch", Source: file}
+				entry.State, entry.Detail = patchState(ctx, runner, projectDir, file, unapplied)
+				if apply {
+					if err := applyPatch(ctx, runner, cfg, projectDir, &entry, opts.DryRun); err != nil {
+						return append(entries, entry), err
+					}
+				}
+				unapplied = unapplied || entry.State != StateApplied
+				entries = append(entries, entry)
+			}
+
+			for _, pick := range project.Picks {
+				entry, err := pickEntry(ctx, runner, cfg, projectDir, pick, apply, opts.DryRun)
+				entry.Set = set.Name
+				entry.Project = project.Path
+				entries = append(entries, entry)
+				if err != nil && apply {
+					return entries, err
+				}
+			}
+		}
+	}
+	return entries, nil
+}
+
+// Pick fetches a single ref into projectDir and cherry-picks it unless it is
+// already present. Configured picks and Gerrit changes share this path.
+func Pick(ctx context.Context, runner *execx.Runner, cfg *config.Config, projectDir string, pick config.PatchPick, dryRun bool) (Entry, error) {
+	if runner == nil {
+		return Entry{}, fmt.Errorf("runner is nil")
+	}
+	if cfg == nil {
+		return Entry{}, fmt.Errorf("config is nil")
+	}
+	return pickEntry(ctx, runner, cfg, projectDir, pick, true, dryRun)
+}
+
+func pickEntry(ctx context.Context, runner *execx.Runner, cfg *config.Config, projectDir string, pick config.PatchPick, apply, dryRun bool) (Entry, error) {
+	entry := Entry{Project: projectDir, Kind: "pick", Source: fmt.Sprintf("%s %s", pick.Remote, pick.Ref)}
+	commit, err := fetchPick(ctx, runner, projectDir, pick)
+	if err != nil {
+		entry.State = StateMissing
+		entry.Detail = err.Error()
+		return entry, err
+	}
+	entry.State = pickState(ctx, runner, projectDir, commit)
+	entry.Detail = shortSHA(commit)
+	if apply {
+		if err := applyPick(ctx, runner, cfg, projectDir, commit, &entry, dryRun); err != nil {
+			return entry, err
+		}
+	}
+	return entry, nil
+}
+
+// patchDir resolves a relative patch dir against the directory of the
+// config file declaring it, so patches are found whatever the working dir.
+func patchDir(cfg *config.Config, dir string) string {
+	if dir == "" || filepath.IsAbs(dir) || cfg.File == "" {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(cfg.File), dir)
+}
+
+func patchFiles(dir string) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*.patch"))
+	if err != nil {
+		return nil, fmt.Errorf("list patches in %s: %w", dir, err)
+	}
+	if len(matches) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("patch dir %s: %w", dir, err)
+		}
+	}
+	for i, match := range matches {
+		abs, err := filepath.Abs(match)
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", match, err)
+		}
+		matches[i] = abs
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// patchState treats a patch as applied when a commit with its subject and
+// patch-id is in HEAD, which holds however later patches changed the same
+// lines. Otherwise it is pending when it forward-applies cleanly and a
+// conflict when not. After an unapplied patch of the same series the forward
+// check is skipped: the patch is assumed to apply on top of its predecessors.
+func patchState(ctx context.Context, runner *execx.Runner, projectDir, file string, afterUnapplied bool) (State, string) {
+	if patchApplied(ctx, runner, projectDir, file) {
+		return StateApplied, ""
+	}
+	if afterUnapplied {
+		return StatePending, "checked once the patches before it are applied"
+	}
+	if _, err := git(ctx, runner, projectDir, "apply", "--check", "--3way", file); err != nil {
+		return StateConflict, err.Error()
+	}
+	return StatePending, ""
+}
+
+// patchApplied looks up commits carrying the patch subject, as git am
+// writes it, and compares their stable patch-ids with the patch file's.
+func patchApplied(ctx context.Context, runner *execx.Runner, projectDir, file string) bool {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	subject := patchSubject(string(data))
+	want := patchID(ctx, runner, projectDir, string(data))
+	if subject == "" || want == "" {
+		return false
+	}
+	out, err := git(ctx, runner, projectDir, "log", "--format=%H", "--fixed-strings", "--grep="+subject, "-n5", "HEAD")
+	if err != nil {
+		return false
+	}
+	for _, commit := range strings.Fields(out) {
+		diff, err := git(ctx, runner, projectDir, "show", "--format=", "--patch", commit)
+		if err == nil && patchID(ctx, runner, projectDir, diff+"\n") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// patchID returns the stable patch-id of a diff, or "" when it has none.
+func patchID(ctx context.Context, runner *execx.Runner, dir, diff string) string {
+	var out bytes.Buffer
+	cmd := execx.Command{Name: "git", Args: []string{"patch-id", "--stable"}, Dir: dir}
+	if err := runner.Pipe(ctx, cmd, strings.NewReader(diff), &out); err != nil {
+		return ""
+	}
+	fields := strings.Fields(out.String())
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// patchSubject reads the Subject header of a format-patch file, unfolded and
+// without the [PATCH n/m] prefix that git am strips.
+func patchSubject(patch string) string {
+	var subject string
+	found := false
+	for _, line := range strings.Split(patch, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if found {
+			if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+				subject += " " + strings.TrimSpace(line)
+				continue
+			}
+			break
+		}
+		if line == "" {
+			break
+		}
+		if rest, ok := strings.CutPrefix(line, "Subject:"); ok {
+			subject = strings.TrimSpace(rest)
+			found = true
+		}
+	}
+	if strings.HasPrefix(subject, "[") {
+		if end := strings.Index(subject, "]"); end >= 0 {
+			subject = strings.TrimSpace(subject[end+1:])
+		}
+	}
+	return subject
+}
+
+func applyPatch(ctx context.Context, runner *execx.Runner, cfg *config.Config, projectDir string, entry *Entry, dryRun bool) error {
+	switch entry.State {
+	case StateApplied:
+		return nil
+	case StateConflict:
+		return fmt.Errorf("%w: %s in %s: %s", ErrConflict, filepath.Base(entry.Source), entry.Project, entry.Detail)
+	}
+
+	cmd := gitCommand(cfg, projectDir, dryRun, "am", "--3way", "--keep-cr", entry.Source)
+	if err := runner.Run(ctx, cmd); err != nil {
+		_ = runner.Run(ctx, gitCommand(cfg, projectDir, false, "am", "--abort"))
+		entry.State = StateConflict
+		entry.Detail = err.Error()
+		return fmt.Errorf("%w: %s in %s: %v", ErrConflict, filepath.Base(entry.Source), entry.Project, err)
+	}
+	if !dryRun {
+		entry.State = StateApplied
+	}
+	return nil
+}
+
+func fetchPick(ctx context.Context, runner *execx.Runner, projectDir string, pick config.PatchPick) (string, error) {
+	if pick.Remote == "" || pick.Ref == "" {
+		return "", fmt.Errorf("pick requires remote and ref")
+	}
+	if _, err := git(ctx, runner, projectDir, "fetch", pick.Remote, pick.Ref); err != nil {
+		return "", fmt.Errorf("fetch %s %s: %w", pick.Remote, pick.Ref, err)
+	}
+	commit, err := git(ctx, runner, projectDir, "rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", pick.Ref, err)
+	}
+	return commit, nil
+}
+
+// Present reports whether commit, or a cherry-pick of it, is in the HEAD of
+// projectDir. A repo sync that reset the project drops its picks.
+func Present(ctx context.Context, runner *execx.Runner, projectDir, commit string) bool {
+	return pickState(ctx, runner, projectDir, commit) == StateApplied
+}
+
+// pickState relies on the "(cherry picked from commit ...)" trailer written by
+// cherry-pick -x, or on the commit already being merged upstream.
+func pickState(ctx context.Context, runner *execx.Runner, projectDir, commit string) State {
+	if _, err := git(ctx, runner, projectDir, "merge-base", "--is-ancestor", commit, "HEAD"); err == nil {
+		return StateApplied
+	}
+	grep := fmt.Sprintf("--grep=(cherry picked from commit %s)", commit)
+	out, err := git(ctx, runner, projectDir, "log", "--format=%H", "--fixed-strings", grep, "-n1", "HEAD")
+	if err == nil && out != "" {
+		return StateApplied
+	}
+	return StatePending
+}
+
+func applyPick(ctx context.Context, runner *execx.Runner, cfg *config.Config, projectDir, commit string, entry *Entry, dryRun bool) error {
+	if entry.State == StateApplied {
+		return nil
+	}
+
+	cmd := gitCommand(cfg, projectDir, dryRun, "cherry-pick", "-x", commit)
+	if err := runner.Run(ctx, cmd); err != nil {
+		_ = runner.Run(ctx, gitCommand(cfg, projectDir, false, "cherry-pick", "--abort"))
+		entry.State = StateConflict
+		entry.Detail = err.Error()
+		return fmt.Errorf("%w: %s in %s: %v", ErrConflict, entry.Source, entry.Project, err)
+	}
+	if !dryRun {
+		entry.State = StateApplied
+	}
+	return nil
+}
+
+func git(ctx context.Context, runner *execx.Runner, dir string, args ...string) (string, error) {
+	return runner.Output(ctx, execx.Command{Name: "git", Args: args, Dir: dir})
+}
+
+func gitCommand(cfg *config.Config, dir string, dryRun bool, args ...string) execx.Command {
+	return execx.Command{
+		Name:   "git",
+		Args:   args,
+		Dir:    dir,
+		DryRun: dryRun,
+		Env: map[string]string{
+			"ARK_COMMANDER": cfg.Commander,
+		},
+	}
+}
+
+func shortSHA(commit string) string {
+	commit = strings.TrimSpace(commit)
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
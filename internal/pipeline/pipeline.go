@@ -0,0 +1,475 @@
+package pipeline
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/artifacts"
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+)
+
+// Stage types.
+const (
+	StageSync    = "sync"
+	StageBuild   = "build"
+	StageClean   = "clean"
+	StageShell   = "shell"
+	StageSign    = "sign"
+	StageRelease = "release"
+)
+
+// Stage conditions and failure policies.
+const (
+	WhenSuccess = "success"
+	WhenFailure = "failure"
+	WhenAlways  = "always"
+
+	OnFailureStop     = "stop"
+	OnFailureContinue = "continue"
+)
+
+// Stage and run statuses.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// StageRun is the recorded outcome of one stage.
+type StageRun struct {
+	Name      string        `json:"name"`
+	Type      string        `json:"type"`
+	Status    string        `json:"status"`
+	StartedAt time.Time     `json:"startedAt,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Attempts  int           `json:"attempts,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Detail    string        `json:"detail,omitempty"`
+}
+
+// Run is the state of a pipeline run, persisted after every stage so a
+// failed run can be resumed.
+type Run struct {
+	Pipeline   string     `json:"pipeline"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt time.Time  `json:"finishedAt,omitempty"`
+	Status     string     `json:"status"`
+	Stages     []StageRun `json:"stages"`
+}
+
+// Options controls a pipeline run. Resume restarts at the stage that failed
+// in the last run; From restarts at a named stage.
+type Options struct {
+	DryRun bool
+	Resume bool
+	From   string
+}
+
+// StatePath returns the run state file of a pipeline.
+func StatePath(cfg *config.Config, name string) string {
+	return filepath.Join(cfg.Build.Workspace, ".arkforge", "pipelines", name+".json")
+}
+
+// LoadRun returns the last recorded run of a pipeline.
+func LoadRun(cfg *config.Config, name string) (Run, error) {
+	data, err := os.ReadFile(StatePath(cfg, name))
+	if err != nil {
+		return Run{}, err
+	}
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return Run{}, fmt.Errorf("decode pipeline state: %w", err)
+	}
+	return run, nil
+}
+
+func saveRun(cfg *config.Config, run Run) error {
+	path := StatePath(cfg, run.Pipeline)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create pipeline state dir: %w", err)
+	}
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal pipeline state: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write pipeline state: %w", err)
+	}
+	return nil
+}
+
+// Lookup returns a validated pipeline by name.
+func Lookup(cfg *config.Config, name string) (*config.Pipeline, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config is nil")
+	}
+	p := cfg.PipelineByName(name)
+	if p == nil {
+		var names []string
+		for _, candidate := range cfg.Pipelines {
+			names = append(names, candidate.Name)
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unknown pipeline %q (no pipelines configured)", name)
+		}
+		return nil, fmt.Errorf("unknown pipeline %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	if err := Validate(*p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks stage types, conditions and policies before anything runs.
+func Validate(p config.Pipeline) error {
+	if len(p.Stages) == 0 {
+		return fmt.Errorf("pipeline %s has no stages", p.Name)
+	}
+	seen := map[string]bool{}
+	for i, stage := range p.Stages {
+		name := StageName(stage, i)
+		if seen[name] {
+			return fmt.Errorf("pipeline %s: duplicate stage %q", p.Name, name)
+		}
+		seen[name] = true
+
+		switch stage.Type {
+		case StageSync, StageBuild, StageClean, StageSign, StageRelease:
+		case StageShell:
+			if strings.TrimSpace(stage.Run) == "" {
+				return fmt.Errorf("pipeline %s: shell stage %q has no run command", p.Name, name)
+			}
+		default:
+			return fmt.Errorf("pipeline %s: stage %q has unknown type %q (sync, build, clean, shell, sign, release)", p.Name, name, stage.Type)
+		}
+		switch stage.When {
+		case "", WhenSuccess, WhenFailure, WhenAlways:
+		default:
+			return fmt.Errorf("pipeline %s: stage %q has unknown condition %q (success, failure, always)", p.Name, name, stage.When)
+		}
+		switch stage.OnFailure {
+		case "", OnFailureStop, OnFailureContinue:
+		default:
+			return fmt.Errorf("pipeline %s: stage %q has unknown failure policy %q (stop, continue)", p.Name, name, stage.OnFailure)
+		}
+	}
+	return nil
+}
+
+// Describe summarises what a stage does, for plan views.
+func Describe(cfg *config.Config, p config.Pipeline, stage config.PipelineStage) string {
+	devices := strings.Join(stageDevices(cfg, p, stage), ",")
+	var what string
+	switch stage.Type {
+	case StageSync:
+		what = "repo sync " + devices
+		if stage.Force {
+			what += " (force)"
+		}
+	case StageBuild:
+		what = fmt.Sprintf("build %s for %s", cmp.Or(stage.Target, cfg.Build.DefaultType), devices)
+	case StageClean:
+		what = fmt.Sprintf("clean %s for %s", cmp.Or(stage.Mode, string(android.CleanLight)), devices)
+	case StageShell:
+		what = "sh: " + stage.Run
+	case StageSign:
+		what = fmt.Sprintf("sign %s with %s", devices, cmp.Or(cfg.Signing.Keys, "<signing.keys unset>"))
+	case StageRelease:
+		what = "release artifacts -> " + filepath.Join(cfg.Release.Dir, "<version>")
+		if stage.Output != "" {
+			what += ", manifest -> " + stage.Output
+		}
+	}
+
+	what += fmt.Sprintf(" [when %s, on failure %s", cmp.Or(stage.When, WhenSuccess), cmp.Or(stage.OnFailure, OnFailureStop))
+	if stage.Retries > 0 {
+		what += fmt.Sprintf(", %d retries", stage.Retries)
+	}
+	return what + "]"
+}
+
+// Execute runs a pipeline stage by stage. A failed stage with the stop
+// policy skips the remaining success-conditioned stages; failure and always
+// stages still run. report, if set, is called as each stage starts and ends.
+func Execute(ctx context.Context, runner *execx.Runner, cfg *config.Config, name string, opts Options, report func(StageRun)) (Run, error) {
+	if runner == nil {
+		return Run{}, fmt.Errorf("runner is nil")
+	}
+	p, err := Lookup(cfg, name)
+	if err != nil {
+		return Run{}, err
+	}
+
+	run := Run{Pipeline: p.Name, StartedAt: time.Now().UTC(), Status: StatusRunning}
+	for i, stage := range p.Stages {
+		run.Stages = append(run.Stages, StageRun{Name: StageName(stage, i), Type: stage.Type, Status: StatusPending})
+	}
+	if opts.From != "" {
+		opts.Resume = false
+	}
+	start, previous, err := startIndex(cfg, *p, run, opts)
+	if err != nil {
+		return Run{}, err
+	}
+	if opts.Resume {
+		copy(run.Stages[:start], previous.Stages[:start])
+	}
+	for i := 0; i < start && !opts.Resume; i++ {
+		run.Stages[i].Status = StatusSkipped
+		run.Stages[i].Detail = "before --from " + opts.From
+	}
+
+	save := func() {
+		if !opts.DryRun {
+			_ = saveRun(cfg, run)
+		}
+	}
+	notify := func(stage StageRun) {
+		if report != nil {
+			report(stage)
+		}
+	}
+
+	halted := false
+	var failedStage string
+	for i := start; i < len(p.Stages); i++ {
+		stage := p.Stages[i]
+		state := &run.Stages[i]
+
+		if !shouldRun(stage.When, halted) {
+			state.Status = StatusSkipped
+			state.Detail = "condition " + cmp.Or(stage.When, WhenSuccess) + " not met"
+			notify(*state)
+			save()
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			state.Status = StatusFailed
+			state.Error = err.Error()
+			halted = true
+			failedStage = state.Name
+			break
+		}
+
+		state.Status = StatusRunning
+		state.StartedAt = time.Now().UTC()
+		notify(*state)
+		save()
+
+		var stageErr error
+		for attempt := 0; attempt <= stage.Retries; attempt++ {
+			state.Attempts = attempt + 1
+			state.Detail, stageErr = runStage(ctx, runner, cfg, *p, stage, opts.DryRun)
+			if stageErr == nil || ctx.Err() != nil {
+				break
+			}
+		}
+		state.Duration = time.Since(state.StartedAt)
+		if stageErr != nil {
+			state.Status = StatusFailed
+			state.Error = stageErr.Error()
+			if stage.OnFailure != OnFailureContinue && !halted {
+				halted = true
+				failedStage = state.Name
+			}
+		} else {
+			state.Status = StatusSuccess
+			state.Error = ""
+		}
+		notify(*state)
+		save()
+	}
+
+	run.FinishedAt = time.Now().UTC()
+	run.Status = StatusSuccess
+	if halted {
+		run.Status = StatusFailed
+	}
+	save()
+	if halted {
+		return run, fmt.Errorf("pipeline %s failed at stage %s", p.Name, failedStage)
+	}
+	return run, nil
+}
+
+// startIndex picks the first stage to run: the --from stage, or on resume
+// the stage that stopped the previous run.
+func startIndex(cfg *config.Config, p config.Pipeline, run Run, opts Options) (int, Run, error) {
+	if opts.From != "" {
+		for i, stage := range run.Stages {
+			if stage.Name == opts.From {
+				return i, Run{}, nil
+			}
+		}
+		return 0, Run{}, fmt.Errorf("pipeline %s has no stage %q", p.Name, opts.From)
+	}
+	if !opts.Resume {
+		return 0, Run{}, nil
+	}
+
+	previous, err := LoadRun(cfg, p.Name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, Run{}, fmt.Errorf("pipeline %s has no previous run to resume", p.Name)
+		}
+		return 0, Run{}, err
+	}
+	if previous.Status == StatusSuccess {
+		return 0, Run{}, fmt.Errorf("last run of pipeline %s succeeded; nothing to resume", p.Name)
+	}
+	if len(previous.Stages) != len(run.Stages) {
+		return 0, Run{}, fmt.Errorf("pipeline %s changed since its last run; use --from", p.Name)
+	}
+	for i, stage := range previous.Stages {
+		if stage.Name != run.Stages[i].Name {
+			return 0, Run{}, fmt.Errorf("pipeline %s changed since its last run; use --from", p.Name)
+		}
+	}
+	for i, stage := range previous.Stages {
+		stopped := stage.Status == StatusFailed && p.Stages[i].OnFailure != OnFailureContinue
+		if stopped || stage.Status == StatusRunning || stage.Status == StatusPending {
+			return i, previous, nil
+		}
+	}
+	return 0, Run{}, fmt.Errorf("pipeline %s has no failed stage to resume", p.Name)
+}
+
+func shouldRun(when string, halted bool) bool {
+	switch when {
+	case WhenAlways:
+		return true
+	case WhenFailure:
+		return halted
+	}
+	return !halted
+}
+
+func runStage(ctx context.Context, runner *execx.Runner, cfg *config.Config, p config.Pipeline, stage config.PipelineStage, dryRun bool) (string, error) {
+	devices := stageDevices(cfg, p, stage)
+	if len(devices) == 0 && stage.Type != StageShell && stage.Type != StageRelease {
+		return "", fmt.Errorf("stage has no devices and the fleet is empty")
+	}
+
+	switch stage.Type {
+	case StageSync:
+		for _, device := range devices {
+			tree, err := android.DetectTree(cfg, device, "")
+			if err != nil {
+				return "", err
+			}
+			opts := android.SyncOptions{Dir: tree.Dir, Force: stage.Force, DryRun: dryRun}
+			if err := android.RepoSync(ctx, runner, cfg, opts); err != nil {
+				return "", fmt.Errorf("sync %s: %w", device, err)
+			}
+		}
+		return fmt.Sprintf("synced %d tree(s)", len(devices)), nil
+
+	case StageBuild:
+		jobs, err := android.PlanFleet(cfg, devices, []string{cmp.Or(stage.Target, cfg.Build.DefaultType)})
+		if err != nil {
+			return "", err
+		}
+		results := android.RunFleet(ctx, runner, cfg, jobs, android.BuildOptions{DryRun: dryRun}, p.Parallel, nil)
+		var failures []string
+		for _, result := range results {
+			if result.Err != nil {
+				failures = append(failures, fmt.Sprintf("%s/%s: %v", result.Device, result.Target, result.Err))
+			}
+		}
+		if len(failures) > 0 {
+			return "", errors.New(strings.Join(failures, "; "))
+		}
+		return fmt.Sprintf("built %d job(s)", len(results)), nil
+
+	case StageClean:
+		mode := android.CleanMode(cmp.Or(stage.Mode, string(android.CleanLight)))
+		for _, device := range devices {
+			tree, err := android.DetectTree(cfg, device, "")
+			if err != nil {
+				return "", err
+			}
+			plan, err := android.PlanClean(cfg, tree, mode)
+			if err != nil {
+				return "", err
+			}
+			if err := android.Clean(ctx, runner, cfg, plan, dryRun); err != nil {
+				return "", fmt.Errorf("clean %s: %w", device, err)
+			}
+		}
+		return fmt.Sprintf("cleaned %d tree(s)", len(devices)), nil
+
+	case StageShell:
+		cmd := execx.Command{
+			Name:    "bash",
+			Args:    []string{"-c", stage.Run},
+			Dir:     cfg.Build.Workspace,
+			DryRun:  dryRun,
+			Timeout: android.CommandTimeout(cfg),
+			Env: map[string]string{
+				"ARK_COMMANDER": cfg.Commander,
+				"ARK_PIPELINE":  p.Name,
+				"ARK_DEVICES":   strings.Join(devices, " "),
+				"ARK_WORKSPACE": cfg.Build.Workspace,
+			},
+		}
+		return "", runner.Run(ctx, cmd)
+
+	case StageSign:
+		for _, device := range devices {
+			result, err := android.Sign(ctx, runner, cfg, android.SignOptions{Device: device, DryRun: dryRun})
+			if err != nil {
+				return "", fmt.Errorf("sign %s: %w", device, err)
+			}
+			if len(devices) == 1 {
+				return "signed " + filepath.Base(result.Package), nil
+			}
+		}
+		return fmt.Sprintf("signed %d tree(s)", len(devices)), nil
+
+	case StageRelease:
+		if dryRun {
+			return "would collect artifacts into " + cfg.Release.Dir, nil
+		}
+		manifest, err := artifacts.Release(ctx, runner, cfg, artifacts.ReleaseOptions{})
+		if err != nil {
+			return "", err
+		}
+		output := cmp.Or(stage.Output, artifacts.ManifestPath(manifest))
+		if err := artifacts.Write(output, manifest); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("collected %d artifacts, wrote %s", len(manifest.Artifacts), output), nil
+	}
+	return "", fmt.Errorf("unknown stage type %q", stage.Type)
+}
+
+// stageDevices returns the stage's devices, else the pipeline's, else the
+// fleet primary.
+func stageDevices(cfg *config.Config, p config.Pipeline, stage config.PipelineStage) []string {
+	if len(stage.Devices) > 0 {
+		return stage.Devices
+	}
+	if len(p.Devices) > 0 {
+		return p.Devices
+	}
+	if len(cfg.Fleet) > 0 {
+		return []string{cfg.Fleet[0].Codename}
+	}
+	return nil
+}
+
+// StageName returns the stage's name, defaulting to "<n>-<type>".
+func StageName(stage config.PipelineStage, index int) string {
+	return cmp.Or(stage.Name, fmt.Sprintf("%d-%s", index+1, stage.Type))
+}
@@ -32,7 +32,7 @@ func Run(ctx context.Context, cfg *config.Config, logger zerolog.Logger) error {
 		commandCheck("Java", "java", []string{"-version"}, false),
 		commandCheck("Repo", "repo", []string{"--version"}, false),
 		commandCheck("Git", "git", []string{"--version"}, false),
-		commandCheck("ccache", "ccache", []string{"--version"}, true),
+		commandCheck("ccache", "ccache", []string{"--version"}, !cfg.CCache.Enabled),
 		workspaceCheck(),
 		ulimitCheck(),
 	}
@@ -0,0 +1,437 @@
+// Package s3 is a minimal client for S3-compatible object storage such as
+// MinIO: path-style addressing, AWS Signature Version 4 and the multipart
+// upload calls needed to publish large files.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/secret"
+)
+
+// DefaultRegion is what MinIO and most S3-compatible servers accept when no
+// region is configured.
+const DefaultRegion = "us-east-1"
+
+// MetaPrefix marks user metadata headers.
+const MetaPrefix = "x-amz-meta-"
+
+// ErrNotFound is returned by Head for missing objects.
+var ErrNotFound = errors.New("object not found")
+
+// Client signs requests for one endpoint. Buckets are addressed path-style
+// (<endpoint>/<bucket>/<key>).
+type Client struct {
+	Endpoint  *url.URL
+	Region    string
+	AccessKey secret.Secret
+	SecretKey secret.Secret
+	HTTP      *http.Client
+}
+
+// NewClient returns a client for endpoint, e.g. https://minio.example.org.
+func NewClient(endpoint, region string, accessKey, secretKey secret.Secret) (*Client, error) {
+	parsed, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, fmt.Errorf("endpoint %q is not an http(s) URL", endpoint)
+	}
+	if region == "" {
+		region = DefaultRegion
+	}
+	return &Client{
+		Endpoint:  parsed,
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		// Parts of multi-GB uploads take a while; requests are bounded by
+		// their context and the server's response time instead.
+		HTTP: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 5 * time.Minute,
+			TLSHandshakeTimeout:   30 * time.Second,
+		}},
+	}, nil
+}
+
+// Error is an S3 error response.
+type Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 returned %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("s3 returned %d: %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Object describes a stored object.
+type Object struct {
+	Size     int64
+	ETag     string
+	Metadata map[string]string
+}
+
+// Head returns an object's size, ETag and user metadata (keys without
+// x-amz-meta-, lowercase).
+func (c *Client) Head(ctx context.Context, bucket, key string) (Object, error) {
+	resp, err := c.do(ctx, http.MethodHead, bucket, key, nil, nil, nil, 0)
+	if err != nil {
+		var s3err *Error
+		if errors.As(err, &s3err) && s3err.Status == http.StatusNotFound {
+			return Object{}, ErrNotFound
+		}
+		return Object{}, err
+	}
+	resp.Body.Close()
+	object := Object{Size: resp.ContentLength, ETag: strings.Trim(resp.Header.Get("ETag"), `"`), Metadata: map[string]string{}}
+	for name, values := range resp.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, MetaPrefix) && len(values) > 0 {
+			object.Metadata[strings.TrimPrefix(lower, MetaPrefix)] = values[0]
+		}
+	}
+	return object, nil
+}
+
+// Put uploads body as a single object. Headers carry Content-Type and
+// x-amz-meta-* metadata.
+func (c *Client) Put(ctx context.Context, bucket, key string, body *io.SectionReader, headers map[string]string) error {
+	resp, err := c.do(ctx, http.MethodPut, bucket, key, nil, headers, body, body.Size())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Upload is an unfinished multipart upload.
+type Upload struct {
+	Key       string    `xml:"Key"`
+	UploadID  string    `xml:"UploadId"`
+	Initiated time.Time `xml:"Initiated"`
+}
+
+// Part is an uploaded part of a multipart upload.
+type Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+	Size   int64  `xml:"Size"`
+}
+
+// CreateMultipartUpload starts a multipart upload; headers are stored with
+// the final object.
+func (c *Client) CreateMultipartUpload(ctx context.Context, bucket, key string, headers map[string]string) (string, error) {
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := c.xml(ctx, http.MethodPost, bucket, key, url.Values{"uploads": {""}}, headers, nil, &result); err != nil {
+		return "", fmt.Errorf("create multipart upload: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", errors.New("create multipart upload: no upload id in response")
+	}
+	return result.UploadID, nil
+}
+
+// ListMultipartUploads returns the unfinished uploads of key, oldest first.
+func (c *Client) ListMultipartUploads(ctx context.Context, bucket, key string) ([]Upload, error) {
+	query := url.Values{"uploads": {""}, "prefix": {key}}
+	var uploads []Upload
+	for {
+		var result struct {
+			Uploads            []Upload `xml:"Upload"`
+			IsTruncated        bool     `xml:"IsTruncated"`
+			NextKeyMarker      string   `xml:"NextKeyMarker"`
+			NextUploadIDMarker string   `xml:"NextUploadIdMarker"`
+		}
+		if err := c.xml(ctx, http.MethodGet, bucket, "", query, nil, nil, &result); err != nil {
+			return nil, fmt.Errorf("list multipart uploads: %w", err)
+		}
+		for _, upload := range result.Uploads {
+			if upload.Key == key {
+				uploads = append(uploads, upload)
+			}
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("key-marker", result.NextKeyMarker)
+		query.Set("upload-id-marker", result.NextUploadIDMarker)
+	}
+	sort.SliceStable(uploads, func(i, j int) bool { return uploads[i].Initiated.Before(uploads[j].Initiated) })
+	return uploads, nil
+}
+
+// ListParts returns the parts uploaded so far.
+func (c *Client) ListParts(ctx context.Context, bucket, key, uploadID string) ([]Part, error) {
+	query := url.Values{"uploadId": {uploadID}}
+	var parts []Part
+	for {
+		var result struct {
+			Parts                []Part `xml:"Part"`
+			IsTruncated          bool   `xml:"IsTruncated"`
+			NextPartNumberMarker string `xml:"NextPartNumberMarker"`
+		}
+		if err := c.xml(ctx, http.MethodGet, bucket, key, query, nil, nil, &result); err != nil {
+			return nil, fmt.Errorf("list parts: %w", err)
+		}
+		parts = append(parts, result.Parts...)
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("part-number-marker", result.NextPartNumberMarker)
+	}
+	for i := range parts {
+		parts[i].ETag = strings.Trim(parts[i].ETag, `"`)
+	}
+	return parts, nil
+}
+
+// UploadPart stores one part (numbered from 1) and returns its ETag.
+func (c *Client) UploadPart(ctx context.Context, bucket, key, uploadID string, number int, body *io.SectionReader) (string, error) {
+	query := url.Values{"uploadId": {uploadID}, "partNumber": {strconv.Itoa(number)}}
+	resp, err := c.do(ctx, http.MethodPut, bucket, key, query, nil, body, body.Size())
+	if err != nil {
+		return "", fmt.Errorf("upload part %d: %w", number, err)
+	}
+	resp.Body.Close()
+	return strings.Trim(resp.Header.Get("ETag"), `"`), nil
+}
+
+// CompleteMultipartUpload assembles the parts, in order, into the object.
+func (c *Client) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Part) error {
+	type completePart struct {
+		Number int    `xml:"PartNumber"`
+		ETag   string `xml:"ETag"`
+	}
+	request := struct {
+		XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+		Parts   []completePart `xml:"Part"`
+	}{}
+	for _, part := range parts {
+		request.Parts = append(request.Parts, completePart{Number: part.Number, ETag: `"` + part.ETag + `"`})
+	}
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("encode parts: %w", err)
+	}
+	// S3 may report a failed completion with 200 and an Error document.
+	var result struct {
+		XMLName xml.Name
+		Error
+	}
+	reader := io.NewSectionReader(bytes.NewReader(body), 0, int64(len(body)))
+	if err := c.xml(ctx, http.MethodPost, bucket, key, url.Values{"uploadId": {uploadID}}, nil, reader, &result); err != nil {
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+	if result.XMLName.Local == "Error" {
+		result.Error.Status = http.StatusOK
+		return fmt.Errorf("complete multipart upload: %w", &result.Error)
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards an upload and its parts.
+func (c *Client) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
+	resp, err := c.do(ctx, http.MethodDelete, bucket, key, url.Values{"uploadId": {uploadID}}, nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("abort multipart upload: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// xml performs a request and decodes its XML response into out.
+func (c *Client) xml(ctx context.Context, method, bucket, key string, query url.Values, headers map[string]string, body *io.SectionReader, out any) error {
+	var size int64
+	if body != nil {
+		size = body.Size()
+	}
+	resp, err := c.do(ctx, method, bucket, key, query, headers, body, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// do signs and sends a request. Responses other than 2xx become *Error.
+func (c *Client) do(ctx context.Context, method, bucket, key string, query url.Values, headers map[string]string, body *io.SectionReader, size int64) (*http.Response, error) {
+	payloadHash, err := hashBody(body)
+	if err != nil {
+		return nil, err
+	}
+	target := *c.Endpoint
+	target.Path = c.Endpoint.Path + "/" + bucket
+	if key != "" {
+		target.Path += "/" + key
+	}
+	target.RawPath = escapePath(target.Path)
+	target.RawQuery = canonicalQuery(query)
+
+	// A fresh reader per request lets callers retry with the same body.
+	var reader io.Reader
+	if body != nil {
+		reader = io.NewSectionReader(body, 0, body.Size())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	c.sign(req, payloadHash, time.Now())
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	s3err := &Error{Status: resp.StatusCode}
+	if data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10)); err == nil && len(data) > 0 {
+		_ = xml.Unmarshal(data, s3err)
+	}
+	return nil, s3err
+}
+
+// hashBody returns the hex SHA-256 SigV4 signs, so the server rejects a
+// body corrupted in transit.
+func hashBody(body *io.SectionReader) (string, error) {
+	hash := sha256.New()
+	if body != nil {
+		if _, err := io.Copy(hash, io.NewSectionReader(body, 0, body.Size())); err != nil {
+			return "", fmt.Errorf("hash request body: %w", err)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sign adds AWS Signature Version 4 headers for time now to req.
+func (c *Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "content-md5" || strings.HasPrefix(lower, "x-amz-") {
+			signed[lower] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey.Value()), date)
+	for _, part := range []string{c.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey.Value(), scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath percent-encodes every byte SigV4 does not leave alone, keeping
+// the slashes between segments.
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery sorts and encodes the query as SigV4 requires; the
+// result is also sent as is.
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, escape(key)+"="+escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// escape is RFC 3986 percent-encoding of everything but unreserved
+// characters.
+func escape(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || b == '-' || b == '.' || b == '_' || b == '~' {
+			out.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&out, "%%%02X", b)
+	}
+	return out.String()
+}
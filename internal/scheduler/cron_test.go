@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		expr, from, want string
+	}{
+		{"*/15 * * * *", "2024-06-07 10:07", "2024-06-07 10:15"},
+		{"*/15 * * * *", "2024-06-07 10:15", "2024-06-07 10:30"},
+		{"30 2 * * 1-5", "2024-06-07 03:00", "2024-06-10 02:30"},
+		{"@daily", "2024-06-07 23:59", "2024-06-08 00:00"},
+		{"@hourly", "2024-12-31 23:30", "2025-01-01 00:00"},
+		{"0 0 * * 7", "2024-06-01 12:00", "2024-06-02 00:00"},
+		// Both day fields restricted: either one matches.
+		{"0 0 13 * 5", "2024-06-01 00:00", "2024-06-07 00:00"},
+		{"0 0 13 * 5", "2024-06-12 00:00", "2024-06-13 00:00"},
+		{"0 12 29 2 *", "2024-03-01 00:00", "2028-02-29 12:00"},
+		{"5,10 8-9/1 * 1,7 *", "2024-01-31 09:10", "2024-07-01 08:05"},
+	}
+	for _, tt := range tests {
+		cron, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+		}
+		if got := cron.Next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%q.Next(%s) = %s, want %s", tt.expr, tt.from, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+}
+
+func TestCronNextNever(t *testing.T) {
+	cron, err := ParseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := cron.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Fatalf("February 30th fired at %s", next)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded", expr)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/lock"
+	"github.com/koobie777/ark-android-forge/internal/pipeline"
+)
+
+// idleWait bounds how long the daemon sleeps between ticks, so it notices
+// clock jumps (suspend, NTP) promptly.
+const idleWait = time.Minute
+
+// Clock abstracts time so schedules can be driven by a fake clock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Job is a queued schedule activation.
+type Job struct {
+	Schedule   string    `json:"schedule"`
+	Due        time.Time `json:"due"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+}
+
+// Success records the upstream fingerprint of a schedule's last good run.
+type Success struct {
+	At       time.Time `json:"at"`
+	Upstream string    `json:"upstream,omitempty"`
+}
+
+// State is persisted after every change so a restart keeps pending jobs and
+// enqueues activations missed while the daemon was down.
+type State struct {
+	LastTick time.Time          `json:"lastTick"`
+	Queue    []Job              `json:"queue"`
+	Success  map[string]Success `json:"success,omitempty"`
+}
+
+// Daemon runs configured schedules. Clock, Upstream and Execute default to
+// the real implementations and can be replaced in tests.
+type Daemon struct {
+	Config *config.Config
+	Runner *execx.Runner
+	Logger zerolog.Logger
+	DryRun bool
+
+	Clock Clock
+	// Upstream fingerprints the upstream state of a tree.
+	Upstream func(ctx context.Context, tree android.Tree) (string, error)
+	// Execute runs a schedule's pipeline or build.
+	Execute func(ctx context.Context, schedule config.Schedule) error
+}
+
+// New returns a daemon using the real clock, repo and build entry points.
+func New(cfg *config.Config, runner *execx.Runner, logger zerolog.Logger) *Daemon {
+	d := &Daemon{Config: cfg, Runner: runner, Logger: logger, Clock: realClock{}}
+	d.Upstream = d.repoUpstream
+	d.Execute = d.runSchedule
+	return d
+}
+
+// StatePath returns the daemon state file.
+func StatePath(cfg *config.Config) string {
+	return filepath.Join(cfg.Build.Workspace, ".arkforge", "daemon", "state.json")
+}
+
+// LoadState reads the persisted daemon state.
+func LoadState(cfg *config.Config) (State, error) {
+	state := State{Success: map[string]Success{}}
+	data, err := os.ReadFile(StatePath(cfg))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
+		}
+		return state, fmt.Errorf("read daemon state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("decode daemon state: %w", err)
+	}
+	if state.Success == nil {
+		state.Success = map[string]Success{}
+	}
+	return state, nil
+}
+
+func saveState(cfg *config.Config, state State) error {
+	path := StatePath(cfg)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create daemon state dir: %w", err)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal daemon state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write daemon state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write daemon state: %w", err)
+	}
+	return nil
+}
+
+// Validate parses every schedule and checks what it triggers.
+func Validate(cfg *config.Config) (map[string]Cron, error) {
+	crons := map[string]Cron{}
+	for _, schedule := range cfg.Schedules {
+		if schedule.Name == "" {
+			return nil, fmt.Errorf("schedule without name")
+		}
+		if _, dup := crons[schedule.Name]; dup {
+			return nil, fmt.Errorf("duplicate schedule %q", schedule.Name)
+		}
+		cron, err := ParseCron(schedule.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", schedule.Name, err)
+		}
+		if schedule.Pipeline != "" {
+			if _, err := pipeline.Lookup(cfg, schedule.Pipeline); err != nil {
+				return nil, fmt.Errorf("schedule %s: %w", schedule.Name, err)
+			}
+		}
+		crons[schedule.Name] = cron
+	}
+	return crons, nil
+}
+
+// Run ticks until ctx is cancelled, sleeping until the next activation.
+func (d *Daemon) Run(ctx context.Context) error {
+	crons, err := Validate(d.Config)
+	if err != nil {
+		return err
+	}
+	if len(crons) == 0 {
+		return fmt.Errorf("no schedules configured")
+	}
+	for {
+		if err := d.Tick(ctx); err != nil {
+			d.Logger.Error().Err(err).Msg("daemon: tick failed")
+		}
+
+		wait := idleWait
+		now := d.Clock.Now()
+		for _, cron := range crons {
+			if next := cron.Next(now); !next.IsZero() && next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-d.Clock.After(wait):
+		}
+	}
+}
+
+// LockPath is held by a daemon for a whole tick, so two daemons of one
+// workspace never interleave updates of its state.
+func LockPath(cfg *config.Config) string {
+	return filepath.Join(filepath.Dir(StatePath(cfg)), "daemon.lock")
+}
+
+// Tick enqueues every schedule that fired since the last tick, then drains
+// the queue while holding the host build lock. It holds the daemon lock
+// throughout, so queue and last tick are read and written by one daemon at a
+// time. A job stays queued until it has run, so a crash or restart retries it.
+func (d *Daemon) Tick(ctx context.Context) error {
+	crons, err := Validate(d.Config)
+	if err != nil {
+		return err
+	}
+	daemonLock, err := lock.TryAcquire(LockPath(d.Config))
+	if err != nil {
+		// The last tick is left alone, so a later tick catches up.
+		d.Logger.Warn().Err(err).Msg("daemon: another daemon is ticking this workspace, skipping")
+		return nil
+	}
+	defer daemonLock.Release()
+
+	state, err := LoadState(d.Config)
+	if err != nil {
+		return err
+	}
+
+	now := d.Clock.Now()
+	since := state.LastTick
+	if since.IsZero() {
+		since = now
+	}
+	for _, schedule := range d.Config.Schedules {
+		due := crons[schedule.Name].Next(since)
+		if due.IsZero() || due.After(now) || queued(state.Queue, schedule.Name) {
+			continue
+		}
+		d.Logger.Info().Str("schedule", schedule.Name).Time("due", due).Msg("daemon: job queued")
+		state.Queue = append(state.Queue, Job{Schedule: schedule.Name, Due: due, EnqueuedAt: now})
+	}
+	state.LastTick = now
+	if err := saveState(d.Config, state); err != nil {
+		return err
+	}
+	if len(state.Queue) == 0 {
+		return nil
+	}
+
+	releaseHost, err := android.AcquireHostLock()
+	if err != nil {
+		d.Logger.Warn().Err(err).Int("queued", len(state.Queue)).Msg("daemon: another build is running, jobs stay queued")
+		return nil
+	}
+	defer releaseHost()
+
+	for len(state.Queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil
+		}
+		d.runJob(ctx, state.Queue[0], &state)
+		if ctx.Err() != nil {
+			// Interrupted jobs stay queued and rerun after a restart.
+			return nil
+		}
+		state.Queue = state.Queue[1:]
+		if err := saveState(d.Config, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Daemon) runJob(ctx context.Context, job Job, state *State) {
+	logger := d.Logger.With().Str("schedule", job.Schedule).Logger()
+	schedule := d.Config.ScheduleByName(job.Schedule)
+	if schedule == nil {
+		logger.Warn().Msg("daemon: schedule removed from config, dropping job")
+		return
+	}
+
+	var upstream string
+	if schedule.SkipUnchanged {
+		fingerprint, err := d.fingerprint(ctx, *schedule)
+		if err != nil {
+			logger.Warn().Err(err).Msg("daemon: upstream check failed, building anyway")
+		} else if last, ok := state.Success[schedule.Name]; ok && last.Upstream == fingerprint {
+			logger.Info().Time("lastSuccess", last.At).Msg("daemon: no upstream changes, skipping")
+			return
+		}
+		upstream = fingerprint
+	}
+
+	logger.Info().Msg("daemon: job started")
+	start := d.Clock.Now()
+	if err := d.Execute(ctx, *schedule); err != nil {
+		logger.Error().Err(err).Dur("duration", d.Clock.Now().Sub(start)).Msg("daemon: job failed")
+		return
+	}
+	logger.Info().Dur("duration", d.Clock.Now().Sub(start)).Msg("daemon: job finished")
+	if !d.DryRun {
+		state.Success[schedule.Name] = Success{At: d.Clock.Now().UTC(), Upstream: upstream}
+	}
+}
+
+// fingerprint combines the upstream state of every tree the schedule builds.
+func (d *Daemon) fingerprint(ctx context.Context, schedule config.Schedule) (string, error) {
+	var parts []string
+	for _, device := range d.Devices(schedule) {
+		tree, err := android.DetectTree(d.Config, device, "")
+		if err != nil {
+			return "", err
+		}
+		upstream, err := d.Upstream(ctx, tree)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", device, err)
+		}
+		parts = append(parts, device+"="+upstream)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// Devices returns the devices a schedule builds: its own, else its
+// pipeline's, else the fleet primary.
+func (d *Daemon) Devices(schedule config.Schedule) []string {
+	if len(schedule.Devices) > 0 {
+		return schedule.Devices
+	}
+	if p := d.Config.PipelineByName(schedule.Pipeline); p != nil && len(p.Devices) > 0 {
+		return p.Devices
+	}
+	if len(d.Config.Fleet) > 0 {
+		return []string{d.Config.Fleet[0].Codename}
+	}
+	return nil
+}
+
+// repoUpstream fetches without touching the work tree (repo sync -n) and
+// hashes the remote revision of every project.
+func (d *Daemon) repoUpstream(ctx context.Context, tree android.Tree) (string, error) {
+	fetch := execx.Command{
+		Name: "repo",
+		Args: []string{"sync", "-n", "--current-branch", "--no-tags", fmt.Sprintf("--jobs=%d", d.Config.Jobs)},
+		Dir:  tree.Dir,
+	}
+	if _, err := d.Runner.Output(ctx, fetch); err != nil {
+		return "", fmt.Errorf("fetch upstream: %w", err)
+	}
+	revs := execx.Command{
+		Name: "repo",
+		Args: []string{"forall", "-c", `echo "$REPO_PROJECT $(git rev-parse -q --verify "$REPO_REMOTE/${REPO_RREV#refs/heads/}^{commit}" 2>/dev/null || echo "$REPO_RREV")"`},
+		Dir:  tree.Dir,
+	}
+	out, err := d.Runner.Output(ctx, revs)
+	if err != nil {
+		return "", fmt.Errorf("list upstream revisions: %w", err)
+	}
+	sum := sha256.Sum256([]byte(out))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// runSchedule runs the schedule's pipeline, or builds its target across its
+// devices like 'build --devices'.
+func (d *Daemon) runSchedule(ctx context.Context, schedule config.Schedule) error {
+	if schedule.Pipeline != "" {
+		_, err := pipeline.Execute(ctx, d.Runner, d.Config, schedule.Pipeline, pipeline.Options{DryRun: d.DryRun}, nil)
+		return err
+	}
+
+	var targets []string
+	for _, target := range strings.Split(schedule.Target, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets = append(targets, target)
+		}
+	}
+	jobs, err := android.PlanFleet(d.Config, d.Devices(schedule), targets)
+	if err != nil {
+		return err
+	}
+	var failures []string
+	for _, result := range android.RunFleet(ctx, d.Runner, d.Config, jobs, android.BuildOptions{DryRun: d.DryRun}, 1, nil) {
+		if result.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s/%s: %v", result.Device, result.Target, result.Err))
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func queued(queue []Job, schedule string) bool {
+	for _, job := range queue {
+		if job.Schedule == schedule {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/koobie777/ark-android-forge/internal/android"
+	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/lock"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// newTestDaemon returns a daemon with a fake clock whose Execute records the
+// schedules it ran. The host lock is isolated in a per-test temp dir.
+func newTestDaemon(t *testing.T, schedules ...config.Schedule) (*Daemon, *fakeClock, *[]string) {
+	t.Helper()
+	t.Setenv("TMPDIR", t.TempDir())
+	cfg := config.Default()
+	cfg.Build.Workspace = t.TempDir()
+	cfg.Schedules = schedules
+
+	clock := &fakeClock{now: time.Date(2024, 6, 7, 12, 0, 0, 0, time.UTC)}
+	var ran []string
+	d := New(cfg, nil, zerolog.Nop())
+	d.Clock = clock
+	d.Execute = func(ctx context.Context, schedule config.Schedule) error {
+		ran = append(ran, schedule.Name+"@"+clock.Now().Format("01-02 15:04"))
+		return nil
+	}
+	return d, clock, &ran
+}
+
+func TestTickCatchesUpMissedRun(t *testing.T) {
+	d, clock, ran := newTestDaemon(t, config.Schedule{Name: "nightly", Cron: "@daily"})
+	ctx := context.Background()
+
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ran) != 0 {
+		t.Fatalf("first tick ran %v", *ran)
+	}
+
+	// The daemon was down across three midnights: one catch-up run, not three.
+	clock.now = clock.now.Add(72 * time.Hour)
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ran) != 1 || (*ran)[0] != "nightly@06-10 12:00" {
+		t.Fatalf("ran %v, want one catch-up run", *ran)
+	}
+	state, err := LoadState(d.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Queue) != 0 || !state.LastTick.Equal(clock.now) {
+		t.Fatalf("state = %+v", state)
+	}
+	if _, ok := state.Success["nightly"]; !ok {
+		t.Fatalf("success not recorded: %+v", state.Success)
+	}
+
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ran) != 1 {
+		t.Fatalf("tick without a new activation ran %v", *ran)
+	}
+}
+
+func TestTickSkipsWhileHostLocked(t *testing.T) {
+	d, clock, ran := newTestDaemon(t, config.Schedule{Name: "hourly", Cron: "0 * * * *"})
+	ctx := context.Background()
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A manual build in another process holds the host lock.
+	held, err := lock.TryAcquire(android.HostLockPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.now = clock.now.Add(time.Hour)
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ran) != 0 {
+		t.Fatalf("ran %v while a build held the host lock", *ran)
+	}
+	state, err := LoadState(d.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Queue) != 1 || state.Queue[0].Schedule != "hourly" {
+		t.Fatalf("queue = %+v, want the job kept", state.Queue)
+	}
+
+	held.Release()
+	clock.now = clock.now.Add(time.Minute)
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ran) != 1 {
+		t.Fatalf("ran %v after the lock was released", *ran)
+	}
+}
+
+func TestTickSkipsWhileAnotherDaemonTicks(t *testing.T) {
+	d, clock, ran := newTestDaemon(t, config.Schedule{Name: "hourly", Cron: "0 * * * *"})
+	ctx := context.Background()
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	before, err := LoadState(d.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held, err := lock.TryAcquire(LockPath(d.Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.now = clock.now.Add(time.Hour)
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	after, err := LoadState(d.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*ran) != 0 || len(after.Queue) != 0 || !after.LastTick.Equal(before.LastTick) {
+		t.Fatalf("tick touched locked state: ran %v, state %+v", *ran, after)
+	}
+
+	// Once the other daemon is done, the activation is caught up.
+	held.Release()
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ran) != 1 {
+		t.Fatalf("ran %v after the daemon lock was released", *ran)
+	}
+}
+
+func TestJobBuildsShareDaemonHostLock(t *testing.T) {
+	d, clock, _ := newTestDaemon(t, config.Schedule{Name: "hourly", Cron: "0 * * * *"})
+	buildErr := errors.New("job did not run")
+	d.Execute = func(context.Context, config.Schedule) error {
+		// Builds the job starts in-process join the daemon's host lock.
+		release, err := android.AcquireHostLock()
+		if err == nil {
+			release()
+		}
+		buildErr = err
+		return err
+	}
+	ctx := context.Background()
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	clock.now = clock.now.Add(time.Hour)
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if buildErr != nil {
+		t.Fatalf("build inside the job: %v", buildErr)
+	}
+	if lock.Held(android.HostLockPath()) {
+		t.Fatal("host lock still held after the tick")
+	}
+}
+
+func TestTickDropsFailedJobWithoutSuccess(t *testing.T) {
+	d, clock, _ := newTestDaemon(t, config.Schedule{Name: "nightly", Cron: "@daily"})
+	d.Execute = func(context.Context, config.Schedule) error { return errors.New("build failed") }
+	ctx := context.Background()
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	clock.now = clock.now.Add(24 * time.Hour)
+	if err := d.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadState(d.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Queue) != 0 || len(state.Success) != 0 {
+		t.Fatalf("state = %+v", state)
+	}
+}
+
+func TestRunSleepsUntilNextActivation(t *testing.T) {
+	d, clock, ran := newTestDaemon(t, config.Schedule{Name: "quarter", Cron: "*/15 * * * *"})
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Execute = func(context.Context, config.Schedule) error {
+		*ran = append(*ran, clock.Now().Format("15:04"))
+		if len(*ran) == 3 {
+			cancel()
+		}
+		return nil
+	}
+	if err := d.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := *ran; len(got) != 3 || got[0] != "12:15" || got[1] != "12:30" || got[2] != "12:45" {
+		t.Fatalf("ran at %v", got)
+	}
+}
@@ -0,0 +1,77 @@
+// Package secret resolves passwords referenced from forge.yaml without
+// letting their values reach logs, errors or written files.
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// redacted replaces a secret's value wherever it is formatted.
+const redacted = "[redacted]"
+
+// Secret holds a resolved value. Formatting, JSON and YAML output show
+// [redacted]; Value returns the real thing.
+type Secret struct {
+	value string
+}
+
+// Value returns the secret in clear text.
+func (s Secret) Value() string {
+	return s.value
+}
+
+// Empty reports whether the reference resolved to nothing.
+func (s Secret) Empty() bool {
+	return s.value == ""
+}
+
+func (s Secret) String() string {
+	if s.value == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString keeps %#v from printing the value.
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// MarshalText redacts the value in JSON and YAML.
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Resolve reads a reference: env:NAME takes an environment variable,
+// file:PATH the first line of a file. Anything else is used literally,
+// which is only sensible for throwaway keys.
+func Resolve(ref string) (Secret, error) {
+	switch {
+	case ref == "":
+		return Secret{}, nil
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return Secret{}, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return Secret{value: value}, nil
+	case strings.HasPrefix(ref, "file:"):
+		path := strings.TrimPrefix(ref, "file:")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// The path is not secret, but the error must not quote content.
+			return Secret{}, fmt.Errorf("read secret file: %w", err)
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			return Secret{}, errors.New("secret file " + path + " is empty")
+		}
+		return Secret{value: line}, nil
+	}
+	return Secret{value: ref}, nil
+}
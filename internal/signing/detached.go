@@ -0,0 +1,192 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Detached signature formats for release checksums.
+const (
+	FormatEd25519  = "ed25519"
+	FormatMinisign = "minisign"
+)
+
+// minisign's legacy algorithm tag: Ed25519 over the message itself. The
+// prehashed "ED" variant needs BLAKE2b, which is not in the standard
+// library, so it is not produced and cannot be verified here.
+const (
+	minisignAlgorithm       = "Ed"
+	minisignPrehashed       = "ED"
+	minisignKeyIDSize       = 8
+	minisignUntrustedPrefix = "untrusted comment: "
+	minisignTrustedPrefix   = "trusted comment: "
+)
+
+// LoadPrivateKey reads a PKCS#8 PEM Ed25519 key, as written by
+// 'openssl genpkey -algorithm ed25519'.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is %T, not Ed25519", parsed)
+	}
+	return key, nil
+}
+
+// PublicKey is an Ed25519 key with the minisign key ID signatures name.
+type PublicKey struct {
+	Key ed25519.PublicKey
+	ID  []byte
+}
+
+// NewPublicKey derives the key ID from the key, so signatures made from a
+// PEM key carry a stable ID.
+func NewPublicKey(key ed25519.PublicKey) PublicKey {
+	sum := sha256.Sum256(key)
+	return PublicKey{Key: key, ID: sum[:minisignKeyIDSize]}
+}
+
+// LoadPublicKey reads a PEM public key or a minisign .pub file.
+func LoadPublicKey(path string) (PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("read public key: %w", err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return PublicKey{}, fmt.Errorf("parse public key: %w", err)
+		}
+		key, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return PublicKey{}, fmt.Errorf("public key is %T, not Ed25519", parsed)
+		}
+		return NewPublicKey(key), nil
+	}
+
+	blob, err := minisignBlob(data)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("parse minisign public key: %w", err)
+	}
+	if len(blob) != 2+minisignKeyIDSize+ed25519.PublicKeySize || string(blob[:2]) != minisignAlgorithm {
+		return PublicKey{}, errors.New("not an Ed25519 minisign public key")
+	}
+	return PublicKey{Key: ed25519.PublicKey(blob[2+minisignKeyIDSize:]), ID: blob[2 : 2+minisignKeyIDSize]}, nil
+}
+
+// PEM formats the key as a PKIX public key.
+func (k PublicKey) PEM() (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(k.Key)
+	if err != nil {
+		return "", fmt.Errorf("encode public key: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// KeyID is the key ID as minisign prints it.
+func (k PublicKey) KeyID() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.ID))
+}
+
+// Minisign formats the key as a minisign .pub file.
+func (k PublicKey) Minisign() string {
+	blob := append(append([]byte(minisignAlgorithm), k.ID...), k.Key...)
+	return fmt.Sprintf("%sminisign public key %s\n%s\n", minisignUntrustedPrefix, k.KeyID(), base64.StdEncoding.EncodeToString(blob))
+}
+
+// SignMinisign returns a minisign signature file for message. The trusted
+// comment is signed along with the signature.
+func SignMinisign(key ed25519.PrivateKey, message []byte, trustedComment string) []byte {
+	pub := NewPublicKey(key.Public().(ed25519.PublicKey))
+	signature := ed25519.Sign(key, message)
+	global := ed25519.Sign(key, append(append([]byte{}, signature...), trustedComment...))
+	blob := append(append([]byte(minisignAlgorithm), pub.ID...), signature...)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%ssignature from arkforge secret key\n", minisignUntrustedPrefix)
+	fmt.Fprintf(&out, "%s\n", base64.StdEncoding.EncodeToString(blob))
+	fmt.Fprintf(&out, "%s%s\n", minisignTrustedPrefix, trustedComment)
+	fmt.Fprintf(&out, "%s\n", base64.StdEncoding.EncodeToString(global))
+	return out.Bytes()
+}
+
+// VerifyMinisign checks a minisign signature file and its trusted comment.
+func VerifyMinisign(pub PublicKey, message, sigFile []byte) error {
+	blob, err := minisignBlob(sigFile)
+	if err != nil {
+		return fmt.Errorf("parse minisign signature: %w", err)
+	}
+	if len(blob) != 2+minisignKeyIDSize+ed25519.SignatureSize {
+		return errors.New("malformed minisign signature")
+	}
+	switch string(blob[:2]) {
+	case minisignAlgorithm:
+	case minisignPrehashed:
+		return errors.New("prehashed (BLAKE2b) minisign signatures are not supported; sign with minisign -l")
+	default:
+		return fmt.Errorf("unknown minisign algorithm %q", blob[:2])
+	}
+	if !bytes.Equal(blob[2:2+minisignKeyIDSize], pub.ID) {
+		return errors.New("signed by a different key")
+	}
+	signature := blob[2+minisignKeyIDSize:]
+	if !ed25519.Verify(pub.Key, message, signature) {
+		return errors.New("signature does not match")
+	}
+
+	lines := strings.Split(strings.TrimRight(string(sigFile), "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], minisignTrustedPrefix) {
+		return errors.New("minisign signature has no trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return fmt.Errorf("parse minisign signature: %w", err)
+	}
+	comment := strings.TrimPrefix(strings.TrimRight(lines[2], "\r"), minisignTrustedPrefix)
+	if !ed25519.Verify(pub.Key, append(append([]byte{}, signature...), comment...), global) {
+		return errors.New("trusted comment signature does not match")
+	}
+	return nil
+}
+
+// VerifyEd25519 checks a raw 64 byte Ed25519 signature.
+func VerifyEd25519(pub PublicKey, message, signature []byte) error {
+	if len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("signature is %d bytes, want %d", len(signature), ed25519.SignatureSize)
+	}
+	if !ed25519.Verify(pub.Key, message, signature) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// minisignBlob decodes the base64 line following the untrusted comment.
+func minisignBlob(data []byte) ([]byte, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r", ""), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, minisignUntrustedPrefix) && i+1 < len(lines) {
+			return base64.StdEncoding.DecodeString(strings.TrimSpace(lines[i+1]))
+		}
+	}
+	return nil, errors.New("missing untrusted comment")
+}
@@ -0,0 +1,230 @@
+// Package signing manages the key set used to sign release builds: key
+// generation compatible with AOSP's make_key, certificate fingerprints and
+// the password file the signing tools read.
+package signing
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koobie777/ark-android-forge/internal/execx"
+	"github.com/koobie777/ark-android-forge/internal/secret"
+)
+
+// ReleaseKey signs the OTA package itself.
+const ReleaseKey = "releasekey"
+
+// DefaultKeys is the key set LineageOS signs builds with.
+var DefaultKeys = []string{
+	"bluetooth", "media", "networkstack", "nfc", "platform", ReleaseKey,
+	"sdk_sandbox", "shared", "testcert", "testkey", "verity",
+}
+
+// DefaultSubject is make_key's example subject.
+const DefaultSubject = "/C=US/ST=California/L=Mountain View/O=Android/OU=Android/CN=Android/emailAddress=android@android.com"
+
+// make_key's parameters: RSA 2048 with F4, SHA-256, 10000 days.
+const (
+	keyBits      = 2048
+	validityDays = 10000
+)
+
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// GenerateOptions configures Generate. Names defaults to DefaultKeys and
+// Subject to DefaultSubject.
+type GenerateOptions struct {
+	Dir      string
+	Names    []string
+	Subject  string
+	Password secret.Secret
+}
+
+// Generate writes <name>.x509.pem and <name>.pk8 for every key, like
+// make_key. With a password the PKCS#8 key is encrypted by openssl, which
+// reads the password from its environment. Existing keys are never
+// overwritten.
+func Generate(ctx context.Context, runner *execx.Runner, opts GenerateOptions) ([]string, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("key directory is required")
+	}
+	names := opts.Names
+	if len(names) == 0 {
+		names = DefaultKeys
+	}
+	subject, err := ParseSubject(cmp.Or(opts.Subject, DefaultSubject))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		for _, path := range []string{CertPath(opts.Dir, name), KeyPath(opts.Dir, name)} {
+			if _, err := os.Stat(path); err == nil {
+				return nil, fmt.Errorf("%s already exists; refusing to overwrite a key set", path)
+			}
+		}
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create key dir: %w", err)
+	}
+
+	var written []string
+	for _, name := range names {
+		if err := generateKey(ctx, runner, opts.Dir, name, subject, opts.Password); err != nil {
+			return written, fmt.Errorf("%s: %w", name, err)
+		}
+		written = append(written, name)
+	}
+	return written, nil
+}
+
+func generateKey(ctx context.Context, runner *execx.Runner, dir, name string, subject pkix.Name, password secret.Secret) error {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return fmt.Errorf("generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 159))
+	if err != nil {
+		return fmt.Errorf("generate serial: %w", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             now,
+		NotAfter:              now.AddDate(0, 0, validityDays),
+		SignatureAlgorithm:    x509.SHA256WithRSA,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("create certificate: %w", err)
+	}
+	pk8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("encode key: %w", err)
+	}
+	if !password.Empty() {
+		if pk8, err = encryptPKCS8(ctx, runner, pk8, password); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(KeyPath(dir, name), pk8, 0o600); err != nil {
+		return fmt.Errorf("write key: %w", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	if err := os.WriteFile(CertPath(dir, name), certPEM, 0o644); err != nil {
+		return fmt.Errorf("write certificate: %w", err)
+	}
+	return nil
+}
+
+// encryptPKCS8 has openssl wrap a DER key. The password travels through
+// the child's environment, never its arguments.
+func encryptPKCS8(ctx context.Context, runner *execx.Runner, der []byte, password secret.Secret) ([]byte, error) {
+	const passwordEnv = "ARK_SIGNING_PASSWORD"
+	cmd := execx.Command{
+		Name: "openssl",
+		Args: []string{"pkcs8", "-topk8", "-inform", "DER", "-outform", "DER", "-passout", "env:" + passwordEnv},
+		Env:  map[string]string{passwordEnv: password.Value()},
+	}
+	var out bytes.Buffer
+	if err := runner.Pipe(ctx, cmd, bytes.NewReader(der), &out); err != nil {
+		return nil, fmt.Errorf("encrypt key: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// ParseSubject reads an openssl style subject (/C=US/O=Android/CN=...).
+func ParseSubject(subject string) (pkix.Name, error) {
+	var name pkix.Name
+	for _, part := range strings.Split(strings.Trim(subject, "/"), "/") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return pkix.Name{}, fmt.Errorf("invalid subject component %q", part)
+		}
+		switch key {
+		case "C":
+			name.Country = append(name.Country, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "CN":
+			name.CommonName = value
+		case "emailAddress":
+			name.ExtraNames = append(name.ExtraNames, pkix.AttributeTypeAndValue{Type: oidEmailAddress, Value: value})
+		default:
+			return pkix.Name{}, fmt.Errorf("unsupported subject component %q", key)
+		}
+	}
+	return name, nil
+}
+
+// CertPath locates the certificate of a key of the set.
+func CertPath(dir, name string) string { return filepath.Join(dir, name+".x509.pem") }
+
+// KeyPath locates the PKCS#8 private key of a key of the set.
+func KeyPath(dir, name string) string { return filepath.Join(dir, name+".pk8") }
+
+// LoadCertificate reads a PEM or DER certificate.
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read certificate: %w", err)
+	}
+	return ParseCertificate(data)
+}
+
+// ParseCertificate accepts PEM or DER.
+func ParseCertificate(data []byte) (*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// Fingerprint is the hex SHA-256 of the certificate's DER encoding, the
+// digest apksigner prints.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// KeyNames lists the keys in dir, by their certificates.
+func KeyNames(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.x509.pem"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(match), ".x509.pem"))
+	}
+	return names, nil
+}
@@ -0,0 +1,39 @@
+package signing
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/koobie777/ark-android-forge/internal/secret"
+)
+
+// PasswordFileEnv names the file releasetools read key passwords from
+// instead of prompting.
+const PasswordFileEnv = "ANDROID_PW_FILE"
+
+// PasswordFile writes a private ANDROID_PW_FILE giving every key in dir the
+// password, in the "[[[ password ]]] dir/key" format of releasetools'
+// PasswordManager. The caller removes it once signing is done.
+func PasswordFile(dir string, password secret.Secret) (string, error) {
+	names, err := KeyNames(dir)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp("", "arkforge-pw-*")
+	if err != nil {
+		return "", fmt.Errorf("create password file: %w", err)
+	}
+	for _, name := range names {
+		if _, err := fmt.Fprintf(file, "[[[ %s ]]] %s\n", password.Value(), filepath.ToSlash(filepath.Join(dir, name))); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return "", fmt.Errorf("write password file: %w", err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("write password file: %w", err)
+	}
+	return file.Name(), nil
+}
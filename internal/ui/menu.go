@@ -12,11 +12,13 @@ import (
 
 	"github.com/rs/zerolog"
 
+	"github.com/koobie777/ark-android-forge/internal/android"
 	"github.com/koobie777/ark-android-forge/internal/config"
+	"github.com/koobie777/ark-android-forge/internal/execx"
 )
 
 // RunMenu renders the interactive ARKFORGE menu that previously lived in bash.
-func RunMenu(ctx context.Context, cfg *config.Config, logger zerolog.Logger) error {
+func RunMenu(ctx context.Context, cfg *config.Config, logger zerolog.Logger, runner *execx.Runner) error {
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
@@ -38,13 +40,13 @@ func RunMenu(ctx context.Context, cfg *config.Config, logger zerolog.Logger) err
 		case "1":
 			logger.Info().Msg("Smart Build module is being ported to Go; stay tuned.")
 		case "2":
-			logger.Warn().Msg("Recovery Build is still in development.")
+			runRecoveryBuild(ctx, reader, cfg, logger, runner)
 		case "3":
-			logger.Info().Msg("ROM Build module is being ported to Go; stay tuned.")
+			runROMBuild(ctx, reader, cfg, logger, runner)
 		case "4":
-			logger.Info().Msg("Boot/Recovery builder will be available after module rewrite.")
+			runImageBuild(ctx, reader, cfg, logger, runner)
 		case "5":
-			logger.Info().Msg("Resume build is not yet implemented in Go.")
+			runResume(ctx, reader, cfg, logger, runner)
 		case "6":
 			logger.Info().Msg("Repo sync only flow is available via 'sync' command.")
 		case "7":
@@ -73,6 +75,112 @@ func RunMenu(ctx context.Context, cfg *config.Config, logger zerolog.Logger) err
 	}
 }
 
+func runROMBuild(ctx context.Context, reader *bufio.Reader, cfg *config.Config, logger zerolog.Logger, runner *execx.Runner) {
+	opts := android.BuildOptions{
+		Device:  prompt(reader, "Device codename", primaryCodename(cfg)),
+		Variant: prompt(reader, "Build variant", "userdebug"),
+	}
+	result, err := android.BuildROM(ctx, runner, cfg, opts)
+	if err != nil {
+		logger.Error().Err(err).Msg("ROM build failed")
+		return
+	}
+	logger.Info().Str("package", result.Package).Strs("images", result.Images).Msg("ROM build complete")
+}
+
+func runRecoveryBuild(ctx context.Context, reader *bufio.Reader, cfg *config.Config, logger zerolog.Logger, runner *execx.Runner) {
+	device := prompt(reader, "Device codename", primaryCodename(cfg))
+	flavor := "twrp"
+	if fleetDevice := cfg.DeviceByCodename(device); fleetDevice != nil && fleetDevice.Recovery.Flavor != "" {
+		flavor = fleetDevice.Recovery.Flavor
+	}
+	opts := android.RecoveryOptions{
+		Build:  android.BuildOptions{Device: device},
+		Flavor: prompt(reader, "Recovery flavor (twrp, orangefox)", flavor),
+		Init:   true,
+	}
+
+	result, err := android.BuildRecovery(ctx, runner, cfg, opts)
+	if err != nil {
+		logger.Error().Err(err).Msg("recovery build failed")
+		return
+	}
+	logger.Info().Str("flavor", result.Flavor).Str("package", result.Package).Msg("recovery build complete")
+}
+
+func runResume(ctx context.Context, reader *bufio.Reader, cfg *config.Config, logger zerolog.Logger, runner *execx.Runner) {
+	builds, err := android.Interrupted(cfg)
+	if err != nil {
+		logger.Error().Err(err).Msg("scan for interrupted builds failed")
+		return
+	}
+	var resumable []android.InterruptedBuild
+	for _, build := range builds {
+		if build.Resumable() {
+			resumable = append(resumable, build)
+		}
+	}
+	if len(resumable) == 0 {
+		fmt.Println("No resumable builds found in the workspace.")
+		return
+	}
+
+	for i, build := range resumable {
+		fmt.Printf("  %d) %s [%s] %s, last activity %s\n", i+1, build.Tree.Dir, build.State.Mode,
+			build.State.Combo, build.LastActivity.Format("2006-01-02 15:04"))
+	}
+	choice := prompt(reader, "Resume which build (0 to cancel)", "1")
+	var index int
+	if _, err := fmt.Sscanf(choice, "%d", &index); err != nil || index < 1 || index > len(resumable) {
+		return
+	}
+	if err := android.Resume(ctx, runner, cfg, resumable[index-1], false); err != nil {
+		logger.Error().Err(err).Msg("resumed build failed")
+	}
+}
+
+func runImageBuild(ctx context.Context, reader *bufio.Reader, cfg *config.Config, logger zerolog.Logger, runner *execx.Runner) {
+	opts := android.BuildOptions{
+		Device:  prompt(reader, "Device codename", primaryCodename(cfg)),
+		Variant: prompt(reader, "Build variant", "userdebug"),
+	}
+	var images []android.Image
+	for _, name := range strings.Split(prompt(reader, "Images (boot,recovery,vendor_boot,init_boot)", "boot,recovery"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			images = append(images, android.Image(name))
+		}
+	}
+
+	results, err := android.BuildImages(ctx, runner, cfg, opts, images)
+	for _, result := range results {
+		logger.Info().Str("image", string(result.Image)).Int64("bytes", result.Size).Str("path", result.Path).Msg("image ready")
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("image build failed")
+	}
+}
+
+func prompt(reader *bufio.Reader, label, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", label, def)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	input, err := reader.ReadString('\n')
+	input = strings.TrimSpace(input)
+	if err != nil || input == "" {
+		return def
+	}
+	return input
+}
+
+func primaryCodename(cfg *config.Config) string {
+	if len(cfg.Fleet) > 0 {
+		return cfg.Fleet[0].Codename
+	}
+	return ""
+}
+
 func renderMenu(cfg *config.Config) {
 	clearScreen()
 	now := time.Now().UTC().Format("2006-01-02 15:04:05")
@@ -129,7 +237,7 @@ func showUserGuide() {
 	fmt.Println("The Go rewrite consolidates the bash modules into:")
 	fmt.Println("- 'preflight' : Validate host prerequisites (Java, repo, ulimit, disk)")
 	fmt.Println("- 'sync'      : Manage repo sync operations with manifests")
-	fmt.Println("- 'build'     : Launch modular builds per device (coming soon)")
+	fmt.Println("- 'build'     : Launch builds per device ('build rom' for full OTA packages)")
 	fmt.Println("- 'release'   : Package artifacts and manifests (coming soon)")
 	fmt.Println("Refer to README.md for detailed setup instructions.")
 	fmt.Println("==============================================")